@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"0xygen.thesphere.online/blockchain/core"
+	"0xygen.thesphere.online/blockchain/rpc"
+)
+
+// initChain creates a new chain in a data directory from a genesis file
+func initChain(args []string) error {
+	flags := newFlagSet("init")
+	datadir := flags.String("datadir", defaultDataDir, "directory to create the chain in")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected exactly one genesis file")
+	}
+
+	genesis, err := core.LoadGenesis(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	exists, err := hasBlocks(*datadir)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("%s already holds a chain", *datadir)
+	}
+
+	if err := os.MkdirAll(*datadir, 0o755); err != nil {
+		return fmt.Errorf("failed to create data directory: %v", err)
+	}
+	if err := genesis.Save(filepath.Join(*datadir, genesisFileName)); err != nil {
+		return fmt.Errorf("failed to save genesis file: %v", err)
+	}
+
+	chain, err := openChain(*datadir, 0)
+	if err != nil {
+		return err
+	}
+	defer chain.Close()
+
+	fmt.Printf("Initialised chain in %s\nGenesis hash: %s\n", *datadir, chain.GetBlock(0).Hash)
+	return nil
+}
+
+// printStatus prints the tip of the stored chain, or of a running node
+func printStatus(args []string) error {
+	flags := newFlagSet("status")
+	datadir := flags.String("datadir", defaultDataDir, "directory holding the chain")
+	rpcURL := flags.String("rpc", "", "query a running node at this JSON-RPC URL instead")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var info *rpc.ChainInfo
+	if *rpcURL != "" {
+		client, err := rpc.Dial(*rpcURL)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if info, err = client.ChainInfo(ctx); err != nil {
+			return err
+		}
+	} else {
+		chain, err := openExistingChain(*datadir)
+		if err != nil {
+			return err
+		}
+		defer chain.Close()
+
+		tip := chain.LastBlock()
+		info = &rpc.ChainInfo{
+			ChainID:        chain.Config.ChainID,
+			Height:         tip.Index,
+			TipHash:        tip.Hash,
+			GenesisHash:    chain.GetBlock(0).Hash,
+			NextDifficulty: chain.NextDifficulty(),
+			Work:           chain.CumulativeWork().String(),
+			Supply:         chain.Supply(),
+			Pending:        len(chain.Pending()),
+		}
+	}
+
+	fmt.Printf("Chain ID:        %d\n", info.ChainID)
+	fmt.Printf("Height:          %d\n", info.Height)
+	fmt.Printf("Tip hash:        %s\n", info.TipHash)
+	fmt.Printf("Genesis hash:    %s\n", info.GenesisHash)
+	fmt.Printf("Next difficulty: %d\n", info.NextDifficulty)
+	fmt.Printf("Total work:      %s\n", info.Work)
+	fmt.Printf("Supply:          %v\n", info.Supply)
+	fmt.Printf("Pending:         %d\n", info.Pending)
+	return nil
+}
+
+// validateChain re-validates every stored block from genesis and reports
+// every rule violation, without stopping at the first
+func validateChain(args []string) error {
+	flags := newFlagSet("validate")
+	datadir := flags.String("datadir", defaultDataDir, "directory holding the chain")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	exists, err := hasBlocks(*datadir)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("no chain in %s; run spherenode init or spherenode run first", *datadir)
+	}
+	config, err := loadConfig(*datadir, 0)
+	if err != nil {
+		return err
+	}
+
+	// Read the blocks directly so a corrupted chain can still be inspected
+	store, err := core.NewReadOnlyFileStore(*datadir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	blocks, err := store.LoadBlocks()
+	if err != nil {
+		return err
+	}
+
+	report := core.ValidateBlocks(config, blocks)
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		for _, violation := range report.Errors {
+			fmt.Printf("[%s] %v\n", violation.Rule, violation)
+		}
+	}
+
+	if !report.Valid() {
+		return fmt.Errorf("%d rule violations in %d blocks", len(report.Errors), report.Blocks)
+	}
+	if !*asJSON {
+		fmt.Printf("Chain is valid: %d blocks, tip %s\n", len(blocks), blocks[len(blocks)-1].Hash)
+	}
+	return nil
+}
+
+// exportBlocks writes a range of stored blocks to a file or stdout
+func exportBlocks(args []string) error {
+	flags := newFlagSet("export")
+	datadir := flags.String("datadir", defaultDataDir, "directory holding the chain")
+	from := flags.Int64("from", 0, "first block index to export")
+	to := flags.Int64("to", -1, "last block index to export, -1 for the tip")
+	format := flags.String("format", "json", "output format: json or binary")
+	out := flags.String("out", "", "output file, stdout when empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *format != "json" && *format != "binary" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	chain, err := openExistingChain(*datadir)
+	if err != nil {
+		return err
+	}
+	defer chain.Close()
+
+	blocks := chain.GetBlocks(*from)
+	if *to >= 0 {
+		for len(blocks) > 0 && blocks[len(blocks)-1].Index > *to {
+			blocks = blocks[:len(blocks)-1]
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %v", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if *format == "binary" {
+		_, err = w.Write(core.EncodeBlocks(blocks))
+	} else {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(blocks)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write blocks: %v", err)
+	}
+
+	if *out != "" {
+		fmt.Fprintf(os.Stderr, "Exported %d blocks to %s\n", len(blocks), *out)
+	}
+	return nil
+}
+
+// openExistingChain opens the chain in datadir for reading, failing if
+// there is none. Nothing is written, so a node may be running on datadir.
+func openExistingChain(datadir string) (*core.Blockchain, error) {
+	exists, err := hasBlocks(datadir)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("no chain in %s; run spherenode init or spherenode run first", datadir)
+	}
+
+	store, err := core.NewReadOnlyFileStore(datadir)
+	if err != nil {
+		return nil, err
+	}
+	return openChainWith(store, datadir, 0)
+}
@@ -0,0 +1,147 @@
+// Command spherenode runs and inspects a core chain node.
+//
+// Usage:
+//
+//	spherenode [run] [flags]             run a node
+//	spherenode init [flags] genesis.json create a chain from a genesis file
+//	spherenode status [flags]            print the chain tip
+//	spherenode validate [flags]          re-validate the stored chain
+//	spherenode export [flags]            write blocks as JSON or binary
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"0xygen.thesphere.online/blockchain/core"
+)
+
+// genesisFileName is where init keeps a copy of the genesis file
+const genesisFileName = "genesis.json"
+
+// defaultDataDir is used when -datadir is not given
+const defaultDataDir = "spheredata"
+
+func main() {
+	args := os.Args[1:]
+	command := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	var err error
+	switch command {
+	case "run":
+		err = runNode(args)
+	case "init":
+		err = initChain(args)
+	case "status":
+		err = printStatus(args)
+	case "validate":
+		err = validateChain(args)
+	case "export":
+		err = exportBlocks(args)
+	case "help":
+		usage()
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "spherenode: unknown command %q\n\n", command)
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "spherenode %s: %v\n", command, err)
+		os.Exit(1)
+	}
+}
+
+// usage prints the list of subcommands
+func usage() {
+	fmt.Fprint(os.Stderr, `Usage: spherenode <command> [flags]
+
+Commands:
+  run       run a node (the default)
+  init      create a chain from a genesis file
+  status    print the chain tip
+  validate  re-validate the stored chain
+  export    write blocks as JSON or binary
+
+Run "spherenode <command> -h" for the flags of a command.
+`)
+}
+
+// newFlagSet creates the flag set of a subcommand
+func newFlagSet(command string) *flag.FlagSet {
+	return flag.NewFlagSet("spherenode "+command, flag.ContinueOnError)
+}
+
+// openChain opens the chain stored in datadir. The genesis file written by
+// init fixes the chain's config; without one a fresh chain is created with
+// the given difficulty, or the default when it is zero.
+func openChain(datadir string, difficulty uint64) (*core.Blockchain, error) {
+	store, err := core.NewFileStore(datadir)
+	if err != nil {
+		return nil, err
+	}
+	return openChainWith(store, datadir, difficulty)
+}
+
+// openChainWith opens the chain in a store that was opened on datadir
+func openChainWith(store *core.FileStore, datadir string, difficulty uint64) (*core.Blockchain, error) {
+	config, err := loadConfig(datadir, difficulty)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	chain, err := core.OpenBlockchain(store, config)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return chain, nil
+}
+
+// loadConfig returns the chain config fixed by the genesis file in datadir,
+// or the defaults with the given difficulty when there is none
+func loadConfig(datadir string, difficulty uint64) (core.Config, error) {
+	config := core.DefaultConfig()
+
+	genesisPath := filepath.Join(datadir, genesisFileName)
+	if _, err := os.Stat(genesisPath); err == nil {
+		genesis, err := core.LoadGenesis(genesisPath)
+		if err != nil {
+			return config, err
+		}
+		if difficulty != 0 && difficulty != genesis.Difficulty {
+			return config, fmt.Errorf("difficulty is fixed at %d by %s", genesis.Difficulty, genesisPath)
+		}
+		config = genesis.Config()
+	} else if difficulty != 0 {
+		config.Difficulty = difficulty
+	}
+	return config, nil
+}
+
+// hasBlocks reports whether datadir already holds a chain
+func hasBlocks(datadir string) (bool, error) {
+	store, err := core.NewReadOnlyFileStore(datadir)
+	if err != nil {
+		return false, err
+	}
+	defer store.Close()
+
+	blocks, err := store.LoadBlocks()
+	if err != nil {
+		return false, err
+	}
+	return len(blocks) > 0, nil
+}
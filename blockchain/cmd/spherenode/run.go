@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"0xygen.thesphere.online/blockchain/core"
+	"0xygen.thesphere.online/blockchain/p2p"
+	"0xygen.thesphere.online/blockchain/rpc"
+	"0xygen.thesphere.online/blockchain/wallet"
+)
+
+// hashRateLogInterval is how often a mining node logs its hash rate
+const hashRateLogInterval = time.Minute
+
+// runNode starts a node and serves it until interrupted
+func runNode(args []string) error {
+	flags := newFlagSet("run")
+	datadir := flags.String("datadir", defaultDataDir, "directory holding the chain")
+	listen := flags.String("listen", "127.0.0.1:7000", "address for peer connections")
+	rpcAddr := flags.String("rpc", "127.0.0.1:8545", "address for the JSON-RPC server, empty to disable")
+	peers := flags.String("peers", "", "comma-separated peer URLs, e.g. http://10.0.0.2:7000")
+	minerAddress := flags.String("miner", "", "mine blocks paying rewards to this address")
+	difficulty := flags.Uint64("difficulty", 0, "genesis difficulty for a new chain without a genesis file")
+	signer := flags.String("signer", "", "seal proof-of-authority blocks with this keystore account")
+	keystoreDir := flags.String("keystore", "keystore", "keystore directory holding the signer key")
+	passfile := flags.String("passfile", "", "file holding the signer key's passphrase")
+	threads := flags.Int("threads", 0, "proof-of-work mining goroutines, 0 for one per CPU")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+
+	if *minerAddress != "" {
+		address, err := wallet.ParseAddress(*minerAddress)
+		if err != nil {
+			return fmt.Errorf("invalid miner address: %v", err)
+		}
+		*minerAddress = address
+	}
+
+	chain, err := openChain(*datadir, *difficulty)
+	if err != nil {
+		return err
+	}
+	defer chain.Close()
+
+	if *signer != "" {
+		address, err := authorizeSigner(chain, *signer, *keystoreDir, *passfile)
+		if err != nil {
+			return err
+		}
+		if *minerAddress == "" {
+			*minerAddress = address
+		}
+		log.Printf("Sealing blocks as %s", address)
+	}
+
+	if pow, ok := chain.Engine().(*core.PoW); ok {
+		pow.SetThreads(*threads)
+	}
+
+	tip := chain.LastBlock()
+	log.Printf("Opened chain in %s at height %d (%s)", *datadir, tip.Index, tip.Hash)
+
+	node := p2p.NewNode(chain, *listen)
+	if err := node.Start(); err != nil {
+		return err
+	}
+	defer node.Stop()
+	log.Printf("Listening for peers at %s", node.Address())
+
+	if *peers != "" {
+		if err := node.Connect(splitList(*peers)...); err != nil {
+			// Peers may come up later and will sync us when they announce blocks
+			log.Printf("Failed to reach some peers: %v", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := rpc.NewServer(chain, node)
+	defer server.Close()
+
+	if *rpcAddr != "" {
+		listener, err := net.Listen("tcp", *rpcAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %v", *rpcAddr, err)
+		}
+		httpServer := &http.Server{Handler: server}
+		// Subscription streams never finish on their own
+		httpServer.RegisterOnShutdown(server.Close)
+		go func() {
+			if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("RPC server stopped: %v", err)
+			}
+		}()
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			httpServer.Shutdown(shutdownCtx)
+		}()
+		log.Printf("Serving JSON-RPC at http://%s", listener.Addr())
+	}
+
+	if *minerAddress != "" {
+		miner := node.StartMining(ctx, *minerAddress)
+		defer miner.Wait()
+		log.Printf("Mining to %s", *minerAddress)
+		if pow, ok := chain.Engine().(*core.PoW); ok {
+			log.Printf("Mining with %d threads", pow.Threads())
+			go logHashRate(ctx, miner)
+		}
+	}
+
+	<-ctx.Done()
+	log.Printf("Shutting down")
+	return nil
+}
+
+// logHashRate periodically logs the miner's hash rate until ctx is cancelled
+func logHashRate(ctx context.Context, miner *core.Miner) {
+	ticker := time.NewTicker(hashRateLogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			status := miner.Status()
+			log.Printf("Mining at %.0f H/s, %d blocks mined", status.HashRate, status.BlocksMined)
+		}
+	}
+}
+
+// authorizeSigner unlocks a keystore account and makes it the key the
+// chain's proof-of-authority engine seals with
+func authorizeSigner(chain *core.Blockchain, signer, keystoreDir, passfile string) (string, error) {
+	engine, ok := chain.Engine().(*core.PoA)
+	if !ok {
+		return "", fmt.Errorf("-signer needs a proof-of-authority genesis file")
+	}
+	address, err := wallet.ParseAddress(signer)
+	if err != nil {
+		return "", fmt.Errorf("invalid signer address: %v", err)
+	}
+	if passfile == "" {
+		return "", fmt.Errorf("-signer needs -passfile")
+	}
+	passphrase, err := os.ReadFile(passfile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase file: %v", err)
+	}
+
+	keystore, err := wallet.NewKeystore(keystoreDir, wallet.StandardScryptN, wallet.StandardScryptP)
+	if err != nil {
+		return "", err
+	}
+	key, err := keystore.Unlock(address, strings.TrimRight(strings.SplitN(string(passphrase), "\n", 2)[0], "\r"))
+	if err != nil {
+		return "", fmt.Errorf("failed to unlock signer: %v", err)
+	}
+	if err := engine.Authorize(key.PrivateKey); err != nil {
+		return "", err
+	}
+	return address, nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
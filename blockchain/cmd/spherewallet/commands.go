@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"0xygen.thesphere.online/blockchain/core"
+	"0xygen.thesphere.online/blockchain/light"
+	"0xygen.thesphere.online/blockchain/rpc"
+	"0xygen.thesphere.online/blockchain/wallet"
+)
+
+// newAccount creates and stores a new key
+func newAccount(args []string) error {
+	flags, ks := newFlagSet("new")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	keystore, err := ks.open()
+	if err != nil {
+		return err
+	}
+	passphrase, err := ks.passphrase(true)
+	if err != nil {
+		return err
+	}
+
+	account, err := keystore.NewAccount(passphrase)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Address:  %s\nKey file: %s\n", wallet.ChecksumAddress(account.Address), account.File)
+	return nil
+}
+
+// listAccounts prints every stored account
+func listAccounts(args []string) error {
+	flags, ks := newFlagSet("list")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	keystore, err := ks.open()
+	if err != nil {
+		return err
+	}
+	accounts, err := keystore.Accounts()
+	if err != nil {
+		return err
+	}
+
+	for i, account := range accounts {
+		fmt.Printf("#%d: %s %s\n", i, wallet.ChecksumAddress(account.Address), account.File)
+	}
+	return nil
+}
+
+// importAccount stores a key read from a hex private key file or a keystore file
+func importAccount(args []string) error {
+	flags, ks := newFlagSet("import")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected exactly one key file")
+	}
+	path := flags.Arg(0)
+
+	keystore, err := ks.open()
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read key file: %v", err)
+	}
+
+	var account wallet.Account
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		// An encrypted keystore file keeps its passphrase
+		passphrase, err := ks.passphrase(false)
+		if err != nil {
+			return err
+		}
+		account, err = keystore.ImportFile(path, passphrase)
+		if err != nil {
+			return err
+		}
+	} else {
+		key, err := wallet.KeyFromHex(string(data))
+		if err != nil {
+			return err
+		}
+		passphrase, err := ks.passphrase(true)
+		if err != nil {
+			return err
+		}
+		account, err = keystore.Import(key, passphrase)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Imported %s\n", wallet.ChecksumAddress(account.Address))
+	return nil
+}
+
+// publicKey prints the public key of a stored account, for building
+// multisig accounts
+func publicKey(args []string) error {
+	flags, ks := newFlagSet("pubkey")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected exactly one address")
+	}
+
+	key, err := unlock(ks, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Println(key.PublicKey())
+	return nil
+}
+
+// multisigAccount prints the address of an M-of-N account and the multisig
+// definition that transactions from it must carry
+func multisigAccount(args []string) error {
+	flags := flag.NewFlagSet("spherewallet multisig", flag.ContinueOnError)
+	threshold := flags.Int("threshold", 0, "number of keys needed to spend")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	multisig, err := core.ParseMultisig(*threshold, flags.Args())
+	if err != nil {
+		return err
+	}
+	address, err := multisig.Address()
+	if err != nil {
+		return err
+	}
+	definition, err := json.Marshal(multisig)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Address:  %s\nMultisig: %s\n", wallet.ChecksumAddress(address), definition)
+	return nil
+}
+
+// signTransaction signs a JSON transaction read from a file or stdin and
+// prints the signed transaction. A transaction carrying a multisig account
+// gets this key's signature added to those it already has.
+func signTransaction(args []string) error {
+	flags, ks := newFlagSet("sign")
+	from := flags.String("from", "", "address to sign with")
+	in := flags.String("in", "", "transaction JSON file, stdin when empty")
+	chainID := flags.Uint64("chainid", 0, "chain ID to sign for, overriding the transaction's")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" {
+		return fmt.Errorf("-from is required")
+	}
+
+	var data []byte
+	var err error
+	if *in == "" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(*in)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read transaction: %v", err)
+	}
+
+	var tx core.Transaction
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&tx); err != nil {
+		return fmt.Errorf("invalid transaction JSON: %v", err)
+	}
+	if *chainID != 0 {
+		tx.ChainID = *chainID
+	}
+	if tx.Timestamp == 0 {
+		tx.Timestamp = time.Now().Unix()
+	}
+
+	key, err := unlock(ks, *from)
+	if err != nil {
+		return err
+	}
+	if tx.Multisig != nil {
+		err = key.CosignTransaction(&tx)
+	} else {
+		err = key.SignTransaction(&tx)
+	}
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(tx)
+}
+
+// sendTransaction signs a coin transfer and submits it to a node
+func sendTransaction(args []string) error {
+	flags, ks := newFlagSet("send")
+	rpcURL := flags.String("rpc", "http://127.0.0.1:8545", "JSON-RPC URL of the node")
+	from := flags.String("from", "", "sending address")
+	to := flags.String("to", "", "receiving address")
+	amount := flags.Float64("amount", 0, "amount to send")
+	fee := flags.Float64("fee", 0, "fee paid to the miner")
+	nonce := flags.Int64("nonce", -1, "sender nonce, -1 to look it up")
+	lockHeight := flags.Int64("lockheight", 0, "block index before which the transfer cannot be mined")
+	lockTime := flags.Int64("locktime", 0, "Unix time before which the transfer cannot be mined")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" {
+		return fmt.Errorf("-from and -to are required")
+	}
+
+	client, err := rpc.Dial(*rpcURL)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	key, err := unlock(ks, *from)
+	if err != nil {
+		return err
+	}
+
+	info, err := client.ChainInfo(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx := core.Transaction{
+		From:       key.Address,
+		To:         *to,
+		Amount:     *amount,
+		Fee:        *fee,
+		ChainID:    info.ChainID,
+		Timestamp:  time.Now().Unix(),
+		LockHeight: *lockHeight,
+		LockTime:   *lockTime,
+	}
+	if *nonce >= 0 {
+		tx.Nonce = uint64(*nonce)
+	} else if tx.Nonce, err = nextNonce(ctx, client, key.Address); err != nil {
+		return err
+	}
+
+	if err := key.SignTransaction(&tx); err != nil {
+		return err
+	}
+	id, err := client.SendTransaction(ctx, &tx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Submitted transaction %s\n", id)
+	return nil
+}
+
+// verifyTransaction confirms a transaction is mined by syncing block headers
+// from a node and checking the transaction's Merkle proof against them, so
+// the node does not have to be trusted
+func verifyTransaction(args []string) error {
+	flags := flag.NewFlagSet("spherewallet verify", flag.ContinueOnError)
+	rpcURL := flags.String("rpc", "http://127.0.0.1:8545", "JSON-RPC URL of the node")
+	genesisPath := flags.String("genesis", "", "genesis file of the chain")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || *genesisPath == "" {
+		return fmt.Errorf("expected -genesis and one transaction ID")
+	}
+
+	genesis, err := core.LoadGenesis(*genesisPath)
+	if err != nil {
+		return err
+	}
+	source, err := rpc.Dial(*rpcURL)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	client := light.NewClient(genesis, source)
+	if err := client.Sync(ctx); err != nil {
+		return err
+	}
+	verified, err := client.VerifyTransaction(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	tx := verified.Transaction
+	fmt.Printf("Transaction %s is in block %d (%s)\n", tx.ID, verified.BlockIndex, verified.BlockHash)
+	fmt.Printf("From:          %s\n", tx.From)
+	fmt.Printf("To:            %s\n", tx.To)
+	fmt.Printf("Amount:        %v\n", tx.Amount)
+	fmt.Printf("Confirmations: %d\n", verified.Confirmations)
+	return nil
+}
+
+// unlock finds an account in the keystore and decrypts its key
+func unlock(ks *keystoreFlags, address string) (*wallet.Key, error) {
+	keystore, err := ks.open()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := keystore.Find(address); err != nil {
+		return nil, fmt.Errorf("%s: %v", address, err)
+	}
+	passphrase, err := ks.passphrase(false)
+	if err != nil {
+		return nil, err
+	}
+	return keystore.Unlock(address, passphrase)
+}
+
+// nextNonce returns the first nonce after the sender's confirmed and pending transactions
+func nextNonce(ctx context.Context, client *rpc.Client, address string) (uint64, error) {
+	nonce, err := client.NonceAt(ctx, address)
+	if err != nil {
+		return 0, err
+	}
+	pending, err := client.PendingTransactions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, tx := range pending {
+		if strings.EqualFold(tx.From, address) && tx.Nonce >= nonce {
+			nonce = tx.Nonce + 1
+		}
+	}
+	return nonce, nil
+}
@@ -0,0 +1,152 @@
+// Command spherewallet manages keys for core chain addresses.
+//
+// Usage:
+//
+//	spherewallet new [flags]               create an encrypted key
+//	spherewallet list [flags]              list stored accounts
+//	spherewallet import [flags] <file>     import a hex private key or keystore file
+//	spherewallet pubkey [flags] <address>  print the public key of an account
+//	spherewallet multisig [flags] <key>... print the address of an M-of-N account
+//	spherewallet sign [flags]              sign or cosign a JSON transaction
+//	spherewallet send [flags]              sign and submit a transfer to a node
+//	spherewallet verify [flags] <txid>     prove a transaction is mined using block headers
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+
+	"0xygen.thesphere.online/blockchain/wallet"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	command, args := os.Args[1], os.Args[2:]
+
+	var err error
+	switch command {
+	case "new":
+		err = newAccount(args)
+	case "list":
+		err = listAccounts(args)
+	case "import":
+		err = importAccount(args)
+	case "pubkey":
+		err = publicKey(args)
+	case "multisig":
+		err = multisigAccount(args)
+	case "sign":
+		err = signTransaction(args)
+	case "send":
+		err = sendTransaction(args)
+	case "verify":
+		err = verifyTransaction(args)
+	case "help", "-h", "--help":
+		usage()
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "spherewallet: unknown command %q\n\n", command)
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "spherewallet %s: %v\n", command, err)
+		os.Exit(1)
+	}
+}
+
+// usage prints the list of subcommands
+func usage() {
+	fmt.Fprint(os.Stderr, `Usage: spherewallet <command> [flags]
+
+Commands:
+  new       create an encrypted key
+  list      list stored accounts
+  import    import a hex private key or keystore file
+  pubkey    print the public key of an account
+  multisig  print the address of an M-of-N account
+  sign      sign or cosign a JSON transaction
+  send      sign and submit a transfer to a node
+  verify    prove a transaction is mined using block headers
+
+Run "spherewallet <command> -h" for the flags of a command.
+`)
+}
+
+// keystoreFlags are the flags shared by every subcommand
+type keystoreFlags struct {
+	dir      *string
+	light    *bool
+	passfile *string
+}
+
+// newFlagSet creates the flag set of a subcommand with the keystore flags
+func newFlagSet(command string) (*flag.FlagSet, *keystoreFlags) {
+	flags := flag.NewFlagSet("spherewallet "+command, flag.ContinueOnError)
+	return flags, &keystoreFlags{
+		dir:      flags.String("keystore", "keystore", "keystore directory"),
+		light:    flags.Bool("light", false, "use cheap scrypt parameters (development only)"),
+		passfile: flags.String("passfile", "", "read the passphrase from this file instead of prompting"),
+	}
+}
+
+// open opens the keystore the flags point at
+func (f *keystoreFlags) open() (*wallet.Keystore, error) {
+	if *f.light {
+		return wallet.NewKeystore(*f.dir, wallet.LightScryptN, wallet.LightScryptP)
+	}
+	return wallet.NewKeystore(*f.dir, wallet.StandardScryptN, wallet.StandardScryptP)
+}
+
+// passphrase reads the passphrase from the passfile, the terminal or stdin.
+// New passphrases are asked for twice on a terminal.
+func (f *keystoreFlags) passphrase(confirm bool) (string, error) {
+	if *f.passfile != "" {
+		data, err := os.ReadFile(*f.passfile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase file: %v", err)
+		}
+		return strings.TrimRight(strings.SplitN(string(data), "\n", 2)[0], "\r"), nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("failed to read passphrase: %v", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, "Passphrase: ")
+	first, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %v", err)
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Repeat passphrase: ")
+		second, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase: %v", err)
+		}
+		if string(first) != string(second) {
+			return "", fmt.Errorf("passphrases do not match")
+		}
+	}
+	return string(first), nil
+}
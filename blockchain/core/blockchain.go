@@ -1,156 +1,519 @@
 package core
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
-	"time"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
 )
 
 // Block represents a single block in the blockchain
 type Block struct {
-	Index        int64
-	Timestamp    int64
-	Transactions []Transaction
-	PrevHash     string
-	Hash         string
-	Nonce        int64
+	Index        int64         `json:"index"`
+	Timestamp    int64         `json:"timestamp"`
+	Transactions []Transaction `json:"transactions"`
+	PrevHash     string        `json:"prevHash"`
+	MerkleRoot   string        `json:"merkleRoot"`
+	Difficulty   uint64        `json:"difficulty"`
+	Hash         string        `json:"hash"`
+	Nonce        int64         `json:"nonce"`
+	// Signature seals the hash for engines that sign blocks, such as PoA
+	Signature string `json:"signature,omitempty"`
 }
 
 // Transaction represents a transaction on the blockchain
 type Transaction struct {
-	ID        string
-	From      string
-	To        string
-	Amount    float64
-	Timestamp int64
-	Signature string
-	Data      map[string]interface{} // For NFT metadata
+	ID        string  `json:"id"`
+	From      string  `json:"from"`
+	To        string  `json:"to"`
+	Amount    float64 `json:"amount"`
+	Fee       float64 `json:"fee"`
+	Nonce     uint64  `json:"nonce"`
+	ChainID   uint64  `json:"chainId"`
+	Timestamp int64   `json:"timestamp"`
+	// LockHeight and LockTime keep the transaction out of blocks below that
+	// index or stamped before that Unix time; zero means no lock
+	LockHeight int64                  `json:"lockHeight,omitempty"`
+	LockTime   int64                  `json:"lockTime,omitempty"`
+	Signature  string                 `json:"signature"`
+	Data       map[string]interface{} `json:"data,omitempty"` // Transaction kind and NFT metadata
+	// Multisig and Signatures authorise a transaction sent from a multisig
+	// account in place of Signature
+	Multisig   *Multisig `json:"multisig,omitempty"`
+	Signatures []string  `json:"signatures,omitempty"`
 }
 
-// Blockchain represents the entire blockchain
+// ErrStaleBlock is returned when the chain tip moved while a block was being mined
+var ErrStaleBlock = errors.New("chain tip changed while mining")
+
+// Blockchain represents the entire blockchain. It is safe for concurrent
+// use through its methods; Chain must not be touched directly while other
+// goroutines are using the blockchain.
 type Blockchain struct {
-	Chain               []*Block
-	PendingTransactions []Transaction
-	Difficulty          int
-	MiningReward        float64
-	Nodes               []string
+	Chain  []*Block
+	Config Config
+
+	mu      sync.RWMutex
+	store   Store
+	state   *AccountState
+	mempool *Mempool
+	// tipChanged is closed and replaced whenever the chain tip moves
+	tipChanged chan struct{}
+
+	// nodes indexes every known block, on the canonical chain or a side branch
+	nodes map[string]*blockNode
+	// finalized is the height below which the tree has been pruned
+	finalized int64
+	orphans   *orphanPool
+	index     *chainIndex
+	blockFeed feed[*Block]
+	txFeed    feed[Transaction]
+	reorgFeed feed[ReorgEvent]
 }
 
-// NewBlockchain creates a new blockchain with a genesis block
+// NewBlockchain creates a new in-memory blockchain with a genesis block
 func NewBlockchain(difficulty int, miningReward float64) *Blockchain {
+	config := DefaultConfig()
+	if difficulty > 0 {
+		config.Difficulty = uint64(difficulty)
+	}
+	config.MiningReward = miningReward
+
+	// Without a store there is nothing that can fail
+	blockchain, _ := OpenBlockchain(nil, config)
+	return blockchain
+}
+
+// OpenBlockchain creates a blockchain backed by the given store. If the store
+// already holds blocks they are reloaded and re-validated, otherwise a new
+// genesis block is created and persisted. A nil store keeps everything in memory.
+func OpenBlockchain(store Store, config Config) (*Blockchain, error) {
+	config = config.withDefaults()
+
 	blockchain := &Blockchain{
-		Chain:               []*Block{},
-		PendingTransactions: []Transaction{},
-		Difficulty:          difficulty,
-		MiningReward:        miningReward,
-		Nodes:               []string{},
+		Chain:      []*Block{},
+		Config:     config,
+		store:      store,
+		mempool:    newMempool(config),
+		tipChanged: make(chan struct{}),
+		orphans:    newOrphanPool(),
+	}
+	blockchain.state = blockchain.newState()
+
+	if store != nil {
+		blocks, err := store.LoadBlocks()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load blocks: %v", err)
+		}
+
+		if len(blocks) > 0 {
+			blockchain.Chain = blocks
+			state, err := blockchain.validateChain(blocks)
+			if err != nil {
+				return nil, fmt.Errorf("stored chain failed validation: %v", err)
+			}
+			blockchain.state = state
+			blockchain.resetTree()
+			blockchain.index = newChainIndex(blocks)
+
+			pending, err := store.LoadPending()
+			if err != nil {
+				return nil, fmt.Errorf("failed to load pending transactions: %v", err)
+			}
+			// Drop anything that is no longer acceptable against the reloaded state
+			for _, tx := range pending {
+				if blockchain.validateTransaction(&tx) != nil {
+					continue
+				}
+				blockchain.mempool.Add(tx)
+			}
+
+			return blockchain, nil
+		}
 	}
 
 	// Create genesis block
+	genesisBlock := newGenesisBlock(config)
+	if err := blockchain.state.ApplyBlock(genesisBlock); err != nil {
+		return nil, fmt.Errorf("invalid genesis block: %v", err)
+	}
+	if store != nil {
+		if err := store.AppendBlock(genesisBlock); err != nil {
+			return nil, fmt.Errorf("failed to persist genesis block: %v", err)
+		}
+	}
+	blockchain.Chain = append(blockchain.Chain, genesisBlock)
+	blockchain.resetTree()
+	blockchain.index = newChainIndex(blockchain.Chain)
+
+	return blockchain, nil
+}
+
+// newGenesisBlock creates the first block of a chain with the given config
+func newGenesisBlock(config Config) *Block {
+	timestamp := config.GenesisTimestamp
+	if timestamp == 0 {
+		timestamp = config.now().Unix()
+	}
+
 	genesisBlock := &Block{
 		Index:        0,
-		Timestamp:    time.Now().Unix(),
-		Transactions: []Transaction{},
+		Timestamp:    timestamp,
+		Transactions: genesisTransactions(config),
 		PrevHash:     "0",
+		Difficulty:   config.Difficulty,
 		Nonce:        0,
 	}
+	genesisBlock.MerkleRoot = ComputeMerkleRoot(genesisBlock.Transactions)
 	genesisBlock.Hash = calculateHash(genesisBlock)
-	blockchain.Chain = append(blockchain.Chain, genesisBlock)
+	return genesisBlock
+}
 
-	return blockchain
+// Close closes the underlying store, if any
+func (bc *Blockchain) Close() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if bc.store == nil {
+		return nil
+	}
+	return bc.store.Close()
+}
+
+// Header returns a copy of the block without its transactions. The hash
+// commits to them only through the Merkle root, so a header is enough to
+// verify the chain and check Merkle proofs against.
+func (b *Block) Header() *Block {
+	header := *b
+	header.Transactions = nil
+	return &header
 }
 
 // calculateHash calculates the hash of a block
 func calculateHash(block *Block) string {
-	record := string(block.Index) + string(block.Timestamp) + block.PrevHash + string(block.Nonce)
-	for _, tx := range block.Transactions {
-		record += tx.ID
-	}
-
-	h := sha256.New()
-	h.Write([]byte(record))
-	hashed := h.Sum(nil)
-	return hex.EncodeToString(hashed)
+	hashed := sha256.Sum256(encodeHeader(block))
+	return hex.EncodeToString(hashed[:])
 }
 
 // AddTransaction adds a new transaction to pending transactions
-func (bc *Blockchain) AddTransaction(tx Transaction) bool {
-	// Verify transaction signature here
-	// ...
+func (bc *Blockchain) AddTransaction(tx Transaction) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	now := bc.Config.now()
+	bc.mempool.Expire(now)
+
+	if err := bc.validateTransaction(&tx); err != nil {
+		return err
+	}
+	if _, err := bc.mempool.add(tx, now); err != nil {
+		return err
+	}
+	bc.txFeed.send(tx)
 
-	bc.PendingTransactions = append(bc.PendingTransactions, tx)
-	return true
+	return bc.savePending()
 }
 
-// MinePendingTransactions mines pending transactions into a new block
-func (bc *Blockchain) MinePendingTransactions(minerAddress string) {
-	// Create mining reward transaction
-	rewardTx := Transaction{
-		ID:        generateTransactionID(),
-		From:      "SYSTEM",
-		To:        minerAddress,
-		Amount:    bc.MiningReward,
-		Timestamp: time.Now().Unix(),
-		Data:      map[string]interface{}{"type": "mining_reward"},
+// Pending returns the pending transactions in mining priority order
+func (bc *Blockchain) Pending() []Transaction {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.mempool.Transactions()
+}
+
+// validateTransaction checks a user transaction before it enters the pending pool
+func (bc *Blockchain) validateTransaction(tx *Transaction) error {
+	// Only the protocol itself may create SYSTEM transactions
+	if tx.From == SystemAddress {
+		return fmt.Errorf("transactions from %s cannot be submitted", SystemAddress)
+	}
+
+	// Token transactions carry their own amount rules
+	if tx.IsNFT() {
+		if err := bc.state.checkNFT(tx); err != nil {
+			return err
+		}
+	} else if !validAmount(tx.Amount) || tx.Amount == 0 {
+		return fmt.Errorf("transaction amount must be positive")
+	}
+	if !validAmount(tx.Fee) {
+		return fmt.Errorf("transaction fee must be finite and not negative")
+	}
+	if err := checkAddresses(tx); err != nil {
+		return err
 	}
 
-	bc.PendingTransactions = append(bc.PendingTransactions, rewardTx)
+	if tx.ChainID != bc.Config.ChainID {
+		return fmt.Errorf("transaction is for chain %d, not %d", tx.ChainID, bc.Config.ChainID)
+	}
+	if err := VerifyTransactionID(tx); err != nil {
+		return err
+	}
+	if err := VerifyTransactionSignature(tx); err != nil {
+		return err
+	}
 
-	// Create new block
-	block := &Block{
-		Index:        int64(len(bc.Chain)),
-		Timestamp:    time.Now().Unix(),
-		Transactions: bc.PendingTransactions,
-		PrevHash:     bc.Chain[len(bc.Chain)-1].Hash,
-		Nonce:        0,
+	// Time-locked transactions are only accepted once the next block could include them
+	if err := checkLock(tx, bc.Chain[len(bc.Chain)-1].Index+1, bc.Config.now().Unix()); err != nil {
+		return err
 	}
 
-	// Mine the block (proof of work)
-	bc.mineBlock(block)
+	if bc.mempool.Has(tx.ID) {
+		return fmt.Errorf("transaction %s is already pending", tx.ID)
+	}
+	if bc.index.hasTransaction(tx.ID) {
+		return fmt.Errorf("transaction %s is already in the chain", tx.ID)
+	}
 
-	// Add block to chain
-	bc.Chain = append(bc.Chain, block)
+	// The nonce must follow the sender's confirmed and pending transactions,
+	// or replace one of the pending ones
+	if tx.Nonce < bc.state.Nonces[tx.From] {
+		return checkNonce(tx, bc.state.Nonces[tx.From])
+	}
+
+	// Pending spends count against the balance so they cannot be doubled up.
+	// A transaction this one would replace does not count. This comes before
+	// the gap check, so ErrNonceGap means the transaction is otherwise sound
+	// and a peer may hold it until the gap fills.
+	spendable := bc.spendableBalance(tx.From)
+	if conflict, ok := bc.mempool.Conflict(tx); ok {
+		spendable += conflict.Amount + conflict.Fee
+	}
+	if cost := tx.Amount + tx.Fee; cost > spendable {
+		return fmt.Errorf("insufficient balance: %s can spend %v, transaction needs %v",
+			tx.From, spendable, cost)
+	}
 
-	// Clear pending transactions
-	bc.PendingTransactions = []Transaction{}
+	if next := bc.pendingNonce(tx.From); tx.Nonce > next {
+		return checkNonce(tx, next)
+	}
+	return nil
 }
 
-// mineBlock mines a block (proof of work)
-func (bc *Blockchain) mineBlock(block *Block) {
-	target := string(make([]byte, bc.Difficulty))
+// pendingNonce returns the nonce that would follow a sender's confirmed
+// transactions and its consecutive pending ones
+func (bc *Blockchain) pendingNonce(address string) uint64 {
+	nonce := bc.state.Nonces[address]
+	for _, tx := range bc.mempool.SenderTransactions(address) {
+		if tx.Nonce == nonce {
+			nonce++
+		}
+	}
+	return nonce
+}
 
+// MinePendingTransactions mines pending transactions into a new block. If
+// another block arrives while mining, the work is restarted on the new tip.
+func (bc *Blockchain) MinePendingTransactions(minerAddress string) (*Block, error) {
 	for {
-		block.Hash = calculateHash(block)
-		if block.Hash[:bc.Difficulty] == target {
+		block, err := bc.MineBlock(context.Background(), minerAddress)
+		if err == ErrStaleBlock {
+			continue
+		}
+		return block, err
+	}
+}
+
+// MineBlock mines a single block on top of the current tip. It gives up with
+// the context's error when ctx is cancelled and with ErrStaleBlock when a
+// competing block extends the chain first.
+func (bc *Blockchain) MineBlock(ctx context.Context, minerAddress string) (*Block, error) {
+	block, tipChanged, err := bc.newBlockTemplate(minerAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	// Seal the block (proof of work, or a signature) without holding the lock
+	if err := bc.Config.Engine.Seal(ctx, block, tipChanged); err != nil {
+		return nil, err
+	}
+
+	if err := bc.AddBlock(block); err != nil {
+		if err == ErrUnknownParent {
+			return nil, ErrStaleBlock
+		}
+		return nil, err
+	}
+
+	// The tip may have moved while sealing, leaving the block on a side branch
+	if bc.LastBlock().Hash != block.Hash {
+		return nil, ErrStaleBlock
+	}
+
+	return block, nil
+}
+
+// newBlockTemplate assembles an unsealed block of the pending transactions
+// plus the mining reward, along with the tip notification channel it was
+// built against
+func (bc *Blockchain) newBlockTemplate(minerAddress string) (*Block, <-chan struct{}, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	parent := bc.Chain[len(bc.Chain)-1]
+	timestamp := bc.Config.now().Unix()
+	if timestamp < parent.Timestamp {
+		timestamp = parent.Timestamp
+	}
+
+	// Take the best paying transactions that still apply cleanly
+	state := bc.state.Copy()
+	state.advance(parent.Index+1, timestamp)
+	supply := state.Supply
+	transactions := []Transaction{}
+	var fees float64
+	for _, tx := range bc.mempool.Transactions() {
+		if bc.Config.MaxBlockTransactions > 0 && len(transactions) >= bc.Config.MaxBlockTransactions {
 			break
 		}
-		block.Nonce++
+		if state.ApplyTransaction(&tx) != nil {
+			continue
+		}
+		transactions = append(transactions, tx)
+		fees += tx.Fee
+	}
+
+	// Create mining reward transaction, which also collects the fees. Its
+	// nonce is the block index, which keeps its ID unique.
+	rewardTx := Transaction{
+		From:      SystemAddress,
+		To:        strings.ToLower(minerAddress),
+		Amount:    bc.blockReward(parent.Index+1, supply) + fees,
+		Nonce:     uint64(parent.Index + 1),
+		ChainID:   bc.Config.ChainID,
+		Timestamp: timestamp,
+		Data:      map[string]interface{}{"type": TxTypeMiningReward},
+	}
+	rewardTx.ID = rewardTx.ComputeID()
+	transactions = append(transactions, rewardTx)
+
+	// Create new block
+	block := &Block{
+		Index:        parent.Index + 1,
+		Timestamp:    timestamp,
+		Transactions: transactions,
+		PrevHash:     parent.Hash,
+		Nonce:        0,
+	}
+	if err := bc.Config.Engine.Prepare(bc.Chain, block); err != nil {
+		return nil, nil, err
+	}
+	block.MerkleRoot = ComputeMerkleRoot(block.Transactions)
+
+	// Make sure the block is valid before doing any proof of work
+	if err := state.ApplyTransaction(&rewardTx); err != nil {
+		return nil, nil, err
 	}
+
+	return block, bc.tipChanged, nil
 }
 
-// IsChainValid checks if the blockchain is valid
-func (bc *Blockchain) IsChainValid() bool {
-	for i := 1; i < len(bc.Chain); i++ {
-		currentBlock := bc.Chain[i]
-		prevBlock := bc.Chain[i-1]
+// blockReward returns the new coins paid to the miner of the block at index
+// when supply coins already exist: the mining reward halved every
+// HalvingInterval blocks, but never more than is left under MaxSupply
+func (bc *Blockchain) blockReward(index int64, supply float64) float64 {
+	reward := bc.Config.MiningReward
+	if bc.Config.HalvingInterval > 0 {
+		reward = math.Ldexp(reward, -int(index/bc.Config.HalvingInterval))
+	}
+	if bc.Config.MaxSupply > 0 {
+		reward = math.Min(reward, math.Max(bc.Config.MaxSupply-supply, 0))
+	}
+	return reward
+}
 
-		// Check if hash is correct
-		if currentBlock.Hash != calculateHash(currentBlock) {
-			return false
+// connectBlock persists a validated block, appends it to the chain and
+// drops the pending transactions it included
+func (bc *Blockchain) connectBlock(block *Block, state *AccountState) error {
+	// Persist the block before it becomes part of the chain
+	if bc.store != nil {
+		if err := bc.store.AppendBlock(block); err != nil {
+			return fmt.Errorf("failed to persist block: %v", err)
 		}
+	}
+
+	// Add block to chain
+	bc.addNode(block, bc.tipNode()).state = state
+	bc.index.addBlock(block)
+	bc.Chain = append(bc.Chain, block)
+	bc.state = state
+	bc.pruneTree()
+	bc.notifyTipChanged()
+	bc.blockFeed.send(block)
+
+	// Clear the pending transactions this block included
+	return bc.resetPending(transactionIDs(block), nil)
+}
 
-		// Check if previous hash is correct
-		if currentBlock.PrevHash != prevBlock.Hash {
-			return false
+// resetPending rebuilds the mempool, dropping included transactions and
+// anything no longer valid against the chain state. Returned transactions,
+// from blocks abandoned in a reorg, are offered back first.
+func (bc *Blockchain) resetPending(included map[string]bool, returned []Transaction) error {
+	// Each sender's transactions go back in nonce order so none look like a gap
+	entries := bc.mempool.entriesByArrival()
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].tx.Nonce < entries[j].tx.Nonce
+	})
+	bc.mempool = newMempool(bc.Config)
+	now := bc.Config.now()
+	for _, tx := range returned {
+		if bc.validateTransaction(&tx) != nil {
+			continue
 		}
+		bc.mempool.add(tx, now)
 	}
+	for _, entry := range entries {
+		if included[entry.tx.ID] || bc.validateTransaction(&entry.tx) != nil {
+			continue
+		}
+		bc.mempool.add(entry.tx, entry.added)
+	}
+	bc.mempool.Expire(now)
+
+	return bc.savePending()
+}
+
+// savePending persists the mempool contents
+func (bc *Blockchain) savePending() error {
+	if bc.store == nil {
+		return nil
+	}
+	if err := bc.store.SavePending(bc.mempool.Transactions()); err != nil {
+		return fmt.Errorf("failed to persist pending transactions: %v", err)
+	}
+	return nil
+}
 
-	return true
+// TipChanged returns a channel that is closed the next time the chain tip moves
+func (bc *Blockchain) TipChanged() <-chan struct{} {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.tipChanged
 }
 
-// Helper function to generate transaction ID
-func generateTransactionID() string {
-	// Generate a unique ID based on timestamp and random number
-	// ...
-	return "tx_" + hex.EncodeToString([]byte(time.Now().String()))
+// notifyTipChanged wakes everything waiting on the current tip
+func (bc *Blockchain) notifyTipChanged() {
+	close(bc.tipChanged)
+	bc.tipChanged = make(chan struct{})
+}
+
+// IsChainValid checks if the blockchain is valid. Validate reports why not.
+func (bc *Blockchain) IsChainValid() bool {
+	return bc.Validate().Valid()
+}
+
+// transactionIDs collects the IDs of every transaction in the given blocks
+func transactionIDs(blocks ...*Block) map[string]bool {
+	ids := map[string]bool{}
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			ids[tx.ID] = true
+		}
+	}
+	return ids
 }
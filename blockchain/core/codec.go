@@ -0,0 +1,549 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// CodecVersion is the version byte written at the start of every encoded
+// block and transaction. Decoders reject any other version.
+const CodecVersion byte = 1
+
+// ErrUnsupportedVersion is returned when decoding data in another codec version
+var ErrUnsupportedVersion = errors.New("unsupported codec version")
+
+// EncodeTransaction returns the canonical binary encoding of a transaction
+func EncodeTransaction(tx *Transaction) []byte {
+	e := &encoder{}
+	e.byte(CodecVersion)
+	encodeTransactionRecord(e, tx)
+	return e.buf.Bytes()
+}
+
+// DecodeTransaction parses a transaction produced by EncodeTransaction
+func DecodeTransaction(data []byte) (*Transaction, error) {
+	d := &decoder{data: data}
+	if err := d.version(); err != nil {
+		return nil, err
+	}
+
+	tx := decodeTransactionBody(d)
+	tx.Signature = d.string()
+	if d.err == nil && d.remaining() > 0 {
+		decodeWitness(d, tx)
+	}
+	if err := d.finish(); err != nil {
+		return nil, fmt.Errorf("failed to decode transaction: %v", err)
+	}
+	return tx, nil
+}
+
+// EncodeBlock returns the canonical binary encoding of a block
+func EncodeBlock(block *Block) []byte {
+	e := &encoder{}
+	e.byte(CodecVersion)
+	encodeHeaderFields(e, block)
+	e.string(block.Hash)
+	e.uvarint(uint64(len(block.Transactions)))
+	for i := range block.Transactions {
+		e.bytes(EncodeTransaction(&block.Transactions[i]))
+	}
+	// The seal signature is an optional trailing field, written only by
+	// engines that sign blocks
+	if block.Signature != "" {
+		e.string(block.Signature)
+	}
+	return e.buf.Bytes()
+}
+
+// DecodeBlock parses a block produced by EncodeBlock
+func DecodeBlock(data []byte) (*Block, error) {
+	d := &decoder{data: data}
+	if err := d.version(); err != nil {
+		return nil, err
+	}
+
+	block := &Block{}
+	block.Index = d.varint()
+	block.Timestamp = d.varint()
+	block.PrevHash = d.string()
+	block.MerkleRoot = d.string()
+	block.Difficulty = d.uvarint()
+	block.Nonce = d.varint()
+	block.Hash = d.string()
+
+	count := d.count()
+	block.Transactions = make([]Transaction, 0, count)
+	for i := 0; i < count && d.err == nil; i++ {
+		raw := d.bytes()
+		if d.err != nil {
+			break
+		}
+		tx, err := DecodeTransaction(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode block transaction %d: %v", i, err)
+		}
+		block.Transactions = append(block.Transactions, *tx)
+	}
+	if d.err == nil && d.remaining() > 0 {
+		// An empty signature is never written, so it has only one encoding
+		if block.Signature = d.string(); d.err == nil && block.Signature == "" {
+			return nil, fmt.Errorf("failed to decode block: empty seal signature")
+		}
+	}
+
+	if err := d.finish(); err != nil {
+		return nil, fmt.Errorf("failed to decode block: %v", err)
+	}
+	return block, nil
+}
+
+// EncodeBlocks encodes a list of blocks as length-prefixed records
+func EncodeBlocks(blocks []*Block) []byte {
+	e := &encoder{}
+	e.uvarint(uint64(len(blocks)))
+	for _, block := range blocks {
+		e.bytes(EncodeBlock(block))
+	}
+	return e.buf.Bytes()
+}
+
+// DecodeBlocks parses a list produced by EncodeBlocks
+func DecodeBlocks(data []byte) ([]*Block, error) {
+	d := &decoder{data: data}
+	count := d.count()
+	blocks := make([]*Block, 0, count)
+	for i := 0; i < count && d.err == nil; i++ {
+		raw := d.bytes()
+		if d.err != nil {
+			break
+		}
+		block, err := DecodeBlock(raw)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+
+	if err := d.finish(); err != nil {
+		return nil, fmt.Errorf("failed to decode blocks: %v", err)
+	}
+	return blocks, nil
+}
+
+// EncodeTransactions encodes a list of transactions as length-prefixed records
+func EncodeTransactions(txs []Transaction) []byte {
+	e := &encoder{}
+	e.uvarint(uint64(len(txs)))
+	for i := range txs {
+		e.bytes(EncodeTransaction(&txs[i]))
+	}
+	return e.buf.Bytes()
+}
+
+// DecodeTransactions parses a list produced by EncodeTransactions
+func DecodeTransactions(data []byte) ([]Transaction, error) {
+	d := &decoder{data: data}
+	count := d.count()
+	txs := make([]Transaction, 0, count)
+	for i := 0; i < count && d.err == nil; i++ {
+		raw := d.bytes()
+		if d.err != nil {
+			break
+		}
+		tx, err := DecodeTransaction(raw)
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, *tx)
+	}
+
+	if err := d.finish(); err != nil {
+		return nil, fmt.Errorf("failed to decode transactions: %v", err)
+	}
+	return txs, nil
+}
+
+// encodeHeader returns the block header preimage that gets hashed
+func encodeHeader(block *Block) []byte {
+	e := &encoder{}
+	encodeHeaderFields(e, block)
+	return e.buf.Bytes()
+}
+
+// encodeSigningPayload returns everything in a transaction except its ID
+// and signature, both of which are derived from it
+func encodeSigningPayload(tx *Transaction) []byte {
+	e := &encoder{}
+	encodeTransactionFields(e, tx)
+	return e.buf.Bytes()
+}
+
+// encodeTransactionPreimage returns the encoded transaction without its
+// version byte, which is what its hash covers
+func encodeTransactionPreimage(tx *Transaction) []byte {
+	e := &encoder{}
+	encodeTransactionRecord(e, tx)
+	return e.buf.Bytes()
+}
+
+// encodeHeaderFields writes the fields committed to by the block hash
+func encodeHeaderFields(e *encoder, block *Block) {
+	e.varint(block.Index)
+	e.varint(block.Timestamp)
+	e.string(block.PrevHash)
+	e.string(block.MerkleRoot)
+	e.uvarint(block.Difficulty)
+	e.varint(block.Nonce)
+}
+
+// encodeTransactionRecord writes everything EncodeTransaction does after
+// the version byte
+func encodeTransactionRecord(e *encoder, tx *Transaction) {
+	encodeTransactionBody(e, tx)
+	e.string(tx.Signature)
+	// The multisig witness is an optional trailing field, so single-key
+	// transactions do not pay for it. An empty one is never written.
+	if tx.Multisig != nil || len(tx.Signatures) > 0 {
+		encodeWitness(e, tx)
+	}
+}
+
+// encodeTransactionBody writes the transaction ID and every signed field
+func encodeTransactionBody(e *encoder, tx *Transaction) {
+	e.string(tx.ID)
+	encodeTransactionFields(e, tx)
+}
+
+// encodeTransactionFields writes every signed transaction field
+func encodeTransactionFields(e *encoder, tx *Transaction) {
+	e.uvarint(tx.ChainID)
+	e.string(tx.From)
+	e.string(tx.To)
+	e.uint64(math.Float64bits(tx.Amount))
+	e.uint64(math.Float64bits(tx.Fee))
+	e.uvarint(tx.Nonce)
+	e.varint(tx.Timestamp)
+	e.varint(tx.LockHeight)
+	e.varint(tx.LockTime)
+	encodeData(e, tx.Data)
+}
+
+// encodeWitness writes the multisig account and signatures of a transaction
+func encodeWitness(e *encoder, tx *Transaction) {
+	multisig := tx.Multisig
+	if multisig == nil {
+		multisig = &Multisig{}
+	}
+	e.uvarint(uint64(multisig.Threshold))
+	e.uvarint(uint64(len(multisig.PublicKeys)))
+	for _, key := range multisig.PublicKeys {
+		e.string(key)
+	}
+	e.uvarint(uint64(len(tx.Signatures)))
+	for _, signature := range tx.Signatures {
+		e.string(signature)
+	}
+}
+
+// encodeData writes the data map with sorted keys and canonical JSON values
+func encodeData(e *encoder, data map[string]interface{}) {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	e.uvarint(uint64(len(keys)))
+	for _, key := range keys {
+		// encoding/json sorts nested map keys too
+		value, err := json.Marshal(data[key])
+		if err != nil {
+			value = []byte("null")
+		}
+		e.string(key)
+		e.bytes(value)
+	}
+}
+
+// decodeTransactionBody reads the fields written by encodeTransactionBody
+func decodeTransactionBody(d *decoder) *Transaction {
+	tx := &Transaction{}
+	tx.ID = d.string()
+	tx.ChainID = d.uvarint()
+	tx.From = d.string()
+	tx.To = d.string()
+	tx.Amount = math.Float64frombits(d.uint64())
+	tx.Fee = math.Float64frombits(d.uint64())
+	tx.Nonce = d.uvarint()
+	tx.Timestamp = d.varint()
+	tx.LockHeight = d.varint()
+	tx.LockTime = d.varint()
+
+	count := d.count()
+	if count > 0 {
+		tx.Data = make(map[string]interface{}, count)
+	}
+	for i := 0; i < count && d.err == nil; i++ {
+		key := d.string()
+		raw := d.bytes()
+		if d.err != nil {
+			break
+		}
+
+		// json.Number keeps numbers byte-for-byte so re-encoding is stable
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		var value interface{}
+		if err := dec.Decode(&value); err != nil {
+			d.err = fmt.Errorf("invalid data value for %q: %v", key, err)
+			break
+		}
+		tx.Data[key] = value
+	}
+
+	return tx
+}
+
+// decodeWitness reads the fields written by encodeWitness. Absent parts
+// decode as nil, and a witness with nothing in it is rejected, as it can
+// only come from an empty Multisig or Signatures that would not survive a
+// round trip.
+func decodeWitness(d *decoder, tx *Transaction) {
+	threshold := d.uvarint()
+	count := d.count()
+	if threshold > 0 || count > 0 {
+		tx.Multisig = &Multisig{Threshold: int(threshold)}
+		for i := 0; i < count && d.err == nil; i++ {
+			tx.Multisig.PublicKeys = append(tx.Multisig.PublicKeys, d.string())
+		}
+	}
+
+	count = d.count()
+	for i := 0; i < count && d.err == nil; i++ {
+		tx.Signatures = append(tx.Signatures, d.string())
+	}
+	if d.err == nil && tx.Multisig == nil && len(tx.Signatures) == 0 {
+		d.err = fmt.Errorf("empty multisig witness")
+	}
+}
+
+// encoder appends primitive values to a buffer
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) byte(b byte) {
+	e.buf.WriteByte(b)
+}
+
+func (e *encoder) uvarint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	e.buf.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+}
+
+func (e *encoder) varint(v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	e.buf.Write(tmp[:binary.PutVarint(tmp[:], v)])
+}
+
+func (e *encoder) uint64(v uint64) {
+	var tmp [8]byte
+	binary.BigEndian.PutUint64(tmp[:], v)
+	e.buf.Write(tmp[:])
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf.Write(b)
+}
+
+func (e *encoder) string(s string) {
+	e.bytes([]byte(s))
+}
+
+// decoder reads primitive values and remembers the first error
+type decoder struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (d *decoder) version() error {
+	if len(d.data) == 0 {
+		return fmt.Errorf("empty input")
+	}
+	if d.data[0] != CodecVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, d.data[0])
+	}
+	d.pos = 1
+	return nil
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data[d.pos:])
+	if n <= 0 {
+		d.err = fmt.Errorf("invalid varint at offset %d", d.pos)
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data[d.pos:])
+	if n <= 0 {
+		d.err = fmt.Errorf("invalid varint at offset %d", d.pos)
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+func (d *decoder) uint64() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data)-d.pos < 8 {
+		d.err = fmt.Errorf("unexpected end of input at offset %d", d.pos)
+		return 0
+	}
+	v := binary.BigEndian.Uint64(d.data[d.pos:])
+	d.pos += 8
+	return v
+}
+
+// count reads a list length, bounding it by the remaining input
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if d.err == nil && n > uint64(len(d.data)-d.pos) {
+		d.err = fmt.Errorf("list length %d exceeds input", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.count()
+	if d.err != nil {
+		return nil
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) remaining() int {
+	return len(d.data) - d.pos
+}
+
+// finish reports any error and rejects trailing bytes
+func (d *decoder) finish() error {
+	if d.err != nil {
+		return d.err
+	}
+	if d.pos != len(d.data) {
+		return fmt.Errorf("%d trailing bytes", len(d.data)-d.pos)
+	}
+	return nil
+}
+
+// blockView is the JSON debugging view of a block
+type blockView struct {
+	Version      byte              `json:"version"`
+	Index        int64             `json:"index"`
+	Timestamp    int64             `json:"timestamp"`
+	PrevHash     string            `json:"prevHash"`
+	MerkleRoot   string            `json:"merkleRoot"`
+	Difficulty   uint64            `json:"difficulty"`
+	Nonce        int64             `json:"nonce"`
+	Hash         string            `json:"hash"`
+	Signature    string            `json:"signature,omitempty"`
+	Size         int               `json:"size"`
+	Transactions []transactionView `json:"transactions"`
+}
+
+// transactionView is the JSON debugging view of a transaction
+type transactionView struct {
+	ID         string                 `json:"id"`
+	Hash       string                 `json:"hash"`
+	From       string                 `json:"from"`
+	To         string                 `json:"to"`
+	Amount     float64                `json:"amount"`
+	Fee        float64                `json:"fee"`
+	Nonce      uint64                 `json:"nonce"`
+	ChainID    uint64                 `json:"chainId"`
+	Timestamp  int64                  `json:"timestamp"`
+	LockHeight int64                  `json:"lockHeight,omitempty"`
+	LockTime   int64                  `json:"lockTime,omitempty"`
+	Signature  string                 `json:"signature"`
+	Data       map[string]interface{} `json:"data,omitempty"`
+	Multisig   *Multisig              `json:"multisig,omitempty"`
+	Signatures []string               `json:"signatures,omitempty"`
+	Size       int                    `json:"size"`
+}
+
+// BlockJSON renders a block as indented JSON, including derived fields such
+// as the encoded size and transaction hashes, for debugging
+func BlockJSON(block *Block) ([]byte, error) {
+	view := blockView{
+		Version:      CodecVersion,
+		Index:        block.Index,
+		Timestamp:    block.Timestamp,
+		PrevHash:     block.PrevHash,
+		MerkleRoot:   block.MerkleRoot,
+		Difficulty:   block.Difficulty,
+		Nonce:        block.Nonce,
+		Hash:         block.Hash,
+		Signature:    block.Signature,
+		Size:         len(EncodeBlock(block)),
+		Transactions: make([]transactionView, len(block.Transactions)),
+	}
+	for i := range block.Transactions {
+		view.Transactions[i] = newTransactionView(&block.Transactions[i])
+	}
+	return json.MarshalIndent(view, "", "  ")
+}
+
+// TransactionJSON renders a transaction as indented JSON for debugging
+func TransactionJSON(tx *Transaction) ([]byte, error) {
+	return json.MarshalIndent(newTransactionView(tx), "", "  ")
+}
+
+// newTransactionView builds the debugging view of a transaction
+func newTransactionView(tx *Transaction) transactionView {
+	return transactionView{
+		ID:         tx.ID,
+		Hash:       hex.EncodeToString(tx.Hash()),
+		From:       tx.From,
+		To:         tx.To,
+		Amount:     tx.Amount,
+		Fee:        tx.Fee,
+		Nonce:      tx.Nonce,
+		ChainID:    tx.ChainID,
+		Timestamp:  tx.Timestamp,
+		LockHeight: tx.LockHeight,
+		LockTime:   tx.LockTime,
+		Signature:  tx.Signature,
+		Data:       tx.Data,
+		Multisig:   tx.Multisig,
+		Signatures: tx.Signatures,
+		Size:       len(EncodeTransaction(tx)),
+	}
+}
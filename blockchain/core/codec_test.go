@@ -0,0 +1,167 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// codecTestTransaction returns a transaction with every field set
+func codecTestTransaction(t *testing.T) Transaction {
+	t.Helper()
+	tx := Transaction{
+		To:         "0x00000000000000000000000000000000000000aa",
+		Amount:     2.5,
+		Fee:        0.1,
+		Nonce:      7,
+		ChainID:    42,
+		Timestamp:  1700000000,
+		LockHeight: 10,
+		LockTime:   1700000100,
+		Data: map[string]interface{}{
+			"type":    TxTypeNFTMint,
+			"tokenId": "token-1",
+			"attrs":   map[string]interface{}{"rarity": 3},
+		},
+	}
+	if err := SignTransaction(&tx, newTestKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestTransactionRoundTrip(t *testing.T) {
+	tx := codecTestTransaction(t)
+	witnessed := tx
+	witnessed.Signature = ""
+	witnessed.Multisig = &Multisig{Threshold: 1, PublicKeys: []string{"02aa", "03bb"}}
+	witnessed.Signatures = []string{"cafe"}
+
+	for _, tx := range []Transaction{tx, witnessed} {
+		data := EncodeTransaction(&tx)
+		decoded, err := DecodeTransaction(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(EncodeTransaction(decoded), data) {
+			t.Fatal("re-encoding a decoded transaction changed it")
+		}
+		if !bytes.Equal(decoded.Hash(), tx.Hash()) || decoded.ID != tx.ID {
+			t.Fatal("decoded transaction has a different hash or ID")
+		}
+		if err := VerifyTransactionID(decoded); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded.Multisig, tx.Multisig) || !reflect.DeepEqual(decoded.Signatures, tx.Signatures) {
+			t.Fatalf("witness %+v %v, want %+v %v", decoded.Multisig, decoded.Signatures, tx.Multisig, tx.Signatures)
+		}
+	}
+}
+
+func TestBlockRoundTrip(t *testing.T) {
+	tx := codecTestTransaction(t)
+	block := &Block{
+		Index:        3,
+		Timestamp:    1700000200,
+		PrevHash:     "parent",
+		Transactions: []Transaction{tx},
+		Difficulty:   12,
+		Nonce:        99,
+	}
+	block.MerkleRoot = ComputeMerkleRoot(block.Transactions)
+	block.Hash = calculateHash(block)
+
+	signed := *block
+	signed.Signature = "5eal"
+
+	for _, block := range []*Block{block, &signed} {
+		data := EncodeBlock(block)
+		decoded, err := DecodeBlock(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(EncodeBlock(decoded), data) {
+			t.Fatal("re-encoding a decoded block changed it")
+		}
+		if decoded.Hash != calculateHash(decoded) || decoded.Signature != block.Signature || decoded.Difficulty != 12 {
+			t.Fatalf("decoded block %+v does not match", decoded)
+		}
+	}
+
+	blocks, err := DecodeBlocks(EncodeBlocks([]*Block{block, &signed}))
+	if err != nil || len(blocks) != 2 {
+		t.Fatalf("decoded %d blocks: %v", len(blocks), err)
+	}
+}
+
+func TestDecodeRejectsBadInput(t *testing.T) {
+	tx := codecTestTransaction(t)
+	data := EncodeTransaction(&tx)
+
+	for _, version := range []byte{0, CodecVersion + 1} {
+		bad := append([]byte{version}, data[1:]...)
+		if _, err := DecodeTransaction(bad); !errors.Is(err, ErrUnsupportedVersion) {
+			t.Fatalf("version %d gave %v", version, err)
+		}
+		if _, err := DecodeBlock(bad); !errors.Is(err, ErrUnsupportedVersion) {
+			t.Fatalf("block version %d gave %v", version, err)
+		}
+	}
+	if _, err := DecodeTransaction(nil); err == nil {
+		t.Fatal("empty input decoded")
+	}
+	if _, err := DecodeTransaction(data[:len(data)-3]); err == nil {
+		t.Fatal("truncated transaction decoded")
+	}
+	if _, err := DecodeTransaction(append(append([]byte{}, data...), 0, 0)); err == nil {
+		t.Fatal("transaction with trailing bytes decoded")
+	}
+}
+
+func TestDecodeRejectsNonCanonicalInput(t *testing.T) {
+	// An empty witness would decode without its Multisig, so it is refused
+	// rather than read back as a different transaction
+	tx := codecTestTransaction(t)
+	tx.Multisig = &Multisig{}
+	if _, err := DecodeTransaction(EncodeTransaction(&tx)); err == nil {
+		t.Fatal("empty multisig witness decoded")
+	}
+
+	// An empty Signatures slice is not written, so it reads back as nil
+	tx = codecTestTransaction(t)
+	tx.Signatures = []string{}
+	decoded, err := DecodeTransaction(EncodeTransaction(&tx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Multisig != nil || decoded.Signatures != nil {
+		t.Fatalf("witness decoded as %+v %v", decoded.Multisig, decoded.Signatures)
+	}
+
+	// Nor is an empty seal signature
+	block := &Block{Index: 1, Timestamp: 1700000000, PrevHash: "parent", Difficulty: 3}
+	block.Hash = calculateHash(block)
+	if _, err := DecodeBlock(append(EncodeBlock(block), 0)); err == nil {
+		t.Fatal("block with an empty seal signature decoded")
+	}
+}
+
+func TestHashesExcludeCodecVersion(t *testing.T) {
+	tx := codecTestTransaction(t)
+	data := EncodeTransaction(&tx)
+	if want := sha256.Sum256(data[1:]); !bytes.Equal(tx.Hash(), want[:]) {
+		t.Fatal("transaction hash covers the codec version")
+	}
+	if digest := sha256.Sum256(encodeSigningPayload(&tx)); tx.ComputeID() != hex.EncodeToString(digest[:]) {
+		t.Fatal("transaction ID is not the digest of its fields")
+	}
+
+	// The header preimage is the encoded header without its version byte
+	block := &Block{Index: 1, Timestamp: 1700000000, PrevHash: "parent", Difficulty: 3}
+	if !bytes.HasPrefix(EncodeBlock(block)[1:], encodeHeader(block)) {
+		t.Fatal("block hash covers the codec version")
+	}
+}
@@ -0,0 +1,85 @@
+package core
+
+import "time"
+
+// Clock tells a chain the current time
+type Clock interface {
+	Now() time.Time
+}
+
+// Config holds the consensus parameters of a chain
+type Config struct {
+	// ChainID distinguishes this chain from others started with similar parameters
+	ChainID uint64
+	// Difficulty is the difficulty of the genesis block, expressed as the
+	// expected number of hashes needed to mine a block
+	Difficulty uint64
+	// MiningReward is paid to the miner of every block
+	MiningReward float64
+	// HalvingInterval is the number of blocks after which the mining reward
+	// halves. Zero keeps it constant.
+	HalvingInterval int64
+	// MaxSupply caps the coins that can ever exist, including premined
+	// ones. Zero leaves supply unlimited.
+	MaxSupply float64
+	// CoinbaseMaturity is how many blocks a mining reward stays
+	// unspendable, so rewards of blocks lost in a reorg cannot be spent
+	CoinbaseMaturity int64
+	// RetargetInterval is the number of blocks between difficulty adjustments
+	RetargetInterval int64
+	// TargetBlockTime is the desired number of seconds between blocks
+	TargetBlockTime int64
+	// MaxBlockTransactions caps how many transactions a mined block includes
+	MaxBlockTransactions int
+	// Mempool limits the pending transaction pool
+	Mempool MempoolConfig
+	// Engine is the consensus algorithm. Nil selects proof-of-work with the
+	// retargeting schedule above.
+	Engine Engine
+	// GenesisTimestamp fixes the genesis block so every node derives the same
+	// one. Zero stamps a fresh genesis block with the current time.
+	GenesisTimestamp int64
+	// Alloc premines balances for addresses in the genesis block
+	Alloc map[string]float64
+	// Clock stamps new blocks and transactions and bounds future
+	// timestamps. Nil uses the system clock; simulations supply a virtual one.
+	Clock Clock
+}
+
+// DefaultConfig returns the parameters used when none are given
+func DefaultConfig() Config {
+	return Config{
+		Difficulty:           1,
+		MiningReward:         10,
+		RetargetInterval:     10,
+		TargetBlockTime:      15,
+		MaxBlockTransactions: 500,
+		Mempool:              DefaultMempoolConfig(),
+	}
+}
+
+// now returns the current time by the configured clock
+func (c Config) now() time.Time {
+	if c.Clock == nil {
+		return time.Now()
+	}
+	return c.Clock.Now()
+}
+
+// withDefaults fills in the parameters that must not be left zero
+func (c Config) withDefaults() Config {
+	if c.Difficulty == 0 {
+		c.Difficulty = 1
+	}
+	if c.Mempool == (MempoolConfig{}) {
+		c.Mempool = DefaultMempoolConfig()
+	}
+	if c.Engine == nil {
+		c.Engine = NewPoW(c.RetargetInterval, c.TargetBlockTime)
+	}
+	// PoA times its seals, so it must read the same clock as the chain
+	if poa, ok := c.Engine.(*PoA); ok && c.Clock != nil {
+		poa.SetClock(c.Clock)
+	}
+	return c
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"context"
+	"math/big"
+)
+
+// Engine is a consensus algorithm. The chain checks linkage, timestamps,
+// the Merkle root, the block hash and the transactions itself; the engine
+// owns the fields that decide who may produce a block and how much it weighs.
+type Engine interface {
+	// Prepare sets the consensus fields, such as difficulty and timestamp,
+	// of an unsealed block that will extend chain
+	Prepare(chain []*Block, block *Block) error
+	// Seal completes a prepared block so that VerifyHeader accepts it. It
+	// gives up with the context's error when ctx is cancelled and with
+	// ErrStaleBlock when tipChanged is closed.
+	Seal(ctx context.Context, block *Block, tipChanged <-chan struct{}) error
+	// VerifyHeader checks the consensus fields of a block against the
+	// chain it extends. block.Hash has already been checked.
+	VerifyHeader(chain []*Block, block *Block) error
+	// VerifySeal checks the consensus fields that need no chain context, so
+	// a block whose parent is unknown can be screened before it is kept
+	VerifySeal(block *Block) error
+	// Work returns the weight a block adds to its chain in fork choice;
+	// the chain with the most cumulative work wins
+	Work(block *Block) *big.Int
+}
+
+// Engine returns the consensus engine of the chain
+func (bc *Blockchain) Engine() Engine {
+	return bc.Config.Engine
+}
+
+// NextDifficulty returns the difficulty this node would give the next
+// block, or zero if it cannot seal one (for example a PoA node without a
+// signer key)
+func (bc *Blockchain) NextDifficulty() uint64 {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	parent := bc.Chain[len(bc.Chain)-1]
+	block := &Block{Index: parent.Index + 1, PrevHash: parent.Hash, Timestamp: bc.Config.now().Unix()}
+	if err := bc.Config.Engine.Prepare(bc.Chain, block); err != nil {
+		return 0
+	}
+	return block.Difficulty
+}
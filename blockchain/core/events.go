@@ -0,0 +1,175 @@
+package core
+
+import (
+	"errors"
+	"sync"
+)
+
+// maxQueuedEvents is how far a subscriber may fall behind before it is dropped
+const maxQueuedEvents = 1024
+
+// ErrSubscriberTooSlow is reported by a subscription dropped for falling
+// too far behind
+var ErrSubscriberTooSlow = errors.New("subscriber fell too far behind")
+
+// ReorgEvent describes a switch of the canonical chain to a heavier branch.
+// Removed blocks were on the old chain after the common ancestor and Added
+// blocks replace them, both in ascending order. CommonAncestor is nil when
+// the chains share no block, as when a fresh node adopts another genesis.
+type ReorgEvent struct {
+	CommonAncestor *Block   `json:"commonAncestor"`
+	Removed        []*Block `json:"removed"`
+	Added          []*Block `json:"added"`
+}
+
+// Subscription delivers events in the order they happened on C. Events are
+// queued so a slow reader never blocks the chain, but a reader that falls
+// more than maxQueuedEvents behind is dropped: C is closed and Err reports
+// ErrSubscriberTooSlow. C is also closed after Unsubscribe.
+type Subscription[T any] struct {
+	C <-chan T
+
+	c     chan T
+	mu    sync.Mutex
+	queue []T
+	err   error
+	wake  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+	feed  *feed[T]
+}
+
+// Unsubscribe stops delivery and closes C
+func (s *Subscription[T]) Unsubscribe() {
+	s.feed.remove(s)
+	s.close(nil)
+}
+
+// Err returns why the subscription ended, or nil if it is live or was
+// unsubscribed
+func (s *Subscription[T]) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// close ends delivery, recording err as the reason
+func (s *Subscription[T]) close(err error) {
+	s.once.Do(func() {
+		s.mu.Lock()
+		s.err = err
+		s.queue = nil
+		s.mu.Unlock()
+		close(s.done)
+	})
+}
+
+// push queues an event for delivery. It reports false if the queue is
+// full, in which case the event is not queued.
+func (s *Subscription[T]) push(event T) bool {
+	s.mu.Lock()
+	if len(s.queue) >= maxQueuedEvents {
+		s.mu.Unlock()
+		return false
+	}
+	s.queue = append(s.queue, event)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// deliver hands queued events to the reader one at a time
+func (s *Subscription[T]) deliver() {
+	defer close(s.c)
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			s.mu.Unlock()
+			select {
+			case <-s.wake:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+		event := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+
+		select {
+		case s.c <- event:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// feed fans events out to every subscription
+type feed[T any] struct {
+	mu   sync.Mutex
+	subs map[*Subscription[T]]bool
+}
+
+// subscribe adds a subscription to the feed
+func (f *feed[T]) subscribe() *Subscription[T] {
+	c := make(chan T)
+	sub := &Subscription[T]{
+		C:    c,
+		c:    c,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+		feed: f,
+	}
+
+	f.mu.Lock()
+	if f.subs == nil {
+		f.subs = map[*Subscription[T]]bool{}
+	}
+	f.subs[sub] = true
+	f.mu.Unlock()
+
+	go sub.deliver()
+	return sub
+}
+
+// send queues an event on every subscription, dropping those too far
+// behind to take it
+func (f *feed[T]) send(event T) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for sub := range f.subs {
+		if !sub.push(event) {
+			delete(f.subs, sub)
+			sub.close(ErrSubscriberTooSlow)
+		}
+	}
+}
+
+// remove drops a subscription from the feed
+func (f *feed[T]) remove(sub *Subscription[T]) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.subs, sub)
+}
+
+// SubscribeBlocks returns a subscription to blocks joining the canonical
+// chain, whether mined, received or adopted in a reorg. The blocks are
+// shared with the chain and must not be modified.
+func (bc *Blockchain) SubscribeBlocks() *Subscription[*Block] {
+	return bc.blockFeed.subscribe()
+}
+
+// SubscribeTransactions returns a subscription to transactions accepted
+// into the pending pool
+func (bc *Blockchain) SubscribeTransactions() *Subscription[Transaction] {
+	return bc.txFeed.subscribe()
+}
+
+// SubscribeReorgs returns a subscription to chain reorganizations
+func (bc *Blockchain) SubscribeReorgs() *Subscription[ReorgEvent] {
+	return bc.reorgFeed.subscribe()
+}
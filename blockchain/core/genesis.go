@@ -0,0 +1,222 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Types of the transactions in a genesis block
+const (
+	// TxTypeGenesis commits the chain ID to the genesis block
+	TxTypeGenesis = "genesis"
+	// TxTypeAlloc premines a balance for an address
+	TxTypeAlloc = "genesis_alloc"
+)
+
+// Genesis is the JSON file that defines a chain. Every node started from the
+// same file derives the same genesis block and consensus parameters.
+type Genesis struct {
+	// ChainID distinguishes this chain from others started with similar parameters
+	ChainID      uint64  `json:"chainId"`
+	Timestamp    int64   `json:"timestamp"`
+	Difficulty   uint64  `json:"difficulty"`
+	MiningReward float64 `json:"miningReward"`
+	// HalvingInterval is the number of blocks after which the mining reward
+	// halves, zero to keep it constant
+	HalvingInterval int64 `json:"halvingInterval,omitempty"`
+	// MaxSupply caps the coins that can ever exist, zero for no cap
+	MaxSupply float64 `json:"maxSupply,omitempty"`
+	// CoinbaseMaturity is how many blocks a mining reward stays unspendable
+	CoinbaseMaturity     int64 `json:"coinbaseMaturity,omitempty"`
+	RetargetInterval     int64 `json:"retargetInterval"`
+	TargetBlockTime      int64 `json:"targetBlockTime"`
+	MaxBlockTransactions int   `json:"maxBlockTransactions"`
+	// PoA selects proof-of-authority when set, otherwise blocks are mined
+	PoA *GenesisPoA `json:"poa,omitempty"`
+	// Alloc premines balances, such as for treasury addresses
+	Alloc map[string]float64 `json:"alloc,omitempty"`
+}
+
+// GenesisPoA holds the proof-of-authority parameters of a genesis file
+type GenesisPoA struct {
+	// Signers are the addresses allowed to seal blocks, in turn order
+	Signers []string `json:"signers"`
+	// Period is the minimum number of seconds between blocks
+	Period int64 `json:"period"`
+}
+
+// LoadGenesis reads and checks a genesis file
+func LoadGenesis(path string) (*Genesis, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read genesis file: %v", err)
+	}
+
+	genesis := &Genesis{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(genesis); err != nil {
+		return nil, fmt.Errorf("invalid genesis file: %v", err)
+	}
+	if err := genesis.Validate(); err != nil {
+		return nil, err
+	}
+	return genesis, nil
+}
+
+// Save writes the genesis file to path
+func (g *Genesis) Save(path string) error {
+	data, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+// Validate checks that the genesis parameters describe a usable chain
+func (g *Genesis) Validate() error {
+	if g.ChainID == 0 {
+		return fmt.Errorf("genesis chain ID must be set")
+	}
+	if g.Timestamp <= 0 {
+		return fmt.Errorf("genesis timestamp must be set")
+	}
+	if g.Difficulty == 0 {
+		return fmt.Errorf("genesis difficulty must be positive")
+	}
+	if !validAmount(g.MiningReward) {
+		return fmt.Errorf("mining reward must be finite and not negative")
+	}
+	if !validAmount(g.MaxSupply) {
+		return fmt.Errorf("maximum supply must be finite and not negative")
+	}
+	if g.HalvingInterval < 0 || g.CoinbaseMaturity < 0 ||
+		g.RetargetInterval < 0 || g.TargetBlockTime < 0 || g.MaxBlockTransactions < 0 {
+		return fmt.Errorf("genesis limits cannot be negative")
+	}
+
+	if g.PoA != nil {
+		if len(g.PoA.Signers) == 0 {
+			return fmt.Errorf("proof-of-authority needs at least one signer")
+		}
+		seen := map[string]bool{}
+		for _, signer := range g.PoA.Signers {
+			if !isAddress(signer) {
+				return fmt.Errorf("invalid signer address %q", signer)
+			}
+			if seen[strings.ToLower(signer)] {
+				return fmt.Errorf("signer %s is listed twice", signer)
+			}
+			seen[strings.ToLower(signer)] = true
+		}
+		if g.PoA.Period < 0 {
+			return fmt.Errorf("block period cannot be negative")
+		}
+	}
+
+	seen := map[string]bool{}
+	var premine float64
+	for address, amount := range g.Alloc {
+		if !isAddress(address) {
+			return fmt.Errorf("invalid alloc address %q", address)
+		}
+		if seen[strings.ToLower(address)] {
+			return fmt.Errorf("address %s is allocated twice", address)
+		}
+		seen[strings.ToLower(address)] = true
+		if !validAmount(amount) || amount == 0 {
+			return fmt.Errorf("alloc for %s must be positive and finite", address)
+		}
+		premine += amount
+	}
+	if g.MaxSupply > 0 && premine > g.MaxSupply {
+		return fmt.Errorf("premined %v exceeds the maximum supply of %v", premine, g.MaxSupply)
+	}
+	return nil
+}
+
+// Config returns the chain config the genesis file describes. Limits left
+// out of the file take their default values.
+func (g *Genesis) Config() Config {
+	config := DefaultConfig()
+	config.ChainID = g.ChainID
+	config.Difficulty = g.Difficulty
+	config.MiningReward = g.MiningReward
+	config.HalvingInterval = g.HalvingInterval
+	config.MaxSupply = g.MaxSupply
+	config.CoinbaseMaturity = g.CoinbaseMaturity
+	config.GenesisTimestamp = g.Timestamp
+	if g.RetargetInterval > 0 {
+		config.RetargetInterval = g.RetargetInterval
+	}
+	if g.TargetBlockTime > 0 {
+		config.TargetBlockTime = g.TargetBlockTime
+	}
+	if g.MaxBlockTransactions > 0 {
+		config.MaxBlockTransactions = g.MaxBlockTransactions
+	}
+	if g.PoA != nil {
+		config.Engine = NewPoA(g.PoA.Signers, g.PoA.Period)
+	}
+	if len(g.Alloc) > 0 {
+		config.Alloc = map[string]float64{}
+		for address, amount := range g.Alloc {
+			config.Alloc[strings.ToLower(address)] = amount
+		}
+	}
+	return config
+}
+
+// Block returns the genesis block the file describes
+func (g *Genesis) Block() *Block {
+	return newGenesisBlock(g.Config())
+}
+
+// genesisTransactions returns the transactions of the genesis block: one
+// committing to the chain ID, if set, then the premined balances sorted by
+// address so every node builds them in the same order
+func genesisTransactions(config Config) []Transaction {
+	txs := []Transaction{}
+	if config.ChainID != 0 {
+		txs = append(txs, Transaction{
+			From:      SystemAddress,
+			ChainID:   config.ChainID,
+			Timestamp: config.GenesisTimestamp,
+			Data:      map[string]interface{}{"type": TxTypeGenesis, "chainId": config.ChainID},
+		})
+	}
+
+	addresses := make([]string, 0, len(config.Alloc))
+	for address := range config.Alloc {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	for _, address := range addresses {
+		txs = append(txs, Transaction{
+			From:      SystemAddress,
+			To:        address,
+			Amount:    config.Alloc[address],
+			ChainID:   config.ChainID,
+			Timestamp: config.GenesisTimestamp,
+			Data:      map[string]interface{}{"type": TxTypeAlloc},
+		})
+	}
+	for i := range txs {
+		txs[i].ID = txs[i].ComputeID()
+	}
+	return txs
+}
+
+// isAddress reports whether s looks like an account address, in any case
+func isAddress(s string) bool {
+	if len(s) != 42 || !strings.HasPrefix(s, "0x") {
+		return false
+	}
+	_, err := hex.DecodeString(s[2:])
+	return err == nil
+}
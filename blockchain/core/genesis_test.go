@@ -0,0 +1,95 @@
+package core
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+// testGenesis returns a valid genesis with two premined addresses
+func testGenesis() *Genesis {
+	return &Genesis{
+		ChainID:      9,
+		Timestamp:    1700000000,
+		Difficulty:   1,
+		MiningReward: 10,
+		Alloc: map[string]float64{
+			"0x00000000000000000000000000000000000000aa": 100,
+			"0x00000000000000000000000000000000000000bb": 50,
+		},
+	}
+}
+
+func TestGenesisIsDeterministic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "genesis.json")
+	if err := testGenesis().Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two nodes loading the same file open the same chain
+	hashes := make([]string, 2)
+	for i := range hashes {
+		genesis, err := LoadGenesis(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chain, err := OpenBlockchain(nil, genesis.Config())
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes[i] = chain.GetBlock(0).Hash
+		if hashes[i] != genesis.Block().Hash {
+			t.Fatal("opened chain does not start with the genesis block")
+		}
+	}
+	if hashes[0] != hashes[1] {
+		t.Fatalf("nodes derived genesis blocks %s and %s", hashes[0], hashes[1])
+	}
+
+	// Any change to the chain ID or allocations gives another chain
+	for name, alter := range map[string]func(g *Genesis){
+		"chain ID":        func(g *Genesis) { g.ChainID = 10 },
+		"alloc amount":    func(g *Genesis) { g.Alloc["0x00000000000000000000000000000000000000bb"] = 51 },
+		"alloc recipient": func(g *Genesis) { g.Alloc["0x00000000000000000000000000000000000000cc"] = 1 },
+	} {
+		genesis := testGenesis()
+		alter(genesis)
+		if genesis.Block().Hash == hashes[0] {
+			t.Errorf("genesis with another %s has the same hash", name)
+		}
+	}
+
+	// The case of an address is not part of it
+	mixed := testGenesis()
+	delete(mixed.Alloc, "0x00000000000000000000000000000000000000aa")
+	mixed.Alloc["0x00000000000000000000000000000000000000AA"] = 100
+	if mixed.Block().Hash != hashes[0] {
+		t.Error("address case changed the genesis hash")
+	}
+}
+
+func TestGenesisValidate(t *testing.T) {
+	if err := testGenesis().Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for name, alter := range map[string]func(g *Genesis){
+		"no chain ID":         func(g *Genesis) { g.ChainID = 0 },
+		"no timestamp":        func(g *Genesis) { g.Timestamp = 0 },
+		"zero difficulty":     func(g *Genesis) { g.Difficulty = 0 },
+		"NaN reward":          func(g *Genesis) { g.MiningReward = math.NaN() },
+		"infinite supply":     func(g *Genesis) { g.MaxSupply = math.Inf(1) },
+		"premine over supply": func(g *Genesis) { g.MaxSupply = 100 },
+		"zero alloc":          func(g *Genesis) { g.Alloc["0x00000000000000000000000000000000000000aa"] = 0 },
+		"negative alloc":      func(g *Genesis) { g.Alloc["0x00000000000000000000000000000000000000aa"] = -1 },
+		"NaN alloc":           func(g *Genesis) { g.Alloc["0x00000000000000000000000000000000000000aa"] = math.NaN() },
+		"infinite alloc":      func(g *Genesis) { g.Alloc["0x00000000000000000000000000000000000000aa"] = math.Inf(1) },
+		"bad alloc address":   func(g *Genesis) { g.Alloc["0xaa"] = 1 },
+		"duplicate alloc":     func(g *Genesis) { g.Alloc["0x00000000000000000000000000000000000000AA"] = 1 },
+	} {
+		genesis := testGenesis()
+		alter(genesis)
+		if err := genesis.Validate(); err == nil {
+			t.Errorf("genesis with %s accepted", name)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// newTestKey generates a random signing key
+func newTestKey(t *testing.T) *secp256k1.PrivateKey {
+	t.Helper()
+	key, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// signedTransfer returns a transfer from key's address signed with the given nonce
+func signedTransfer(t *testing.T, key *secp256k1.PrivateKey, to string, amount, fee float64, nonce uint64) Transaction {
+	t.Helper()
+	tx := Transaction{To: to, Amount: amount, Fee: fee, Nonce: nonce, Timestamp: 1700000000}
+	if err := SignTransaction(&tx, key); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
@@ -0,0 +1,200 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// MaxHeaders is the most headers GetHeaders returns at once
+const MaxHeaders = 2000
+
+var (
+	// ErrKnownBlock is returned when a block is already in the block tree or orphan pool
+	ErrKnownBlock = errors.New("block already known")
+	// ErrUnknownParent is returned when a block's parent is not known yet
+	ErrUnknownParent = errors.New("block parent is unknown")
+)
+
+// LastBlock returns the current tip of the chain
+func (bc *Blockchain) LastBlock() *Block {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.Chain[len(bc.Chain)-1]
+}
+
+// Height returns the index of the current tip
+func (bc *Blockchain) Height() int64 {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.Chain[len(bc.Chain)-1].Index
+}
+
+// GetBlock returns the block at the given index, or nil if there is none
+func (bc *Blockchain) GetBlock(index int64) *Block {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if index < 0 || index >= int64(len(bc.Chain)) {
+		return nil
+	}
+	return bc.Chain[index]
+}
+
+// GetBlocks returns the blocks from the given index up to the tip
+func (bc *Blockchain) GetBlocks(from int64) []*Block {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if from < 0 {
+		from = 0
+	}
+	if from >= int64(len(bc.Chain)) {
+		return []*Block{}
+	}
+	return append([]*Block{}, bc.Chain[from:]...)
+}
+
+// GetHeaders returns up to count header-only blocks starting at the given
+// index, capped at MaxHeaders
+func (bc *Blockchain) GetHeaders(from int64, count int) []*Block {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if count <= 0 || count > MaxHeaders {
+		count = MaxHeaders
+	}
+	headers := []*Block{}
+	for i := from; i >= 0 && i < int64(len(bc.Chain)) && len(headers) < count; i++ {
+		headers = append(headers, bc.Chain[i].Header())
+	}
+	return headers
+}
+
+// Locator lists hashes of the chain from the tip back to genesis, ten in a
+// row and then at doubling intervals, so a peer can find the fork point in
+// one round trip
+func (bc *Blockchain) Locator() []string {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	locator := []string{}
+	step := int64(1)
+	for height := int64(len(bc.Chain)) - 1; ; height -= step {
+		if height <= 0 {
+			return append(locator, bc.Chain[0].Hash)
+		}
+		locator = append(locator, bc.Chain[height].Hash)
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+}
+
+// BlocksAfter returns up to count blocks following the first locator hash
+// found on the chain, and false if none of them is on it
+func (bc *Blockchain) BlocksAfter(locator []string, count int) ([]*Block, bool) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	for _, hash := range locator {
+		height, ok := bc.index.heights[hash]
+		if !ok {
+			continue
+		}
+		end := min(height+1+int64(count), int64(len(bc.Chain)))
+		return append([]*Block{}, bc.Chain[height+1:end]...), true
+	}
+	return nil, false
+}
+
+// CumulativeWork returns the total proof of work behind the current chain
+func (bc *Blockchain) CumulativeWork() *big.Int {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.chainWork(bc.Chain)
+}
+
+// AddBlock validates a block received from elsewhere and adds it to the
+// block tree. A block extending the tip is appended; one on a side branch is
+// kept and triggers a reorg once its branch carries the most cumulative work.
+// A block whose parent is unknown is held as an orphan until the parent
+// arrives, and ErrUnknownParent is returned so the caller can fetch it.
+func (bc *Blockchain) AddBlock(block *Block) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if err := bc.addBlock(block); err != nil {
+		return err
+	}
+	bc.connectOrphans(block)
+	return nil
+}
+
+// addBlock adds a single block to the tree without connecting orphans
+func (bc *Blockchain) addBlock(block *Block) error {
+	if _, ok := bc.nodes[block.Hash]; ok || bc.orphans.has(block.Hash) {
+		return ErrKnownBlock
+	}
+
+	parent, ok := bc.nodes[block.PrevHash]
+	if !ok {
+		// Only well-formed, sealed blocks may wait in the pool, so it cannot
+		// be filled with junk that evicts real orphans
+		if err := bc.checkOrphan(block); err != nil {
+			return err
+		}
+		bc.orphans.add(block)
+		return ErrUnknownParent
+	}
+
+	tip := bc.Chain[len(bc.Chain)-1]
+	if parent.block.Hash != tip.Hash {
+		return bc.addSideBlock(block, parent)
+	}
+
+	state := bc.state.Copy()
+	if err := bc.validateBlock(bc.Chain, block, state, bc.index.hasTransaction); err != nil {
+		return err
+	}
+
+	return bc.connectBlock(block, state)
+}
+
+// ReplaceChain switches to the given chain if it is valid and carries more
+// cumulative work than the current one. Unless the local chain holds nothing
+// but its genesis block, the candidate must share the same genesis.
+func (bc *Blockchain) ReplaceChain(chain []*Block) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if len(chain) == 0 {
+		return fmt.Errorf("candidate chain is empty")
+	}
+	if len(bc.Chain) > 1 && chain[0].Hash != bc.Chain[0].Hash {
+		return fmt.Errorf("candidate chain has a different genesis block")
+	}
+	if bc.chainWork(chain).Cmp(bc.chainWork(bc.Chain)) <= 0 {
+		return fmt.Errorf("candidate chain does not carry more work")
+	}
+
+	state, err := bc.validateChain(chain)
+	if err != nil {
+		return fmt.Errorf("candidate chain is invalid: %v", err)
+	}
+
+	if err := bc.switchChain(chain, state); err != nil {
+		return err
+	}
+	bc.connectOrphans(chain...)
+	return nil
+}
+
+// chainWork sums the fork-choice weight the engine gives each block
+func (bc *Blockchain) chainWork(chain []*Block) *big.Int {
+	work := new(big.Int)
+	for _, block := range chain {
+		work.Add(work, bc.Config.Engine.Work(block))
+	}
+	return work
+}
@@ -0,0 +1,153 @@
+package core
+
+// Page sizes for paginated queries
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// txLocation is where a transaction sits in the canonical chain
+type txLocation struct {
+	height   int64
+	position int
+}
+
+// chainIndex maps transaction IDs, addresses and block hashes to the
+// canonical chain so lookups do not scan every block. Blocks are only ever
+// added at, and removed from, the tip.
+type chainIndex struct {
+	txs map[string]txLocation
+	// addresses lists the transactions sending to or from each address,
+	// oldest first
+	addresses map[string][]txLocation
+	heights   map[string]int64
+}
+
+// newChainIndex indexes the given chain
+func newChainIndex(chain []*Block) *chainIndex {
+	index := &chainIndex{
+		txs:       map[string]txLocation{},
+		addresses: map[string][]txLocation{},
+		heights:   map[string]int64{},
+	}
+	for _, block := range chain {
+		index.addBlock(block)
+	}
+	return index
+}
+
+// addBlock indexes a block appended to the tip
+func (ix *chainIndex) addBlock(block *Block) {
+	ix.heights[block.Hash] = block.Index
+	for i, tx := range block.Transactions {
+		location := txLocation{height: block.Index, position: i}
+		ix.txs[tx.ID] = location
+		for _, address := range txAddresses(&tx) {
+			ix.addresses[address] = append(ix.addresses[address], location)
+		}
+	}
+}
+
+// removeBlock unindexes the tip block when it is rolled back
+func (ix *chainIndex) removeBlock(block *Block) {
+	delete(ix.heights, block.Hash)
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		delete(ix.txs, tx.ID)
+		for _, address := range txAddresses(tx) {
+			// The block is the tip, so its entries are the last ones
+			locations := ix.addresses[address]
+			for len(locations) > 0 && locations[len(locations)-1].height == block.Index {
+				locations = locations[:len(locations)-1]
+			}
+			if len(locations) == 0 {
+				delete(ix.addresses, address)
+			} else {
+				ix.addresses[address] = locations
+			}
+		}
+	}
+}
+
+// hasTransaction reports whether a transaction is in the canonical chain
+func (ix *chainIndex) hasTransaction(id string) bool {
+	_, ok := ix.txs[id]
+	return ok
+}
+
+// txAddresses returns the distinct accounts a transaction touches
+func txAddresses(tx *Transaction) []string {
+	addresses := []string{}
+	if tx.From != SystemAddress {
+		addresses = append(addresses, tx.From)
+	}
+	if tx.To != "" && tx.To != tx.From {
+		addresses = append(addresses, tx.To)
+	}
+	return addresses
+}
+
+// TransactionRecord is a mined transaction with the block that includes it
+type TransactionRecord struct {
+	Transaction Transaction `json:"transaction"`
+	BlockIndex  int64       `json:"blockIndex"`
+	BlockHash   string      `json:"blockHash"`
+}
+
+// TransactionPage is one page of a transaction listing
+type TransactionPage struct {
+	Transactions []TransactionRecord `json:"transactions"`
+	// Total is the number of transactions across all pages
+	Total  int `json:"total"`
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+// BlockHeight returns the height of a block on the canonical chain
+func (bc *Blockchain) BlockHeight(hash string) (int64, bool) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	height, ok := bc.index.heights[hash]
+	return height, ok
+}
+
+// AddressTransactions lists the mined transactions sent to or from an
+// address, newest first, skipping offset of them and returning at most
+// limit. A limit of zero or less selects DefaultPageSize.
+func (bc *Blockchain) AddressTransactions(address string, offset, limit int) TransactionPage {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	locations := bc.index.addresses[address]
+	page := TransactionPage{
+		Transactions: []TransactionRecord{},
+		Total:        len(locations),
+		Offset:       offset,
+		Limit:        limit,
+	}
+	for i := len(locations) - 1 - offset; i >= 0 && len(page.Transactions) < limit; i-- {
+		page.Transactions = append(page.Transactions, bc.transactionAt(locations[i]))
+	}
+	return page
+}
+
+// transactionAt returns the indexed transaction at a location
+func (bc *Blockchain) transactionAt(location txLocation) TransactionRecord {
+	block := bc.Chain[location.height]
+	return TransactionRecord{
+		Transaction: block.Transactions[location.position],
+		BlockIndex:  block.Index,
+		BlockHash:   block.Hash,
+	}
+}
@@ -0,0 +1,113 @@
+package core
+
+import "testing"
+
+func TestIndexFollowsReorg(t *testing.T) {
+	key := newTestKey(t)
+	sender := AddressFromPublicKey(key.PubKey())
+	alloc := map[string]float64{sender: 100}
+	local, remote := testChain(t, alloc), testChain(t, alloc)
+	const to = "0x00000000000000000000000000000000000000aa"
+
+	abandonedTx := signedTransfer(t, key, to, 5, 0.1, 0)
+	if err := local.AddTransaction(abandonedTx); err != nil {
+		t.Fatal(err)
+	}
+	abandoned := mineBlocks(t, local, "0x00000000000000000000000000000000000000a1", 1)
+	branchTx := signedTransfer(t, key, to, 7, 0.1, 0)
+	if err := remote.AddTransaction(branchTx); err != nil {
+		t.Fatal(err)
+	}
+	branch := mineBlocks(t, remote, "0x00000000000000000000000000000000000000a2", 2)
+	for _, block := range branch {
+		if err := local.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if local.LastBlock().Hash != branch[1].Hash {
+		t.Fatal("chain did not switch to the heavier branch")
+	}
+
+	// The abandoned block and its transactions leave the index
+	if local.GetBlockByHash(abandoned[0].Hash) != nil {
+		t.Fatal("abandoned block still indexed")
+	}
+	if _, ok := local.BlockHeight(abandoned[0].Hash); ok {
+		t.Fatal("abandoned block still has a height")
+	}
+	if _, block := local.GetTransaction(abandoned[0].Transactions[1].ID); block != nil {
+		t.Fatal("abandoned reward still indexed")
+	}
+	if page := local.AddressTransactions("0x00000000000000000000000000000000000000a1", 0, 0); page.Total != 0 {
+		t.Fatalf("abandoned miner has %d transactions, want none", page.Total)
+	}
+
+	// The branch takes its place
+	for i, block := range branch {
+		if height, ok := local.BlockHeight(block.Hash); !ok || height != int64(i+1) {
+			t.Fatalf("branch block %d at height %d, %v", i+1, height, ok)
+		}
+	}
+	if _, block := local.GetTransaction(branchTx.ID); block == nil || block.Hash != branch[0].Hash {
+		t.Fatal("branch transaction not indexed in its block")
+	}
+	// The sender's other entry is its genesis allocation
+	for address, total := range map[string]int{sender: 2, to: 1} {
+		page := local.AddressTransactions(address, 0, 0)
+		if page.Total != total || page.Transactions[0].Transaction.ID != branchTx.ID {
+			t.Fatalf("%s lists %+v, want the branch transfer newest", address, page.Transactions)
+		}
+	}
+	if page := local.AddressTransactions("0x00000000000000000000000000000000000000a2", 0, 0); page.Total != 2 {
+		t.Fatalf("branch miner has %d transactions, want 2", page.Total)
+	}
+}
+
+func TestAddressTransactionsPagination(t *testing.T) {
+	key := newTestKey(t)
+	chain := testChain(t, map[string]float64{AddressFromPublicKey(key.PubKey()): 100})
+	const to = "0x00000000000000000000000000000000000000aa"
+	for nonce := uint64(0); nonce < 3; nonce++ {
+		if err := chain.AddTransaction(signedTransfer(t, key, to, 1, 0, nonce)); err != nil {
+			t.Fatal(err)
+		}
+		mineBlocks(t, chain, "0x00000000000000000000000000000000000000bb", 1)
+	}
+
+	// Nonces are listed newest first
+	for _, test := range []struct {
+		name          string
+		offset, limit int
+		nonces        []uint64
+		wantOffset    int
+		wantLimit     int
+	}{
+		{"first page", 0, 2, []uint64{2, 1}, 0, 2},
+		{"last page", 2, 2, []uint64{0}, 2, 2},
+		{"offset at the end", 3, 2, nil, 3, 2},
+		{"offset past the end", 10, 2, nil, 10, 2},
+		{"negative offset", -1, 1, []uint64{2}, 0, 1},
+		{"zero limit", 0, 0, []uint64{2, 1, 0}, 0, DefaultPageSize},
+		{"negative limit", 1, -1, []uint64{1, 0}, 1, DefaultPageSize},
+		{"limit over the maximum", 0, MaxPageSize + 1, []uint64{2, 1, 0}, 0, MaxPageSize},
+	} {
+		page := chain.AddressTransactions(to, test.offset, test.limit)
+		if page.Total != 3 || page.Offset != test.wantOffset || page.Limit != test.wantLimit {
+			t.Errorf("%s: total %d, offset %d, limit %d", test.name, page.Total, page.Offset, page.Limit)
+		}
+		// An empty page still encodes as a list
+		if page.Transactions == nil || len(page.Transactions) != len(test.nonces) {
+			t.Errorf("%s: got %d transactions, want %d", test.name, len(page.Transactions), len(test.nonces))
+			continue
+		}
+		for i, record := range page.Transactions {
+			if record.Transaction.Nonce != test.nonces[i] || record.BlockIndex != int64(test.nonces[i]+1) {
+				t.Errorf("%s: entry %d is nonce %d in block %d", test.name, i, record.Transaction.Nonce, record.BlockIndex)
+			}
+		}
+	}
+
+	if page := chain.AddressTransactions("0x00000000000000000000000000000000000000cc", 0, 0); page.Total != 0 || page.Transactions == nil || len(page.Transactions) != 0 {
+		t.Fatalf("unknown address gave %+v", page)
+	}
+}
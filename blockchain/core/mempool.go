@@ -0,0 +1,339 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrMempoolFull is returned when a transaction's fee is too low to evict anything
+	ErrMempoolFull = errors.New("mempool is full")
+	// ErrReplacementUnderpriced is returned when a replacement does not raise the fee enough
+	ErrReplacementUnderpriced = errors.New("replacement transaction underpriced")
+)
+
+// MempoolConfig limits what the mempool will hold
+type MempoolConfig struct {
+	// MaxCount is the maximum number of pending transactions
+	MaxCount int
+	// MaxSize is the maximum total encoded size of pending transactions in bytes
+	MaxSize int
+	// MaxPerSender is the maximum number of pending transactions from one address
+	MaxPerSender int
+	// Expiry is how long a transaction may wait before it is dropped
+	Expiry time.Duration
+	// ReplaceBump is the minimum fractional fee increase for replace-by-fee
+	ReplaceBump float64
+}
+
+// DefaultMempoolConfig returns the limits used when none are given
+func DefaultMempoolConfig() MempoolConfig {
+	return MempoolConfig{
+		MaxCount:     5000,
+		MaxSize:      8 << 20,
+		MaxPerSender: 64,
+		Expiry:       3 * time.Hour,
+		ReplaceBump:  0.1,
+	}
+}
+
+// mempoolEntry is a pending transaction with its bookkeeping
+type mempoolEntry struct {
+	tx    Transaction
+	size  int
+	added time.Time
+}
+
+// Mempool holds pending transactions ordered by fee. It is not safe for
+// concurrent use on its own; the Blockchain that owns it guards it.
+type Mempool struct {
+	config   MempoolConfig
+	entries  map[string]*mempoolEntry
+	bySender map[string]map[uint64]*mempoolEntry
+	size     int
+	// clock stamps transactions passed to Add. Nil uses the system clock.
+	clock Clock
+}
+
+// NewMempool creates an empty mempool with the given limits
+func NewMempool(config MempoolConfig) *Mempool {
+	return &Mempool{
+		config:   config,
+		entries:  map[string]*mempoolEntry{},
+		bySender: map[string]map[uint64]*mempoolEntry{},
+	}
+}
+
+// newMempool creates an empty mempool for a chain, stamping transactions
+// with the chain's clock
+func newMempool(config Config) *Mempool {
+	m := NewMempool(config.Mempool)
+	m.clock = config.Clock
+	return m
+}
+
+// Len returns the number of pending transactions
+func (m *Mempool) Len() int {
+	return len(m.entries)
+}
+
+// Size returns the total encoded size of pending transactions
+func (m *Mempool) Size() int {
+	return m.size
+}
+
+// Has reports whether a transaction ID is pending
+func (m *Mempool) Has(id string) bool {
+	_, ok := m.entries[id]
+	return ok
+}
+
+// Get returns a pending transaction by ID
+func (m *Mempool) Get(id string) (Transaction, bool) {
+	entry, ok := m.entries[id]
+	if !ok {
+		return Transaction{}, false
+	}
+	return entry.tx, true
+}
+
+// Conflict returns the pending transaction from the same sender with the same nonce
+func (m *Mempool) Conflict(tx *Transaction) (Transaction, bool) {
+	entry, ok := m.bySender[tx.From][tx.Nonce]
+	if !ok {
+		return Transaction{}, false
+	}
+	return entry.tx, true
+}
+
+// SenderTransactions returns the pending transactions of one sender
+func (m *Mempool) SenderTransactions(sender string) []Transaction {
+	txs := []Transaction{}
+	for _, entry := range m.sortedSender(sender) {
+		txs = append(txs, entry.tx)
+	}
+	return txs
+}
+
+// Add inserts a transaction, enforcing duplicate, per-sender, replace-by-fee
+// and capacity rules. It returns whichever transactions were replaced or
+// evicted to make room.
+func (m *Mempool) Add(tx Transaction) ([]Transaction, error) {
+	now := time.Now()
+	if m.clock != nil {
+		now = m.clock.Now()
+	}
+	return m.add(tx, now)
+}
+
+// add inserts a transaction with an explicit arrival time
+func (m *Mempool) add(tx Transaction, added time.Time) ([]Transaction, error) {
+	if m.Has(tx.ID) {
+		return nil, fmt.Errorf("transaction %s is already pending", tx.ID)
+	}
+	// A NaN fee would slip past every comparison below
+	if !validAmount(tx.Fee) {
+		return nil, fmt.Errorf("transaction fee %v is invalid", tx.Fee)
+	}
+
+	entry := &mempoolEntry{tx: tx, size: len(EncodeTransaction(&tx)), added: added}
+	if m.config.MaxSize > 0 && entry.size > m.config.MaxSize {
+		return nil, fmt.Errorf("transaction of %d bytes exceeds the mempool size limit", entry.size)
+	}
+
+	// A transaction with the same sender and nonce replaces the old one if it pays enough more
+	existing, replacing := m.bySender[tx.From][tx.Nonce]
+	if replacing {
+		minFee := existing.tx.Fee * (1 + m.config.ReplaceBump)
+		if tx.Fee <= existing.tx.Fee || tx.Fee < minFee {
+			return nil, ErrReplacementUnderpriced
+		}
+	} else if m.config.MaxPerSender > 0 && len(m.bySender[tx.From]) >= m.config.MaxPerSender {
+		return nil, fmt.Errorf("sender %s already has %d pending transactions", tx.From, len(m.bySender[tx.From]))
+	}
+
+	removed := []Transaction{}
+	if replacing {
+		m.remove(existing)
+		removed = append(removed, existing.tx)
+	}
+
+	// Evict the cheapest transactions until the new one fits
+	evicted, ok := m.makeRoom(entry)
+	if !ok {
+		if replacing {
+			m.insert(existing)
+		}
+		return nil, ErrMempoolFull
+	}
+	for _, victim := range evicted {
+		m.remove(victim)
+		removed = append(removed, victim.tx)
+	}
+
+	m.insert(entry)
+	return removed, nil
+}
+
+// Remove drops a transaction by ID
+func (m *Mempool) Remove(id string) {
+	if entry, ok := m.entries[id]; ok {
+		m.remove(entry)
+	}
+}
+
+// Expire drops every transaction that has waited longer than the configured expiry
+func (m *Mempool) Expire(now time.Time) []Transaction {
+	if m.config.Expiry <= 0 {
+		return nil
+	}
+
+	expired := []Transaction{}
+	for _, entry := range m.entries {
+		if now.Sub(entry.added) > m.config.Expiry {
+			expired = append(expired, entry.tx)
+		}
+	}
+	for _, tx := range expired {
+		m.Remove(tx.ID)
+	}
+	return expired
+}
+
+// Transactions returns the pending transactions in mining priority order:
+// highest fee first, while keeping each sender's transactions in nonce order
+func (m *Mempool) Transactions() []Transaction {
+	queues := map[string][]*mempoolEntry{}
+	for sender := range m.bySender {
+		queues[sender] = m.sortedSender(sender)
+	}
+
+	txs := make([]Transaction, 0, len(m.entries))
+	for len(queues) > 0 {
+		// Pick the sender whose next transaction pays the most
+		var best string
+		var bestEntry *mempoolEntry
+		for sender, queue := range queues {
+			head := queue[0]
+			if bestEntry == nil || higherPriority(head, bestEntry) {
+				best, bestEntry = sender, head
+			}
+		}
+
+		txs = append(txs, bestEntry.tx)
+		if len(queues[best]) == 1 {
+			delete(queues, best)
+		} else {
+			queues[best] = queues[best][1:]
+		}
+	}
+
+	return txs
+}
+
+// entriesByArrival returns every entry, oldest first
+func (m *Mempool) entriesByArrival() []*mempoolEntry {
+	entries := make([]*mempoolEntry, 0, len(m.entries))
+	for _, entry := range m.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].added.Equal(entries[j].added) {
+			return entries[i].added.Before(entries[j].added)
+		}
+		return entries[i].tx.ID < entries[j].tx.ID
+	})
+	return entries
+}
+
+// makeRoom picks the cheapest entries to evict so that entry fits. It
+// reports false if that would mean evicting something paying at least as much.
+func (m *Mempool) makeRoom(entry *mempoolEntry) ([]*mempoolEntry, bool) {
+	count := len(m.entries) + 1
+	size := m.size + entry.size
+	if m.fits(count, size) {
+		return nil, true
+	}
+
+	candidates := make([]*mempoolEntry, 0, len(m.entries))
+	for _, e := range m.entries {
+		candidates = append(candidates, e)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return higherPriority(candidates[j], candidates[i])
+	})
+
+	evicted := []*mempoolEntry{}
+	for _, victim := range candidates {
+		if m.fits(count, size) {
+			break
+		}
+		if victim.tx.Fee >= entry.tx.Fee {
+			return nil, false
+		}
+		evicted = append(evicted, victim)
+		count--
+		size -= victim.size
+	}
+
+	return evicted, m.fits(count, size)
+}
+
+// fits reports whether the given totals are within the configured limits
+func (m *Mempool) fits(count, size int) bool {
+	if m.config.MaxCount > 0 && count > m.config.MaxCount {
+		return false
+	}
+	if m.config.MaxSize > 0 && size > m.config.MaxSize {
+		return false
+	}
+	return true
+}
+
+// insert adds an entry to every index
+func (m *Mempool) insert(entry *mempoolEntry) {
+	m.entries[entry.tx.ID] = entry
+	if m.bySender[entry.tx.From] == nil {
+		m.bySender[entry.tx.From] = map[uint64]*mempoolEntry{}
+	}
+	m.bySender[entry.tx.From][entry.tx.Nonce] = entry
+	m.size += entry.size
+}
+
+// remove deletes an entry from every index
+func (m *Mempool) remove(entry *mempoolEntry) {
+	delete(m.entries, entry.tx.ID)
+	if senderTxs := m.bySender[entry.tx.From]; senderTxs != nil {
+		delete(senderTxs, entry.tx.Nonce)
+		if len(senderTxs) == 0 {
+			delete(m.bySender, entry.tx.From)
+		}
+	}
+	m.size -= entry.size
+}
+
+// sortedSender returns a sender's entries in nonce order
+func (m *Mempool) sortedSender(sender string) []*mempoolEntry {
+	entries := make([]*mempoolEntry, 0, len(m.bySender[sender]))
+	for _, entry := range m.bySender[sender] {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].tx.Nonce < entries[j].tx.Nonce
+	})
+	return entries
+}
+
+// higherPriority orders entries by fee, then by arrival time, then by ID.
+// Fees are finite, as add refuses any other, so the order is total.
+func higherPriority(a, b *mempoolEntry) bool {
+	if a.tx.Fee != b.tx.Fee {
+		return a.tx.Fee > b.tx.Fee
+	}
+	if !a.added.Equal(b.added) {
+		return a.added.Before(b.added)
+	}
+	return a.tx.ID < b.tx.ID
+}
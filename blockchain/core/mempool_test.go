@@ -0,0 +1,196 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+// pendingTx returns an unsigned transaction for exercising the mempool
+func pendingTx(from string, nonce uint64, fee float64) Transaction {
+	return Transaction{ID: fmt.Sprintf("%s-%d-%v", from, nonce, fee), From: from, To: "0xto", Amount: 1, Fee: fee, Nonce: nonce}
+}
+
+func TestMempoolReplaceByFee(t *testing.T) {
+	pool := NewMempool(DefaultMempoolConfig())
+	original := pendingTx("a", 0, 1)
+	if _, err := pool.Add(original); err != nil {
+		t.Fatal(err)
+	}
+
+	// A lower fee never replaces, and the default bump of 10% makes 1.05
+	// too little while 1.1 is enough
+	for _, fee := range []float64{0.5, 1.05} {
+		if _, err := pool.Add(pendingTx("a", 0, fee)); !errors.Is(err, ErrReplacementUnderpriced) {
+			t.Fatalf("replacement paying %v gave %v", fee, err)
+		}
+	}
+	replacement := pendingTx("a", 0, 1.1)
+	removed, err := pool.Add(replacement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0].ID != original.ID {
+		t.Fatalf("replacement removed %v, want the original", removed)
+	}
+	if pool.Has(original.ID) || !pool.Has(replacement.ID) || pool.Len() != 1 {
+		t.Fatal("pool does not hold just the replacement")
+	}
+	if _, err := pool.Add(replacement); err == nil {
+		t.Fatal("duplicate transaction accepted")
+	}
+}
+
+func TestMempoolEviction(t *testing.T) {
+	config := DefaultMempoolConfig()
+	config.MaxCount = 2
+	pool := NewMempool(config)
+	cheap, middle := pendingTx("a", 0, 1), pendingTx("b", 0, 2)
+	for _, tx := range []Transaction{cheap, middle} {
+		if _, err := pool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A better paying transaction evicts the cheapest one
+	rich := pendingTx("c", 0, 3)
+	removed, err := pool.Add(rich)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0].ID != cheap.ID {
+		t.Fatalf("evicted %v, want the cheapest transaction", removed)
+	}
+
+	// One that pays no more than anything pending is turned away
+	if _, err := pool.Add(pendingTx("d", 0, 2)); !errors.Is(err, ErrMempoolFull) {
+		t.Fatalf("underpaying transaction gave %v", err)
+	}
+	if !pool.Has(middle.ID) || !pool.Has(rich.ID) {
+		t.Fatal("pool lost a transaction it should keep")
+	}
+
+	config = DefaultMempoolConfig()
+	config.MaxPerSender = 2
+	pool = NewMempool(config)
+	for nonce := uint64(0); nonce < 2; nonce++ {
+		if _, err := pool.Add(pendingTx("a", nonce, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := pool.Add(pendingTx("a", 2, 1)); err == nil {
+		t.Fatal("sender exceeded its pending limit")
+	}
+}
+
+func TestMempoolExpiry(t *testing.T) {
+	config := DefaultMempoolConfig()
+	config.Expiry = time.Hour
+	pool := NewMempool(config)
+	start := time.Unix(1700000000, 0)
+	old, fresh := pendingTx("a", 0, 1), pendingTx("b", 0, 1)
+	if _, err := pool.add(old, start); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.add(fresh, start.Add(30*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if expired := pool.Expire(start.Add(time.Hour)); len(expired) != 0 {
+		t.Fatalf("expired %v at exactly the expiry", expired)
+	}
+	expired := pool.Expire(start.Add(time.Hour + time.Second))
+	if len(expired) != 1 || expired[0].ID != old.ID {
+		t.Fatalf("expired %v, want the old transaction", expired)
+	}
+	if pool.Has(old.ID) || !pool.Has(fresh.ID) {
+		t.Fatal("expiry removed the wrong transactions")
+	}
+}
+
+func TestMempoolPriorityOrder(t *testing.T) {
+	pool := NewMempool(DefaultMempoolConfig())
+	// Sender a's second transaction pays the most but must follow its first
+	for _, tx := range []Transaction{
+		pendingTx("a", 1, 5),
+		pendingTx("a", 0, 1),
+		pendingTx("b", 0, 3),
+		pendingTx("c", 0, 2),
+	} {
+		if _, err := pool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"b-0-3", "c-0-2", "a-0-1", "a-1-5"}
+	txs := pool.Transactions()
+	for i, tx := range txs {
+		if tx.ID != want[i] {
+			t.Fatalf("position %d holds %s, want %s", i, tx.ID, want[i])
+		}
+	}
+}
+
+func TestMempoolRejectsInvalidFees(t *testing.T) {
+	pool := NewMempool(DefaultMempoolConfig())
+	if _, err := pool.Add(pendingTx("a", 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	// A NaN replacement would otherwise pass the fee bump check
+	for _, fee := range []float64{math.NaN(), math.Inf(1), -1} {
+		if _, err := pool.Add(pendingTx("a", 0, fee)); err == nil {
+			t.Errorf("replacement with fee %v accepted", fee)
+		}
+		if _, err := pool.Add(pendingTx("b", 0, fee)); err == nil {
+			t.Errorf("fee %v accepted", fee)
+		}
+	}
+	if pool.Len() != 1 || !pool.Has(pendingTx("a", 0, 1).ID) {
+		t.Fatalf("pool holds %v", pool.Transactions())
+	}
+}
+
+func TestMempoolUsesChainClock(t *testing.T) {
+	key := newTestKey(t)
+	clock := &fixedClock{now: time.Unix(1800000000, 0)}
+	config := DefaultConfig()
+	config.GenesisTimestamp = 1700000000
+	config.Alloc = map[string]float64{AddressFromPublicKey(key.PubKey()): 100}
+	config.Clock = clock
+	dir := t.TempDir()
+
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := OpenBlockchain(store, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := signedTransfer(t, key, "0x00000000000000000000000000000000000000aa", 1, 0.1, 0)
+	if err := chain.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if added := chain.mempool.entries[tx.ID].added; !added.Equal(clock.now) {
+		t.Fatalf("transaction stamped %v, want %v", added, clock.now)
+	}
+	chain.Close()
+
+	// Pending transactions reloaded from the store are stamped by the clock too
+	clock.now = clock.now.Add(time.Minute)
+	store, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := OpenBlockchain(store, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloaded.Close()
+	entry, ok := reloaded.mempool.entries[tx.ID]
+	if !ok || !entry.added.Equal(clock.now) {
+		t.Fatalf("reloaded transaction stamped %v, want %v", entry, clock.now)
+	}
+}
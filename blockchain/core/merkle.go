@@ -0,0 +1,170 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Domain prefixes keep leaf and interior hashes from being confused
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// MerkleStep is one sibling hash on the path from a leaf to the root
+type MerkleStep struct {
+	Hash string `json:"hash"`
+	// Left is true when the sibling sits to the left of the running hash
+	Left bool `json:"left"`
+}
+
+// MerkleProof shows that a transaction is included in a block
+type MerkleProof struct {
+	TxID       string       `json:"txId"`
+	TxHash     string       `json:"txHash"`
+	BlockIndex int64        `json:"blockIndex"`
+	BlockHash  string       `json:"blockHash"`
+	MerkleRoot string       `json:"merkleRoot"`
+	Steps      []MerkleStep `json:"steps"`
+}
+
+// Hash returns the hash of the full encoded transaction, including its
+// signature but not the codec version
+func (tx *Transaction) Hash() []byte {
+	hash := sha256.Sum256(encodeTransactionPreimage(tx))
+	return hash[:]
+}
+
+// ComputeMerkleRoot returns the hex Merkle root of the transactions' hashes
+func ComputeMerkleRoot(txs []Transaction) string {
+	if len(txs) == 0 {
+		return hex.EncodeToString(make([]byte, sha256.Size))
+	}
+
+	level := merkleLeaves(txs)
+	for len(level) > 1 {
+		level = merkleParents(level)
+	}
+	return hex.EncodeToString(level[0])
+}
+
+// BuildMerkleProof returns the sibling path for the transaction at index
+func BuildMerkleProof(txs []Transaction, index int) ([]MerkleStep, error) {
+	if index < 0 || index >= len(txs) {
+		return nil, fmt.Errorf("transaction index %d out of range", index)
+	}
+
+	steps := []MerkleStep{}
+	level := merkleLeaves(txs)
+	for len(level) > 1 {
+		sibling := index ^ 1
+		// An unpaired last node is promoted without a sibling
+		if sibling < len(level) {
+			steps = append(steps, MerkleStep{
+				Hash: hex.EncodeToString(level[sibling]),
+				Left: sibling < index,
+			})
+		}
+		level = merkleParents(level)
+		index /= 2
+	}
+
+	return steps, nil
+}
+
+// VerifyMerkleProof folds a transaction hash up the proof path and checks
+// that it arrives at the expected root
+func VerifyMerkleProof(txHash string, steps []MerkleStep, root string) bool {
+	leaf, err := hex.DecodeString(txHash)
+	if err != nil {
+		return false
+	}
+	expected, err := hex.DecodeString(root)
+	if err != nil {
+		return false
+	}
+
+	current := merkleHash(merkleLeafPrefix, leaf)
+	for _, step := range steps {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil {
+			return false
+		}
+		if step.Left {
+			current = merkleHash(merkleNodePrefix, sibling, current)
+		} else {
+			current = merkleHash(merkleNodePrefix, current, sibling)
+		}
+	}
+
+	return bytes.Equal(current, expected)
+}
+
+// Verify checks that the proof belongs to the given transaction and leads to its Merkle root
+func (p *MerkleProof) Verify(tx *Transaction) bool {
+	if tx.ID != p.TxID || hex.EncodeToString(tx.Hash()) != p.TxHash {
+		return false
+	}
+	return VerifyMerkleProof(p.TxHash, p.Steps, p.MerkleRoot)
+}
+
+// GetTransactionProof finds a transaction in the chain and builds its inclusion proof
+func (bc *Blockchain) GetTransactionProof(txID string) (*MerkleProof, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	location, ok := bc.index.txs[txID]
+	if !ok {
+		return nil, fmt.Errorf("transaction %s not found", txID)
+	}
+	block := bc.Chain[location.height]
+	tx := block.Transactions[location.position]
+
+	steps, err := BuildMerkleProof(block.Transactions, location.position)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MerkleProof{
+		TxID:       tx.ID,
+		TxHash:     hex.EncodeToString(tx.Hash()),
+		BlockIndex: block.Index,
+		BlockHash:  block.Hash,
+		MerkleRoot: block.MerkleRoot,
+		Steps:      steps,
+	}, nil
+}
+
+// merkleLeaves hashes every transaction into a leaf node
+func merkleLeaves(txs []Transaction) [][]byte {
+	leaves := make([][]byte, len(txs))
+	for i := range txs {
+		leaves[i] = merkleHash(merkleLeafPrefix, txs[i].Hash())
+	}
+	return leaves
+}
+
+// merkleParents combines a level of the tree into the next one up
+func merkleParents(level [][]byte) [][]byte {
+	parents := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			parents = append(parents, level[i])
+			continue
+		}
+		parents = append(parents, merkleHash(merkleNodePrefix, level[i], level[i+1]))
+	}
+	return parents
+}
+
+// merkleHash hashes a domain prefix followed by the given parts
+func merkleHash(prefix byte, parts ...[]byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{prefix})
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+// merkleTestTransactions returns n distinct transactions
+func merkleTestTransactions(n int) []Transaction {
+	txs := make([]Transaction, n)
+	for i := range txs {
+		txs[i] = Transaction{ID: fmt.Sprint(i), From: "0xfrom", To: "0xto", Amount: float64(i + 1), Nonce: uint64(i)}
+	}
+	return txs
+}
+
+func TestMerkleProofs(t *testing.T) {
+	// Odd sizes exercise the promotion of an unpaired last node
+	for n := 1; n <= 9; n++ {
+		txs := merkleTestTransactions(n)
+		root := ComputeMerkleRoot(txs)
+		for i := range txs {
+			steps, err := BuildMerkleProof(txs, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hash := hex.EncodeToString(txs[i].Hash())
+			if !VerifyMerkleProof(hash, steps, root) {
+				t.Fatalf("proof of transaction %d of %d does not verify", i, n)
+			}
+
+			// The proof must not hold for a neighbour or a flipped path
+			other := hex.EncodeToString(txs[(i+1)%n].Hash())
+			if n > 1 && VerifyMerkleProof(other, steps, root) {
+				t.Fatalf("proof of transaction %d of %d verifies another transaction", i, n)
+			}
+			if len(steps) > 0 {
+				flipped := append([]MerkleStep{}, steps...)
+				flipped[0].Left = !flipped[0].Left
+				if VerifyMerkleProof(hash, flipped, root) {
+					t.Fatalf("proof of transaction %d of %d verifies with a flipped step", i, n)
+				}
+			}
+		}
+	}
+
+	if _, err := BuildMerkleProof(merkleTestTransactions(2), 2); err == nil {
+		t.Fatal("proof built for an index out of range")
+	}
+}
+
+func TestMerkleRootCommitsToTransactions(t *testing.T) {
+	txs := merkleTestTransactions(4)
+	root := ComputeMerkleRoot(txs)
+
+	reordered := []Transaction{txs[1], txs[0], txs[2], txs[3]}
+	if ComputeMerkleRoot(reordered) == root {
+		t.Fatal("reordering transactions keeps the root")
+	}
+	changed := merkleTestTransactions(4)
+	changed[3].Signature = "00"
+	if ComputeMerkleRoot(changed) == root {
+		t.Fatal("changing a signature keeps the root")
+	}
+
+	// A root over two leaves must differ from a single leaf of their parent,
+	// which the domain prefixes guarantee
+	pair := merkleTestTransactions(2)
+	left, right := merkleHash(merkleLeafPrefix, pair[0].Hash()), merkleHash(merkleLeafPrefix, pair[1].Hash())
+	interior := merkleHash(merkleNodePrefix, left, right)
+	if VerifyMerkleProof(hex.EncodeToString(interior), nil, ComputeMerkleRoot(pair)) {
+		t.Fatal("an interior node passes as a leaf")
+	}
+}
+
+func TestTransactionProofFromChain(t *testing.T) {
+	key := newTestKey(t)
+	chain := testChain(t, map[string]float64{AddressFromPublicKey(key.PubKey()): 100})
+	txs := make([]Transaction, 5)
+	for i := range txs {
+		txs[i] = signedTransfer(t, key, "0x00000000000000000000000000000000000000aa", 1, 0.1, uint64(i))
+		if err := chain.AddTransaction(txs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	block := mineBlocks(t, chain, "0x00000000000000000000000000000000000000bb", 1)[0]
+
+	for _, tx := range block.Transactions {
+		proof, err := chain.GetTransactionProof(tx.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proof.BlockHash != block.Hash || proof.MerkleRoot != block.MerkleRoot {
+			t.Fatalf("proof names block %s, want %s", proof.BlockHash, block.Hash)
+		}
+		if !proof.Verify(&tx) {
+			t.Fatalf("proof of %s does not verify", tx.ID)
+		}
+		tampered := tx
+		tampered.Amount++
+		if proof.Verify(&tampered) {
+			t.Fatalf("proof of %s verifies a tampered transaction", tx.ID)
+		}
+	}
+
+	if _, err := chain.GetTransactionProof("missing"); err == nil {
+		t.Fatal("proof built for an unknown transaction")
+	}
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+)
+
+// MinerStatus is a snapshot of what a miner is doing
+type MinerStatus struct {
+	Running     bool   `json:"running"`
+	Address     string `json:"address"`
+	BlocksMined int64  `json:"blocksMined"`
+	LastBlock   string `json:"lastBlock,omitempty"`
+	// HashRate is the proof-of-work hashes per second while running
+	HashRate float64 `json:"hashRate"`
+}
+
+// Miner mines blocks in the background until its context is cancelled.
+// Work on a block is abandoned as soon as a competing block extends the
+// chain, and mining restarts on top of the new tip.
+type Miner struct {
+	chain   *Blockchain
+	address string
+	onBlock func(*Block)
+
+	mu     sync.Mutex
+	status MinerStatus
+	done   chan struct{}
+}
+
+// NewMiner creates a miner that pays rewards to address. The optional
+// onBlock callback is invoked for every block the miner adds to the chain.
+func NewMiner(chain *Blockchain, address string, onBlock func(*Block)) *Miner {
+	return &Miner{
+		chain:   chain,
+		address: address,
+		onBlock: onBlock,
+		status:  MinerStatus{Address: address},
+	}
+}
+
+// Start launches the mining loop in a new goroutine. Cancel ctx to stop it.
+func (m *Miner) Start(ctx context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.status.Running {
+		return
+	}
+	m.status.Running = true
+	m.done = make(chan struct{})
+
+	go m.run(ctx, m.done)
+}
+
+// Wait blocks until the mining loop has exited
+func (m *Miner) Wait() {
+	m.mu.Lock()
+	done := m.done
+	m.mu.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
+
+// Status returns a snapshot of the miner's progress
+func (m *Miner) Status() MinerStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := m.status
+	if pow, ok := m.chain.Engine().(*PoW); ok && status.Running {
+		status.HashRate = pow.HashRate()
+	}
+	return status
+}
+
+// run mines blocks one after another until ctx is cancelled
+func (m *Miner) run(ctx context.Context, done chan struct{}) {
+	defer func() {
+		m.mu.Lock()
+		m.status.Running = false
+		m.mu.Unlock()
+		close(done)
+	}()
+
+	for ctx.Err() == nil {
+		block, err := m.chain.MineBlock(ctx, m.address)
+		switch {
+		case err == nil:
+			m.mu.Lock()
+			m.status.BlocksMined++
+			m.status.LastBlock = block.Hash
+			m.mu.Unlock()
+
+			if m.onBlock != nil {
+				m.onBlock(block)
+			}
+		case err == ErrStaleBlock || ctx.Err() != nil:
+			// Start again on the new tip, or exit the loop
+		case errors.Is(err, ErrRecentlySigned):
+			// Another signer's turn, wait quietly for their block
+			select {
+			case <-ctx.Done():
+			case <-m.chain.TipChanged():
+			}
+		default:
+			log.Printf("miner: %v", err)
+			// Wait for the chain to change before trying again
+			select {
+			case <-ctx.Done():
+			case <-m.chain.TipChanged():
+			}
+		}
+	}
+}
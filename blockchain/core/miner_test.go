@@ -0,0 +1,118 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// signalingPoA reports every seal it starts, so a test knows a miner is
+// waiting on its template
+type signalingPoA struct {
+	*PoA
+	started chan struct{}
+}
+
+func (p *signalingPoA) Seal(ctx context.Context, block *Block, tipChanged <-chan struct{}) error {
+	p.started <- struct{}{}
+	return p.PoA.Seal(ctx, block, tipChanged)
+}
+
+func TestMinerAbandonsStaleWork(t *testing.T) {
+	keys := []*secp256k1.PrivateKey{newTestKey(t), newTestKey(t)}
+	addresses := []string{AddressFromPublicKey(keys[0].PubKey()), AddressFromPublicKey(keys[1].PubKey())}
+	// A clock on a whole second makes the out-of-turn wait exactly outOfTurnDelay
+	clock := &fixedClock{now: time.Unix(1700000100, 0)}
+	chains := make([]*Blockchain, 2)
+	engines := make([]*signalingPoA, 2)
+	for i, key := range keys {
+		engines[i] = &signalingPoA{PoA: NewPoA(addresses, 0), started: make(chan struct{}, 8)}
+		// OpenBlockchain only hands its clock to a bare *PoA
+		engines[i].SetClock(clock)
+		if err := engines[i].Authorize(key); err != nil {
+			t.Fatal(err)
+		}
+		config := DefaultConfig()
+		config.GenesisTimestamp = 1700000000
+		config.Engine = engines[i]
+		config.Clock = clock
+		chain, err := OpenBlockchain(nil, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chains[i] = chain
+	}
+
+	// Block 1 is signer 1's turn, so signer 0 holds back its own
+	competing, err := chains[1].MinePendingTransactions(addresses[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mined := make(chan *Block, 4)
+	miner := NewMiner(chains[0], addresses[0], func(block *Block) { mined <- block })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		miner.Wait()
+	}()
+	miner.Start(ctx)
+
+	<-engines[0].started
+	if err := chains[0].AddBlock(competing); err != nil {
+		t.Fatal(err)
+	}
+
+	// The out-of-turn block is dropped and mining resumes on the new tip
+	select {
+	case block := <-mined:
+		if block.Index != 2 || block.PrevHash != competing.Hash {
+			t.Fatalf("miner sealed block %d on %s, want block 2 on the competing tip", block.Index, block.PrevHash)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("miner did not resume on the new tip")
+	}
+	if status := miner.Status(); status.BlocksMined != 1 {
+		t.Fatalf("miner reports %d blocks, want 1", status.BlocksMined)
+	}
+}
+
+func TestResetPendingKeepsUnincludedTransactions(t *testing.T) {
+	first, second := newTestKey(t), newTestKey(t)
+	config := DefaultConfig()
+	config.GenesisTimestamp = 1700000000
+	config.MaxBlockTransactions = 1
+	config.Alloc = map[string]float64{
+		AddressFromPublicKey(first.PubKey()):  100,
+		AddressFromPublicKey(second.PubKey()): 100,
+	}
+	chain, err := OpenBlockchain(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const to = "0x00000000000000000000000000000000000000aa"
+	included := signedTransfer(t, first, to, 1, 0.3, 0)
+	kept := []Transaction{signedTransfer(t, second, to, 1, 0.2, 0), signedTransfer(t, second, to, 1, 0.1, 1)}
+	for _, tx := range append([]Transaction{included}, kept...) {
+		if err := chain.AddTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only the best paying transaction fits in the block
+	block, err := chain.MinePendingTransactions("0x00000000000000000000000000000000000000bb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(block.Transactions) != 2 || block.Transactions[0].ID != included.ID {
+		t.Fatalf("block holds %d transactions, want the coinbase and the best paying one", len(block.Transactions))
+	}
+
+	pending := chain.Pending()
+	if len(pending) != 2 || pending[0].ID != kept[0].ID || pending[1].ID != kept[1].ID {
+		t.Fatalf("pending %v, want the two transactions left out", pending)
+	}
+}
@@ -0,0 +1,248 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+// MaxMultisigKeys is the most keys a multisig account may have
+const MaxMultisigKeys = 16
+
+// compactSignatureSize is the length of a compact recoverable signature
+const compactSignatureSize = 65
+
+// ErrNotEnoughSignatures is returned when a multisig transaction is signed
+// by fewer keys than its threshold
+var ErrNotEnoughSignatures = errors.New("not enough multisig signatures")
+
+// Multisig is an M-of-N account: any Threshold of its public keys together
+// may spend from it. Its address commits to the threshold and the keys, so
+// a transaction from the account carries them for checking.
+type Multisig struct {
+	Threshold int `json:"threshold"`
+	// PublicKeys are hex-encoded compressed secp256k1 public keys. On chain
+	// they must be lowercase and sorted.
+	PublicKeys []string `json:"publicKeys"`
+}
+
+// NewMultisig creates the account that threshold of keys may spend from
+func NewMultisig(threshold int, keys ...*secp256k1.PublicKey) (*Multisig, error) {
+	encoded := make([]string, len(keys))
+	for i, key := range keys {
+		encoded[i] = hex.EncodeToString(key.SerializeCompressed())
+	}
+	return ParseMultisig(threshold, encoded)
+}
+
+// ParseMultisig creates the account that threshold of the hex-encoded keys
+// may spend from, with the keys in canonical form and order
+func ParseMultisig(threshold int, keys []string) (*Multisig, error) {
+	m := &Multisig{Threshold: threshold, PublicKeys: keys}
+	canonical, err := m.canonicalKeys()
+	if err != nil {
+		return nil, err
+	}
+	return &Multisig{Threshold: threshold, PublicKeys: canonical}, nil
+}
+
+// Address returns the chain address of the account. Key order and case do
+// not matter; the same keys and threshold always give the same address.
+func (m *Multisig) Address() (string, error) {
+	keys, err := m.canonicalKeys()
+	if err != nil {
+		return "", err
+	}
+
+	// The tag keeps multisig addresses apart from single-key ones
+	e := &encoder{}
+	e.string("multisig")
+	e.uvarint(uint64(m.Threshold))
+	e.uvarint(uint64(len(keys)))
+	for _, key := range keys {
+		e.string(key)
+	}
+	hash := sha256.Sum256(e.buf.Bytes())
+	return "0x" + hex.EncodeToString(hash[12:]), nil
+}
+
+// canonicalKeys checks the account and returns its keys as sorted
+// lowercase compressed hex
+func (m *Multisig) canonicalKeys() ([]string, error) {
+	if len(m.PublicKeys) == 0 || len(m.PublicKeys) > MaxMultisigKeys {
+		return nil, fmt.Errorf("multisig account must have 1 to %d keys, has %d", MaxMultisigKeys, len(m.PublicKeys))
+	}
+	if m.Threshold < 1 || m.Threshold > len(m.PublicKeys) {
+		return nil, fmt.Errorf("multisig threshold %d is not between 1 and %d", m.Threshold, len(m.PublicKeys))
+	}
+
+	keys := make([]string, 0, len(m.PublicKeys))
+	seen := make(map[string]bool, len(m.PublicKeys))
+	for _, key := range m.PublicKeys {
+		raw, err := hex.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("multisig key %q is not hex", key)
+		}
+		pub, err := secp256k1.ParsePubKey(raw)
+		if err != nil {
+			return nil, fmt.Errorf("multisig key %q is invalid: %v", key, err)
+		}
+		canonical := hex.EncodeToString(pub.SerializeCompressed())
+		if seen[canonical] {
+			return nil, fmt.Errorf("multisig key %s appears twice", canonical)
+		}
+		seen[canonical] = true
+		keys = append(keys, canonical)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// isCanonical reports whether the account is written exactly as
+// ParseMultisig would write it
+func (m *Multisig) isCanonical() bool {
+	keys, err := m.canonicalKeys()
+	if err != nil {
+		return false
+	}
+	for i, key := range keys {
+		if m.PublicKeys[i] != key {
+			return false
+		}
+	}
+	return true
+}
+
+// CosignTransaction adds the signature of one of a multisig account's keys
+// to a transaction sent from it and sets its ID. The sender is filled in
+// from tx.Multisig if empty and must otherwise match it. The sender and the
+// account are rewritten in canonical form and the signatures kept in key
+// order. Signing again with the same key replaces its earlier signature; a
+// transaction already signed by Threshold other keys cannot take another.
+func CosignTransaction(tx *Transaction, key *secp256k1.PrivateKey) error {
+	if tx.Multisig == nil {
+		return fmt.Errorf("transaction has no multisig account")
+	}
+	multisig, err := ParseMultisig(tx.Multisig.Threshold, tx.Multisig.PublicKeys)
+	if err != nil {
+		return err
+	}
+	address, _ := multisig.Address()
+	if tx.From != "" && !strings.EqualFold(tx.From, address) {
+		return fmt.Errorf("multisig account %s cannot sign for sender %s", address, tx.From)
+	}
+	tx.From = address
+	signer := hex.EncodeToString(key.PubKey().SerializeCompressed())
+	if multisig.keyIndex(signer) < 0 {
+		return fmt.Errorf("key %s is not a signer of %s", signer, address)
+	}
+	tx.Multisig = multisig
+	tx.ID = tx.ComputeID()
+	digest := tx.Digest()
+
+	// Collect the other signers' signatures by key position
+	byKey := map[int]string{}
+	for _, signature := range tx.Signatures {
+		other, err := recoverSigner(signature, digest)
+		if err != nil {
+			return fmt.Errorf("transaction carries an invalid signature: %v", err)
+		}
+		if other != signer {
+			byKey[multisig.keyIndex(other)] = signature
+		}
+	}
+	if len(byKey) >= multisig.Threshold {
+		return fmt.Errorf("transaction already has the %d signatures it needs", multisig.Threshold)
+	}
+	byKey[multisig.keyIndex(signer)] = hex.EncodeToString(ecdsa.SignCompact(key, digest, true))
+
+	tx.Signatures = make([]string, 0, len(byKey))
+	for i := range multisig.PublicKeys {
+		if signature, ok := byKey[i]; ok {
+			tx.Signatures = append(tx.Signatures, signature)
+		}
+	}
+	return nil
+}
+
+// keyIndex returns the position of a canonical key in the account, or -1
+func (m *Multisig) keyIndex(key string) int {
+	for i, candidate := range m.PublicKeys {
+		if candidate == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// verifyMultisig checks that a transaction carries the multisig account
+// behind its From address and exactly its threshold of signatures, from
+// distinct keys of the account in key order. Only one encoding of the
+// witness is valid, so it cannot be altered to change the transaction hash.
+func verifyMultisig(tx *Transaction) error {
+	if tx.Multisig == nil || tx.Signature != "" {
+		return ErrInvalidSignature
+	}
+	address, err := tx.Multisig.Address()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	if address != tx.From || !tx.Multisig.isCanonical() {
+		return ErrInvalidSignature
+	}
+	if len(tx.Signatures) == 0 {
+		return ErrMissingSignature
+	}
+	if len(tx.Signatures) < tx.Multisig.Threshold {
+		return fmt.Errorf("%w: %d of %d", ErrNotEnoughSignatures, len(tx.Signatures), tx.Multisig.Threshold)
+	}
+	if len(tx.Signatures) > tx.Multisig.Threshold {
+		return fmt.Errorf("%w: %d signatures for a threshold of %d", ErrInvalidSignature, len(tx.Signatures), tx.Multisig.Threshold)
+	}
+
+	digest := tx.Digest()
+	last := -1
+	for _, signature := range tx.Signatures {
+		signer, err := recoverSigner(signature, digest)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+		}
+		// Increasing key positions rule out both strangers and repeats
+		position := tx.Multisig.keyIndex(signer)
+		if position <= last {
+			return ErrInvalidSignature
+		}
+		last = position
+	}
+	return nil
+}
+
+// recoverSigner returns the hex compressed public key behind a compact
+// signature of digest. Only the canonical form of a signature is accepted:
+// lowercase hex, a compressed key flag and a low S value.
+func recoverSigner(signature string, digest []byte) (string, error) {
+	raw, err := hex.DecodeString(signature)
+	if err != nil || hex.EncodeToString(raw) != signature || len(raw) != compactSignatureSize {
+		return "", fmt.Errorf("signature is not %d bytes of lowercase hex", compactSignatureSize)
+	}
+	var s secp256k1.ModNScalar
+	s.SetByteSlice(raw[33:])
+	if s.IsOverHalfOrder() {
+		return "", fmt.Errorf("signature has a high S value")
+	}
+
+	pub, compressed, err := ecdsa.RecoverCompact(raw, digest)
+	if err != nil {
+		return "", err
+	}
+	if !compressed {
+		return "", fmt.Errorf("signature is not for a compressed key")
+	}
+	return hex.EncodeToString(pub.SerializeCompressed()), nil
+}
@@ -0,0 +1,257 @@
+package core
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// multisigKeys returns n signing keys and a threshold-of-n account over them
+func multisigKeys(t *testing.T, threshold, n int) ([]*secp256k1.PrivateKey, *Multisig) {
+	t.Helper()
+	keys := make([]*secp256k1.PrivateKey, n)
+	public := make([]*secp256k1.PublicKey, n)
+	for i := range keys {
+		keys[i] = newTestKey(t)
+		public[i] = keys[i].PubKey()
+	}
+	multisig, err := NewMultisig(threshold, public...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys, multisig
+}
+
+func TestMultisigAccount(t *testing.T) {
+	keys, multisig := multisigKeys(t, 2, 3)
+	address, err := multisig.Address()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither key order nor hex case changes the account
+	shuffled := []string{
+		strings.ToUpper(multisig.PublicKeys[2]),
+		multisig.PublicKeys[0],
+		multisig.PublicKeys[1],
+	}
+	parsed, err := ParseMultisig(2, shuffled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other, _ := parsed.Address(); other != address {
+		t.Fatalf("reordered keys give address %s, want %s", other, address)
+	}
+	if other, _ := (&Multisig{Threshold: 3, PublicKeys: multisig.PublicKeys}).Address(); other == address {
+		t.Fatal("threshold does not change the address")
+	}
+
+	for _, bad := range []struct {
+		threshold int
+		keys      []*secp256k1.PublicKey
+	}{
+		{0, []*secp256k1.PublicKey{keys[0].PubKey()}},
+		{4, []*secp256k1.PublicKey{keys[0].PubKey(), keys[1].PubKey(), keys[2].PubKey()}},
+		{2, []*secp256k1.PublicKey{keys[0].PubKey(), keys[0].PubKey()}},
+		{1, nil},
+	} {
+		if _, err := NewMultisig(bad.threshold, bad.keys...); err == nil {
+			t.Fatalf("%d of %d keys accepted", bad.threshold, len(bad.keys))
+		}
+	}
+}
+
+func TestMultisigThreshold(t *testing.T) {
+	keys, multisig := multisigKeys(t, 2, 3)
+	address, _ := multisig.Address()
+	config := DefaultConfig()
+	config.GenesisTimestamp = 1700000000
+	config.Alloc = map[string]float64{address: 100}
+	chain, err := OpenBlockchain(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := Transaction{Multisig: multisig, To: "0x00000000000000000000000000000000000000aa", Amount: 5, Timestamp: 1700000000}
+	if err := SignTransaction(&tx, keys[0]); err == nil {
+		t.Fatal("a single key signed for the multisig account")
+	}
+	if err := CosignTransaction(&tx, keys[2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := CosignTransaction(&tx, keys[2]); err != nil || len(tx.Signatures) != 1 {
+		t.Fatalf("signing twice with one key gave %d signatures: %v", len(tx.Signatures), err)
+	}
+	if err := chain.AddTransaction(tx); !errors.Is(err, ErrNotEnoughSignatures) {
+		t.Fatalf("one of two signatures gave %v", err)
+	}
+	if err := CosignTransaction(&tx, newTestKey(t)); err == nil {
+		t.Fatal("a key outside the account cosigned")
+	}
+
+	repeated := tx
+	repeated.Signatures = []string{tx.Signatures[0], tx.Signatures[0]}
+	if chain.AddTransaction(repeated) == nil {
+		t.Fatal("one signature counted twice")
+	}
+
+	if err := CosignTransaction(&tx, keys[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := CosignTransaction(&tx, keys[1]); err == nil {
+		t.Fatal("a signature beyond the threshold was added")
+	}
+	if err := chain.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.MinePendingTransactions("0x00000000000000000000000000000000000000bb"); err != nil {
+		t.Fatal(err)
+	}
+	if chain.GetBalance(address) != 95 || chain.GetNonce(address) != 1 {
+		t.Fatalf("multisig account has %v at nonce %d", chain.GetBalance(address), chain.GetNonce(address))
+	}
+	if !chain.IsChainValid() {
+		t.Fatal(chain.Validate().Err())
+	}
+}
+
+func TestMultisigWitnessIsCanonical(t *testing.T) {
+	keys, multisig := multisigKeys(t, 2, 3)
+	tx := Transaction{Multisig: multisig, To: "0x00000000000000000000000000000000000000aa", Amount: 5, Timestamp: 1700000000}
+	for _, key := range keys[:2] {
+		if err := CosignTransaction(&tx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := VerifyTransactionSignature(&tx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every variant below keeps the same ID but would change the hash
+	variants := map[string]func(tx *Transaction){
+		"reordered signatures": func(tx *Transaction) {
+			tx.Signatures = []string{tx.Signatures[1], tx.Signatures[0]}
+		},
+		"extra signature": func(tx *Transaction) {
+			extra := *tx
+			extra.Signatures = nil
+			if err := CosignTransaction(&extra, keys[2]); err != nil {
+				t.Fatal(err)
+			}
+			tx.Signatures = append(tx.Signatures, extra.Signatures[0])
+		},
+		"uppercase signature": func(tx *Transaction) {
+			tx.Signatures[0] = strings.ToUpper(tx.Signatures[0])
+		},
+		"uncompressed flag": func(tx *Transaction) {
+			raw, _ := hex.DecodeString(tx.Signatures[0])
+			raw[0] -= 4
+			tx.Signatures[0] = hex.EncodeToString(raw)
+		},
+		"high S": func(tx *Transaction) {
+			raw, _ := hex.DecodeString(tx.Signatures[0])
+			var s secp256k1.ModNScalar
+			s.SetByteSlice(raw[33:])
+			s.Negate()
+			sBytes := s.Bytes()
+			copy(raw[33:], sBytes[:])
+			raw[0] ^= 1
+			tx.Signatures[0] = hex.EncodeToString(raw)
+		},
+		"reordered keys": func(tx *Transaction) {
+			keys := tx.Multisig.PublicKeys
+			tx.Multisig = &Multisig{Threshold: 2, PublicKeys: []string{keys[2], keys[1], keys[0]}}
+		},
+		"uppercase key": func(tx *Transaction) {
+			keys := append([]string{}, tx.Multisig.PublicKeys...)
+			keys[0] = strings.ToUpper(keys[0])
+			tx.Multisig = &Multisig{Threshold: 2, PublicKeys: keys}
+		},
+	}
+	for name, alter := range variants {
+		altered := tx
+		altered.Signatures = append([]string{}, tx.Signatures...)
+		alter(&altered)
+		if err := VerifyTransactionSignature(&altered); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
+
+func TestTimeLocks(t *testing.T) {
+	key := newTestKey(t)
+	address := AddressFromPublicKey(key.PubKey())
+	clock := &fixedClock{now: time.Unix(1700000000, 0)}
+	config := DefaultConfig()
+	config.GenesisTimestamp = 1700000000
+	config.Alloc = map[string]float64{address: 100}
+	config.Clock = clock
+	chain, err := OpenBlockchain(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const to = "0x00000000000000000000000000000000000000aa"
+	const miner = "0x00000000000000000000000000000000000000bb"
+
+	byHeight := Transaction{To: to, Amount: 1, LockHeight: 3, Timestamp: 1700000000}
+	if err := SignTransaction(&byHeight, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.AddTransaction(byHeight); !errors.Is(err, ErrTransactionLocked) {
+		t.Fatalf("transaction locked to block 3 gave %v at block 1", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := chain.MinePendingTransactions(miner); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := chain.AddTransaction(byHeight); err != nil {
+		t.Fatal(err)
+	}
+
+	byTime := Transaction{To: to, Amount: 2, Nonce: 1, LockTime: 1700000100, Timestamp: 1700000000}
+	if err := SignTransaction(&byTime, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.AddTransaction(byTime); !errors.Is(err, ErrTransactionLocked) {
+		t.Fatalf("transaction locked until later gave %v", err)
+	}
+	block, err := chain.MinePendingTransactions(miner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Index != 3 || len(block.Transactions) != 2 {
+		t.Fatalf("block %d has %d transactions, want the coinbase and the unlocked transfer", block.Index, len(block.Transactions))
+	}
+
+	clock.now = time.Unix(1700000100, 0)
+	if err := chain.AddTransaction(byTime); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.MinePendingTransactions(miner); err != nil {
+		t.Fatal(err)
+	}
+	if chain.GetBalance(to) != 3 {
+		t.Fatalf("recipient has %v, want 3", chain.GetBalance(to))
+	}
+
+	// A block that includes a transfer before its lock height is invalid
+	blocks := chain.GetBlocks(0)
+	early := *blocks[3]
+	early.Transactions = append([]Transaction{}, early.Transactions...)
+	early.Transactions[1] = Transaction{To: to, Amount: 1, LockHeight: 10, Timestamp: 1700000000}
+	if err := SignTransaction(&early.Transactions[1], key); err != nil {
+		t.Fatal(err)
+	}
+	report := ValidateBlocks(chain.Config, append(append([]*Block{}, blocks[:3]...), &early))
+	for _, problem := range report.Errors {
+		if problem.Rule == RuleLockTime {
+			return
+		}
+	}
+	t.Fatalf("early transfer not reported: %v", report.Errors)
+}
@@ -0,0 +1,266 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Transaction kinds, stored under the "type" key of Transaction.Data. A
+// transaction without a type is a plain coin transfer.
+const (
+	TxTypeMiningReward = "mining_reward"
+	TxTypeNFTMint      = "nft_mint"
+	TxTypeNFTTransfer  = "nft_transfer"
+	TxTypeNFTList      = "nft_list"
+	TxTypeNFTDelist    = "nft_delist"
+	TxTypeNFTBuy       = "nft_buy"
+)
+
+// Token is the current state of a non-fungible token
+type Token struct {
+	ID      string `json:"id"`
+	Creator string `json:"creator"`
+	Owner   string `json:"owner"`
+	URI     string `json:"uri"`
+	// Listed tokens can be bought for Price and cannot be transferred
+	Listed bool    `json:"listed"`
+	Price  float64 `json:"price,omitempty"`
+}
+
+// Type returns the transaction kind, or "" for a plain transfer
+func (tx *Transaction) Type() string {
+	kind, _ := tx.Data["type"].(string)
+	return kind
+}
+
+// IsNFT reports whether the transaction acts on a token
+func (tx *Transaction) IsNFT() bool {
+	switch tx.Type() {
+	case TxTypeNFTMint, TxTypeNFTTransfer, TxTypeNFTList, TxTypeNFTDelist, TxTypeNFTBuy:
+		return true
+	}
+	return false
+}
+
+// TokenID returns the token a NFT transaction acts on
+func (tx *Transaction) TokenID() string {
+	id, _ := tx.Data["tokenId"].(string)
+	return id
+}
+
+// NewMintTransaction creates an unsigned transaction minting tokenID to owner
+func NewMintTransaction(owner, tokenID, uri string) Transaction {
+	return newNFTTransaction(TxTypeNFTMint, owner, 0, map[string]interface{}{"tokenId": tokenID, "uri": uri})
+}
+
+// NewTransferNFTTransaction creates an unsigned transaction giving tokenID to a new owner
+func NewTransferNFTTransaction(to, tokenID string) Transaction {
+	return newNFTTransaction(TxTypeNFTTransfer, to, 0, map[string]interface{}{"tokenId": tokenID})
+}
+
+// NewListTransaction creates an unsigned transaction offering tokenID for sale
+func NewListTransaction(tokenID string, price float64) Transaction {
+	return newNFTTransaction(TxTypeNFTList, "", 0, map[string]interface{}{"tokenId": tokenID, "price": price})
+}
+
+// NewDelistTransaction creates an unsigned transaction withdrawing tokenID from sale
+func NewDelistTransaction(tokenID string) Transaction {
+	return newNFTTransaction(TxTypeNFTDelist, "", 0, map[string]interface{}{"tokenId": tokenID})
+}
+
+// NewBuyTransaction creates an unsigned transaction paying seller the listed price for tokenID
+func NewBuyTransaction(seller, tokenID string, price float64) Transaction {
+	return newNFTTransaction(TxTypeNFTBuy, seller, price, map[string]interface{}{"tokenId": tokenID})
+}
+
+// newNFTTransaction fills in the fields shared by every token transaction
+func newNFTTransaction(kind, to string, amount float64, data map[string]interface{}) Transaction {
+	data["type"] = kind
+	return Transaction{
+		To:        to,
+		Amount:    amount,
+		Timestamp: time.Now().Unix(),
+		Data:      data,
+	}
+}
+
+// checkNFT validates a token transaction against the state without changing it
+func (s *AccountState) checkNFT(tx *Transaction) error {
+	if tx.From == SystemAddress {
+		return fmt.Errorf("transaction %s: %s cannot act on tokens", tx.ID, SystemAddress)
+	}
+
+	id := tx.TokenID()
+	if id == "" {
+		return fmt.Errorf("transaction %s: missing token ID", tx.ID)
+	}
+	token, exists := s.Tokens[id]
+
+	kind := tx.Type()
+	if kind != TxTypeNFTBuy && tx.Amount != 0 {
+		return fmt.Errorf("transaction %s: %s cannot carry an amount", tx.ID, kind)
+	}
+
+	switch kind {
+	case TxTypeNFTMint:
+		if exists {
+			return fmt.Errorf("transaction %s: token %s already exists", tx.ID, id)
+		}
+		if tx.To == "" {
+			return fmt.Errorf("transaction %s: mint has no recipient", tx.ID)
+		}
+		if uri, _ := tx.Data["uri"].(string); uri == "" {
+			return fmt.Errorf("transaction %s: mint has no token URI", tx.ID)
+		}
+		return nil
+	}
+
+	if !exists {
+		return fmt.Errorf("transaction %s: token %s does not exist", tx.ID, id)
+	}
+
+	switch kind {
+	case TxTypeNFTTransfer:
+		if token.Owner != tx.From {
+			return fmt.Errorf("transaction %s: only the owner of token %s can transfer it", tx.ID, id)
+		}
+		if token.Listed {
+			return fmt.Errorf("transaction %s: token %s is listed for sale", tx.ID, id)
+		}
+		if tx.To == "" {
+			return fmt.Errorf("transaction %s: transfer has no recipient", tx.ID)
+		}
+	case TxTypeNFTList:
+		if token.Owner != tx.From {
+			return fmt.Errorf("transaction %s: only the owner of token %s can list it", tx.ID, id)
+		}
+		if token.Listed {
+			return fmt.Errorf("transaction %s: token %s is already listed", tx.ID, id)
+		}
+		// NaN would pass a plain price <= 0 check
+		if price, ok := dataFloat(tx.Data, "price"); !ok || !validAmount(price) || price == 0 {
+			return fmt.Errorf("transaction %s: listing price must be positive and finite", tx.ID)
+		}
+	case TxTypeNFTDelist:
+		if token.Owner != tx.From {
+			return fmt.Errorf("transaction %s: only the seller of token %s can delist it", tx.ID, id)
+		}
+		if !token.Listed {
+			return fmt.Errorf("transaction %s: token %s is not listed", tx.ID, id)
+		}
+	case TxTypeNFTBuy:
+		if !token.Listed {
+			return fmt.Errorf("transaction %s: token %s is not listed", tx.ID, id)
+		}
+		if tx.From == token.Owner {
+			return fmt.Errorf("transaction %s: seller cannot buy their own token", tx.ID)
+		}
+		if tx.To != token.Owner {
+			return fmt.Errorf("transaction %s: payment for token %s must go to its seller %s", tx.ID, id, token.Owner)
+		}
+		if tx.Amount != token.Price {
+			return fmt.Errorf("transaction %s: token %s costs %v, not %v", tx.ID, id, token.Price, tx.Amount)
+		}
+	}
+
+	return nil
+}
+
+// applyNFT updates token ownership for a transaction that passed checkNFT
+func (s *AccountState) applyNFT(tx *Transaction) {
+	id := tx.TokenID()
+	token := s.Tokens[id]
+
+	switch tx.Type() {
+	case TxTypeNFTMint:
+		uri, _ := tx.Data["uri"].(string)
+		token = Token{ID: id, Creator: tx.From, URI: uri}
+		s.setOwner(&token, tx.To)
+	case TxTypeNFTTransfer:
+		token.Listed, token.Price = false, 0
+		s.setOwner(&token, tx.To)
+	case TxTypeNFTBuy:
+		// The payment goes to the seller and the token to the buyer
+		token.Listed, token.Price = false, 0
+		s.setOwner(&token, tx.From)
+	case TxTypeNFTList:
+		token.Listed = true
+		token.Price, _ = dataFloat(tx.Data, "price")
+	case TxTypeNFTDelist:
+		token.Listed, token.Price = false, 0
+	}
+
+	s.Tokens[id] = token
+}
+
+// setOwner moves a token between owners in the ownership index
+func (s *AccountState) setOwner(token *Token, owner string) {
+	if owned := s.OwnedTokens[token.Owner]; owned != nil {
+		delete(owned, token.ID)
+		if len(owned) == 0 {
+			delete(s.OwnedTokens, token.Owner)
+		}
+	}
+
+	token.Owner = owner
+	if s.OwnedTokens[owner] == nil {
+		s.OwnedTokens[owner] = map[string]bool{}
+	}
+	s.OwnedTokens[owner][token.ID] = true
+}
+
+// GetToken returns the current state of a token
+func (bc *Blockchain) GetToken(id string) (Token, bool) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	token, ok := bc.state.Tokens[id]
+	return token, ok
+}
+
+// TokensOf returns every token owned by an address, ordered by ID
+func (bc *Blockchain) TokensOf(owner string) []Token {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	tokens := []Token{}
+	for id := range bc.state.OwnedTokens[owner] {
+		tokens = append(tokens, bc.state.Tokens[id])
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+	return tokens
+}
+
+// Listings returns every token currently for sale, ordered by ID
+func (bc *Blockchain) Listings() []Token {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	tokens := []Token{}
+	for _, token := range bc.state.Tokens {
+		if token.Listed {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+	return tokens
+}
+
+// dataFloat reads a number from transaction data, which holds float64 values
+// when built in memory and json.Number values once decoded
+func dataFloat(data map[string]interface{}, key string) (float64, bool) {
+	switch v := data[key].(type) {
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
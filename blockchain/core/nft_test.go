@@ -0,0 +1,99 @@
+package core
+
+import (
+	"math"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// signedNFT signs a token transaction from key with the given nonce
+func signedNFT(t *testing.T, key *secp256k1.PrivateKey, tx Transaction, nonce uint64) *Transaction {
+	t.Helper()
+	tx.Nonce = nonce
+	tx.Timestamp = 1700000000
+	if err := SignTransaction(&tx, key); err != nil {
+		t.Fatal(err)
+	}
+	return &tx
+}
+
+func TestNFTOwnerRules(t *testing.T) {
+	owner, other := newTestKey(t), newTestKey(t)
+	ownerAddress, otherAddress := AddressFromPublicKey(owner.PubKey()), AddressFromPublicKey(other.PubKey())
+	chain := testChain(t, map[string]float64{ownerAddress: 10, otherAddress: 10})
+	state := chain.state.Copy()
+	state.advance(1, 1700000000)
+
+	if err := state.ApplyTransaction(signedNFT(t, owner, NewMintTransaction(ownerAddress, "token-1", "ipfs://token-1"), 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the owner may list or transfer the token
+	if err := state.ApplyTransaction(signedNFT(t, other, NewListTransaction("token-1", 2), 0)); err == nil {
+		t.Fatal("non-owner listed the token")
+	}
+	if err := state.ApplyTransaction(signedNFT(t, other, NewTransferNFTTransaction(otherAddress, "token-1"), 0)); err == nil {
+		t.Fatal("non-owner transferred the token")
+	}
+	if err := state.ApplyTransaction(signedNFT(t, owner, NewListTransaction("token-1", 2), 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A listed token can only be bought at its price, and not transferred
+	if err := state.ApplyTransaction(signedNFT(t, owner, NewTransferNFTTransaction(otherAddress, "token-1"), 2)); err == nil {
+		t.Fatal("listed token transferred")
+	}
+	if err := state.ApplyTransaction(signedNFT(t, other, NewDelistTransaction("token-1"), 0)); err == nil {
+		t.Fatal("non-owner delisted the token")
+	}
+	if err := state.ApplyTransaction(signedNFT(t, other, NewBuyTransaction(ownerAddress, "token-1", 1), 0)); err == nil {
+		t.Fatal("token bought below its price")
+	}
+	if err := state.ApplyTransaction(signedNFT(t, other, NewBuyTransaction(ownerAddress, "token-1", 2), 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	token := state.Tokens["token-1"]
+	if token.Owner != otherAddress || token.Listed || !state.OwnedTokens[otherAddress]["token-1"] || state.OwnedTokens[ownerAddress] != nil {
+		t.Fatalf("bought token is %+v", token)
+	}
+	if state.Balances[ownerAddress] != 12 || state.Balances[otherAddress] != 8 {
+		t.Fatalf("balances %v after the sale", state.Balances)
+	}
+
+	// The previous owner has lost every right over it
+	if err := state.ApplyTransaction(signedNFT(t, owner, NewTransferNFTTransaction(ownerAddress, "token-1"), 2)); err == nil {
+		t.Fatal("previous owner transferred the token")
+	}
+}
+
+func TestNFTListingPrice(t *testing.T) {
+	owner := newTestKey(t)
+	address := AddressFromPublicKey(owner.PubKey())
+	chain := testChain(t, map[string]float64{address: 10})
+	state := chain.state.Copy()
+	state.advance(1, 1700000000)
+	if err := state.ApplyTransaction(signedNFT(t, owner, NewMintTransaction(address, "token-1", "ipfs://token-1"), 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, price := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		list := signedNFT(t, owner, NewListTransaction("token-1", price), 1)
+		if err := state.ApplyTransaction(list); err == nil {
+			t.Errorf("listing at %v accepted", price)
+		}
+	}
+	unpriced := NewListTransaction("token-1", 1)
+	delete(unpriced.Data, "price")
+	if err := state.ApplyTransaction(signedNFT(t, owner, unpriced, 1)); err == nil {
+		t.Error("listing without a price accepted")
+	}
+
+	if err := state.ApplyTransaction(signedNFT(t, owner, NewListTransaction("token-1", 0.5), 1)); err != nil {
+		t.Fatal(err)
+	}
+	if token := state.Tokens["token-1"]; !token.Listed || token.Price != 0.5 {
+		t.Fatalf("listed token is %+v", token)
+	}
+}
@@ -0,0 +1,264 @@
+package core
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+// Difficulties of PoA blocks. Sealing in turn weighs more, so that when
+// signers race the chain built by in-turn signers wins fork choice.
+const (
+	DifficultyInTurn    = 2
+	DifficultyOutOfTurn = 1
+
+	// outOfTurnDelay staggers out-of-turn signers so the in-turn one goes first
+	outOfTurnDelay = 500 * time.Millisecond
+)
+
+var (
+	// ErrUnauthorizedSigner is returned when a block is sealed by a key outside the signer set
+	ErrUnauthorizedSigner = errors.New("signer is not authorized")
+	// ErrRecentlySigned is returned when a signer tries to seal again before its turn comes round
+	ErrRecentlySigned = errors.New("signer has signed too recently")
+	// ErrNoSignerKey is returned when a PoA node is asked to seal without a key
+	ErrNoSignerKey = errors.New("no signer key configured")
+)
+
+// PoA is the proof-of-authority engine. A fixed set of signers take turns
+// in round-robin order, sealing blocks by signing their hash. Any signer
+// may step in when the in-turn one is absent, but at a lower difficulty,
+// and no signer may seal more than one of any len(signers)/2+1 consecutive
+// blocks.
+type PoA struct {
+	signers []string
+	// period is the minimum number of seconds between blocks
+	period int64
+
+	mu     sync.RWMutex
+	key    *secp256k1.PrivateKey
+	signer string
+	// clock decides when a block may be sealed; nil is the system clock
+	clock Clock
+}
+
+// NewPoA creates a proof-of-authority engine for the given signer addresses
+func NewPoA(signers []string, period int64) *PoA {
+	normalized := make([]string, len(signers))
+	for i, signer := range signers {
+		normalized[i] = strings.ToLower(signer)
+	}
+	return &PoA{signers: normalized, period: period}
+}
+
+// Authorize sets the key this node seals blocks with
+func (p *PoA) Authorize(key *secp256k1.PrivateKey) error {
+	signer := AddressFromPublicKey(key.PubKey())
+	if p.signerIndex(signer) < 0 {
+		return fmt.Errorf("%w: %s", ErrUnauthorizedSigner, signer)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.key = key
+	p.signer = signer
+	return nil
+}
+
+// SetClock makes the engine time its seals by clock instead of the system
+// clock. OpenBlockchain sets it to the chain's Config.Clock.
+func (p *PoA) SetClock(clock Clock) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clock = clock
+}
+
+// Signers returns the authorized signer addresses in turn order
+func (p *PoA) Signers() []string {
+	return append([]string{}, p.signers...)
+}
+
+// Period returns the minimum number of seconds between blocks
+func (p *PoA) Period() int64 {
+	return p.period
+}
+
+// InTurnSigner returns the signer whose turn it is to seal the block at index
+func (p *PoA) InTurnSigner(index int64) string {
+	if len(p.signers) == 0 {
+		return ""
+	}
+	return p.signers[index%int64(len(p.signers))]
+}
+
+// Prepare sets the difficulty for the local signer's turn and holds the
+// timestamp back until the period since the parent has passed
+func (p *PoA) Prepare(chain []*Block, block *Block) error {
+	p.mu.RLock()
+	signer := p.signer
+	p.mu.RUnlock()
+
+	if signer == "" {
+		return ErrNoSignerKey
+	}
+	if err := p.checkRecent(chain, signer); err != nil {
+		return err
+	}
+
+	block.Difficulty = p.difficulty(block.Index, signer)
+
+	parent := chain[len(chain)-1]
+	if earliest := parent.Timestamp + p.period; block.Timestamp < earliest {
+		block.Timestamp = earliest
+	}
+	block.Nonce = 0
+	return nil
+}
+
+// Seal waits until the block's timestamp, plus a delay when sealing out of
+// turn, and then signs the block hash. The wait is measured on the engine's
+// clock, so a virtual clock that has already passed that time seals at once.
+func (p *PoA) Seal(ctx context.Context, block *Block, tipChanged <-chan struct{}) error {
+	p.mu.RLock()
+	key, signer, clock := p.key, p.signer, p.clock
+	p.mu.RUnlock()
+
+	if key == nil {
+		return ErrNoSignerKey
+	}
+
+	now := time.Now()
+	if clock != nil {
+		now = clock.Now()
+	}
+	delay := time.Unix(block.Timestamp, 0).Sub(now)
+	if block.Difficulty != DifficultyInTurn {
+		// Signers further from the in-turn position wait longer
+		n := int64(len(p.signers))
+		distance := (int64(p.signerIndex(signer)) - block.Index%n + n) % n
+		delay += time.Duration(distance) * outOfTurnDelay
+	}
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tipChanged:
+			return ErrStaleBlock
+		case <-timer.C:
+		}
+	}
+
+	block.Hash = calculateHash(block)
+	hash, err := hex.DecodeString(block.Hash)
+	if err != nil {
+		return err
+	}
+	block.Signature = hex.EncodeToString(ecdsa.SignCompact(key, hash, true))
+	return nil
+}
+
+// VerifyHeader checks the block was signed by an authorized signer whose
+// turn and timing allow it
+func (p *PoA) VerifyHeader(chain []*Block, block *Block) error {
+	if err := p.VerifySeal(block); err != nil {
+		return err
+	}
+	signer, _ := BlockSigner(block)
+
+	parent := chain[len(chain)-1]
+	if block.Timestamp < parent.Timestamp+p.period {
+		return fmt.Errorf("sealed less than %d seconds after its parent", p.period)
+	}
+	if err := p.checkRecent(chain, signer); err != nil {
+		return err
+	}
+	return nil
+}
+
+// VerifySeal checks the block was signed by an authorized signer with the
+// difficulty of its turn
+func (p *PoA) VerifySeal(block *Block) error {
+	signer, err := BlockSigner(block)
+	if err != nil {
+		return err
+	}
+	if p.signerIndex(signer) < 0 {
+		return fmt.Errorf("%w: %s", ErrUnauthorizedSigner, signer)
+	}
+	if expected := p.difficulty(block.Index, signer); block.Difficulty != expected {
+		return fmt.Errorf("difficulty %d does not match expected %d for signer %s",
+			block.Difficulty, expected, signer)
+	}
+	return nil
+}
+
+// Work is the block difficulty, which favours chains sealed in turn
+func (p *PoA) Work(block *Block) *big.Int {
+	return new(big.Int).SetUint64(block.Difficulty)
+}
+
+// BlockSigner recovers the address that signed a block's hash
+func BlockSigner(block *Block) (string, error) {
+	if block.Signature == "" {
+		return "", fmt.Errorf("block is not signed")
+	}
+	signature, err := hex.DecodeString(block.Signature)
+	if err != nil {
+		return "", fmt.Errorf("invalid block signature")
+	}
+	hash, err := hex.DecodeString(block.Hash)
+	if err != nil {
+		return "", fmt.Errorf("invalid block hash")
+	}
+
+	pub, _, err := ecdsa.RecoverCompact(signature, hash)
+	if err != nil {
+		return "", fmt.Errorf("invalid block signature")
+	}
+	return AddressFromPublicKey(pub), nil
+}
+
+// difficulty returns the difficulty signer must use for the block at index
+func (p *PoA) difficulty(index int64, signer string) uint64 {
+	if p.InTurnSigner(index) == signer {
+		return DifficultyInTurn
+	}
+	return DifficultyOutOfTurn
+}
+
+// checkRecent rejects a signer that sealed one of the last len(signers)/2
+// blocks of chain
+func (p *PoA) checkRecent(chain []*Block, signer string) error {
+	limit := len(p.signers) / 2
+	for i := len(chain) - 1; i > 0 && i >= len(chain)-limit; i-- {
+		recent, err := BlockSigner(chain[i])
+		if err != nil {
+			continue
+		}
+		if recent == signer {
+			return ErrRecentlySigned
+		}
+	}
+	return nil
+}
+
+// signerIndex returns the position of an address in the signer set, or -1
+func (p *PoA) signerIndex(address string) int {
+	address = strings.ToLower(address)
+	for i, signer := range p.signers {
+		if signer == address {
+			return i
+		}
+	}
+	return -1
+}
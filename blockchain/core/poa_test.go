@@ -0,0 +1,136 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// fixedClock is a virtual clock that only moves when a test sets it
+type fixedClock struct {
+	now time.Time
+}
+
+func (c *fixedClock) Now() time.Time {
+	return c.now
+}
+
+// poaNetwork creates signer keys and opens one chain per key, sealing with
+// that key, plus an observer chain without a key at index len(keys)
+func poaNetwork(t *testing.T, signers int, period int64, clock Clock) ([]string, []*Blockchain) {
+	t.Helper()
+	keys := make([]*secp256k1.PrivateKey, signers)
+	addresses := make([]string, signers)
+	for i := range keys {
+		keys[i] = newTestKey(t)
+		addresses[i] = AddressFromPublicKey(keys[i].PubKey())
+	}
+
+	chains := make([]*Blockchain, signers+1)
+	for i := range chains {
+		config := DefaultConfig()
+		config.GenesisTimestamp = 1700000000
+		config.Clock = clock
+		engine := NewPoA(addresses, period)
+		if i < signers {
+			if err := engine.Authorize(keys[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		config.Engine = engine
+
+		chain, err := OpenBlockchain(nil, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chains[i] = chain
+	}
+	return addresses, chains
+}
+
+func TestPoATurnOrder(t *testing.T) {
+	addresses, chains := poaNetwork(t, 3, 0, nil)
+	observer := chains[3]
+
+	// Block 1 belongs to signer 1
+	block, err := chains[1].MinePendingTransactions(addresses[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Difficulty != DifficultyInTurn {
+		t.Fatalf("in-turn block has difficulty %d", block.Difficulty)
+	}
+	if signer, err := BlockSigner(block); err != nil || signer != addresses[1] {
+		t.Fatalf("block signed by %s (%v), want %s", signer, err, addresses[1])
+	}
+	for _, chain := range []*Blockchain{chains[2], observer} {
+		if err := chain.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// With three signers, none may seal two blocks in a row
+	if _, err := chains[1].MinePendingTransactions(addresses[1]); !errors.Is(err, ErrRecentlySigned) {
+		t.Fatalf("signing twice in a row gave %v", err)
+	}
+
+	block, err = chains[2].MinePendingTransactions(addresses[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Difficulty != DifficultyInTurn {
+		t.Fatalf("in-turn block has difficulty %d", block.Difficulty)
+	}
+	for _, chain := range []*Blockchain{chains[1], observer} {
+		if err := chain.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Signer 0 is absent at block 3, so signer 1 steps in out of turn
+	block, err = chains[1].MinePendingTransactions(addresses[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Difficulty != DifficultyOutOfTurn {
+		t.Fatalf("out-of-turn block has difficulty %d", block.Difficulty)
+	}
+	if err := observer.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+
+	unsigned := *block
+	unsigned.Signature = ""
+	if err := chains[2].AddBlock(&unsigned); err == nil {
+		t.Fatal("unsigned block was accepted")
+	}
+	if NewPoA(addresses, 0).Authorize(newTestKey(t)) == nil {
+		t.Fatal("key outside the signer set was authorized")
+	}
+	if !observer.IsChainValid() {
+		t.Fatal(observer.Validate().Err())
+	}
+	if observer.NextDifficulty() != 0 {
+		t.Fatal("observer without a key reports a difficulty")
+	}
+}
+
+func TestPoASealUsesConfiguredClock(t *testing.T) {
+	// The virtual clock runs a day ahead of the wall clock, so a seal timed
+	// by the wall clock would wait for a day
+	clock := &fixedClock{now: time.Now().Add(24 * time.Hour)}
+	addresses, chains := poaNetwork(t, 1, 5, clock)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	block, err := chains[0].MineBlock(ctx, addresses[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Timestamp != clock.now.Unix() {
+		t.Fatalf("block timestamp %d, want the virtual time %d", block.Timestamp, clock.now.Unix())
+	}
+}
@@ -0,0 +1,247 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// maxRetargetFactor bounds how far a single retarget can move the difficulty
+const maxRetargetFactor = 4
+
+// sealCheckInterval is how many hashes are tried between cancellation checks
+const sealCheckInterval = 4096
+
+// maxHash is the largest possible 256-bit block hash
+var maxHash = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+// PoW is the proof-of-work engine. A block's hash must not exceed the
+// target for its difficulty, and the difficulty is retargeted periodically
+// to keep blocks arriving at the target rate.
+type PoW struct {
+	// RetargetInterval is the number of blocks between difficulty adjustments
+	RetargetInterval int64
+	// TargetBlockTime is the desired number of seconds between blocks
+	TargetBlockTime int64
+
+	mu sync.Mutex
+	// threads is how many goroutines search for a nonce, zero for one per CPU
+	threads int
+	// hashes and sealStart measure the seal in progress, if any
+	hashes    uint64
+	sealStart time.Time
+	// hashRate is the rate of the last finished seal, in hashes per second
+	hashRate float64
+}
+
+// NewPoW creates a proof-of-work engine with the given retargeting schedule
+func NewPoW(retargetInterval, targetBlockTime int64) *PoW {
+	return &PoW{RetargetInterval: retargetInterval, TargetBlockTime: targetBlockTime}
+}
+
+// DifficultyToTarget returns the numeric target a block hash must not exceed
+func DifficultyToTarget(difficulty uint64) *big.Int {
+	if difficulty == 0 {
+		difficulty = 1
+	}
+	return new(big.Int).Div(maxHash, new(big.Int).SetUint64(difficulty))
+}
+
+// hashMeetsDifficulty checks a hex block hash against the target for a difficulty
+func hashMeetsDifficulty(hash string, difficulty uint64) bool {
+	value, ok := new(big.Int).SetString(hash, 16)
+	if !ok {
+		return false
+	}
+	return value.Cmp(DifficultyToTarget(difficulty)) <= 0
+}
+
+// Prepare sets the difficulty the retargeting schedule requires
+func (p *PoW) Prepare(chain []*Block, block *Block) error {
+	block.Difficulty = p.CalcDifficulty(chain)
+	return nil
+}
+
+// SetThreads sets how many goroutines Seal splits the nonce search across.
+// Zero or less uses one per CPU.
+func (p *PoW) SetThreads(threads int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.threads = threads
+}
+
+// Threads returns how many goroutines Seal searches with
+func (p *PoW) Threads() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.threads <= 0 {
+		return runtime.NumCPU()
+	}
+	return p.threads
+}
+
+// HashRate returns the hashes per second of the seal in progress, or of
+// the last one if none is running
+func (p *PoW) HashRate() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sealStart.IsZero() {
+		return p.hashRate
+	}
+	if elapsed := time.Since(p.sealStart).Seconds(); elapsed > 0 {
+		return float64(p.hashes) / elapsed
+	}
+	return 0
+}
+
+// Seal searches for a nonce that satisfies the block's difficulty, with
+// the nonce space interleaved across Threads goroutines. All of them stop
+// as soon as one finds a solution, ctx is cancelled or the tip changes.
+func (p *PoW) Seal(ctx context.Context, block *Block, tipChanged <-chan struct{}) error {
+	threads := p.Threads()
+	p.beginSeal()
+	defer p.endSeal()
+
+	// Buffered so that workers finding solutions together never block
+	found := make(chan Block, threads)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	// Workers copy the header before block is written with the solution
+	header := *block
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func(start int64) {
+			defer wg.Done()
+			p.search(header, start, int64(threads), found, stop)
+		}(header.Nonce + int64(i))
+	}
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	select {
+	case solved := <-found:
+		block.Nonce = solved.Nonce
+		block.Hash = solved.Hash
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-tipChanged:
+		return ErrStaleBlock
+	}
+}
+
+// search tries every step-th nonce from start on its own copy of the
+// header, checking for stop every few thousand hashes
+func (p *PoW) search(header Block, start, step int64, found chan<- Block, stop <-chan struct{}) {
+	header.Nonce = start
+	for {
+		for i := 0; i < sealCheckInterval; i++ {
+			header.Hash = calculateHash(&header)
+			if hashMeetsDifficulty(header.Hash, header.Difficulty) {
+				p.countHashes(uint64(i + 1))
+				found <- header
+				return
+			}
+			header.Nonce += step
+		}
+		p.countHashes(sealCheckInterval)
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
+
+// beginSeal starts measuring the hash rate of a seal
+func (p *PoW) beginSeal() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hashes = 0
+	p.sealStart = time.Now()
+}
+
+// countHashes adds to the hashes tried by the seal in progress
+func (p *PoW) countHashes(n uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hashes += n
+}
+
+// endSeal records the hash rate of the seal that just finished
+func (p *PoW) endSeal() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if elapsed := time.Since(p.sealStart).Seconds(); elapsed > 0 {
+		p.hashRate = float64(p.hashes) / elapsed
+	}
+	p.sealStart = time.Time{}
+}
+
+// VerifyHeader checks the difficulty follows the retargeting schedule and
+// that the hash meets it
+func (p *PoW) VerifyHeader(chain []*Block, block *Block) error {
+	if expected := p.CalcDifficulty(chain); block.Difficulty != expected {
+		return fmt.Errorf("difficulty %d does not match expected %d", block.Difficulty, expected)
+	}
+	return p.VerifySeal(block)
+}
+
+// VerifySeal checks the hash meets the difficulty the block claims
+func (p *PoW) VerifySeal(block *Block) error {
+	if !hashMeetsDifficulty(block.Hash, block.Difficulty) {
+		return fmt.Errorf("hash does not meet the difficulty target")
+	}
+	return nil
+}
+
+// Work is the expected number of hashes needed to mine the block
+func (p *PoW) Work(block *Block) *big.Int {
+	return new(big.Int).SetUint64(block.Difficulty)
+}
+
+// CalcDifficulty computes the difficulty required of the block that
+// extends chain. Every RetargetInterval blocks the difficulty is scaled by
+// how far the actual time over the last interval missed the target time.
+func (p *PoW) CalcDifficulty(chain []*Block) uint64 {
+	parent := chain[len(chain)-1]
+	height := parent.Index + 1
+	interval := p.RetargetInterval
+
+	if interval <= 0 || height%interval != 0 || height < interval {
+		return parent.Difficulty
+	}
+
+	first := chain[height-interval]
+	actual := parent.Timestamp - first.Timestamp
+	expected := interval * p.TargetBlockTime
+
+	// Never let a single adjustment overshoot
+	if actual < expected/maxRetargetFactor {
+		actual = expected / maxRetargetFactor
+	}
+	if actual > expected*maxRetargetFactor {
+		actual = expected * maxRetargetFactor
+	}
+	if actual < 1 {
+		actual = 1
+	}
+
+	next := new(big.Int).SetUint64(parent.Difficulty)
+	next.Mul(next, big.NewInt(expected))
+	next.Div(next, big.NewInt(actual))
+
+	if next.Sign() <= 0 {
+		return 1
+	}
+	if !next.IsUint64() {
+		return ^uint64(0)
+	}
+	return next.Uint64()
+}
@@ -0,0 +1,180 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// headerChain returns count headers of the given difficulty, spaced by
+// spacing seconds
+func headerChain(count int, difficulty uint64, spacing int64) []*Block {
+	chain := make([]*Block, count)
+	for i := range chain {
+		chain[i] = &Block{Index: int64(i), Timestamp: 1700000000 + int64(i)*spacing, Difficulty: difficulty}
+	}
+	return chain
+}
+
+// sealed returns a block extending chain with the given difficulty and a
+// hash that meets it
+func sealed(t *testing.T, pow *PoW, chain []*Block, difficulty uint64) *Block {
+	t.Helper()
+	parent := chain[len(chain)-1]
+	block := &Block{Index: parent.Index + 1, Timestamp: parent.Timestamp + 1, PrevHash: parent.Hash, Difficulty: difficulty}
+	if err := pow.Seal(context.Background(), block, nil); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestRetargetClampsAdjustment(t *testing.T) {
+	pow := NewPoW(10, 15)
+	for _, test := range []struct {
+		name    string
+		spacing int64
+		want    uint64
+	}{
+		// 150 seconds were expected for the interval
+		{"on target", 15, 8 * 150 / 135},
+		{"slightly fast", 12, 8 * 150 / 108},
+		{"far too fast", 1, 8 * 4},
+		{"far too slow", 1000, 8 / 4},
+	} {
+		if got := pow.CalcDifficulty(headerChain(10, 8, test.spacing)); got != test.want {
+			t.Errorf("%s: difficulty %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNonBoundaryBlocksInheritDifficulty(t *testing.T) {
+	pow := NewPoW(10, 15)
+	for _, count := range []int{1, 9, 11, 15} {
+		chain := headerChain(count, 8, 1)
+		chain[count-1].Difficulty = 5
+		if got := pow.CalcDifficulty(chain); got != 5 {
+			t.Errorf("block %d has difficulty %d, want its parent's 5", count, got)
+		}
+	}
+
+	// Without a retarget interval the difficulty never moves
+	if got := NewPoW(0, 15).CalcDifficulty(headerChain(10, 8, 1)); got != 8 {
+		t.Fatalf("difficulty %d without retargeting, want 8", got)
+	}
+}
+
+func TestVerifyHeaderChecksRetarget(t *testing.T) {
+	pow := NewPoW(10, 15)
+	pow.SetThreads(1)
+	chain := headerChain(10, 8, 1)
+
+	// The parent's difficulty is wrong at a boundary even with a valid seal
+	stale := sealed(t, pow, chain, 8)
+	if err := pow.VerifyHeader(chain, stale); err == nil || !strings.Contains(err.Error(), "difficulty") {
+		t.Fatalf("block keeping its parent's difficulty at a boundary gave %v", err)
+	}
+	retargeted := sealed(t, pow, chain, 32)
+	if err := pow.VerifyHeader(chain, retargeted); err != nil {
+		t.Fatal(err)
+	}
+
+	// Past the boundary the retargeted difficulty carries on
+	chain = append(chain, retargeted)
+	if err := pow.VerifyHeader(chain, sealed(t, pow, chain, 8)); err == nil {
+		t.Fatal("block after a retarget reverted the difficulty")
+	}
+	if err := pow.VerifyHeader(chain, sealed(t, pow, chain, 32)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewBlockchainDifficulty(t *testing.T) {
+	// The difficulty is the expected number of hashes, not leading zeros
+	if got := NewBlockchain(4, 10).GetBlock(0).Difficulty; got != 4 {
+		t.Fatalf("genesis difficulty %d, want 4", got)
+	}
+	if got := NewBlockchain(0, 10).GetBlock(0).Difficulty; got != DefaultConfig().Difficulty {
+		t.Fatalf("genesis difficulty %d, want the default", got)
+	}
+}
+
+// hashesTried returns the hashes counted for the current or last seal
+func hashesTried(pow *PoW) uint64 {
+	pow.mu.Lock()
+	defer pow.mu.Unlock()
+	return pow.hashes
+}
+
+// assertStopped checks that no worker of a finished seal is still hashing
+func assertStopped(t *testing.T, pow *PoW) {
+	t.Helper()
+	before := hashesTried(pow)
+	time.Sleep(50 * time.Millisecond)
+	if after := hashesTried(pow); after != before {
+		t.Fatalf("workers tried %d more hashes after the seal returned", after-before)
+	}
+}
+
+func TestSealWithThreadsStopsOnSolution(t *testing.T) {
+	pow := NewPoW(0, 10)
+	pow.SetThreads(4)
+	block := sealed(t, pow, headerChain(1, 1, 10), 1<<12)
+	if err := pow.VerifySeal(block); err != nil {
+		t.Fatal(err)
+	}
+	if hashesTried(pow) == 0 || pow.HashRate() <= 0 {
+		t.Fatalf("seal counted %d hashes at %v per second", hashesTried(pow), pow.HashRate())
+	}
+	assertStopped(t, pow)
+}
+
+func TestSealWithThreadsStops(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		stop func(cancel context.CancelFunc, tipChanged chan struct{})
+		want error
+	}{
+		{"cancelled", func(cancel context.CancelFunc, _ chan struct{}) { cancel() }, context.Canceled},
+		{"tip changed", func(_ context.CancelFunc, tipChanged chan struct{}) { close(tipChanged) }, ErrStaleBlock},
+	} {
+		pow := NewPoW(0, 10)
+		pow.SetThreads(4)
+		// No nonce is expected to meet this difficulty
+		block := &Block{Index: 1, Timestamp: 1700000001, Difficulty: 1 << 62}
+		ctx, cancel := context.WithCancel(context.Background())
+		tipChanged := make(chan struct{})
+		done := make(chan error, 1)
+		go func() { done <- pow.Seal(ctx, block, tipChanged) }()
+
+		// The counter advances while the seal runs
+		deadline := time.Now().Add(5 * time.Second)
+		first := hashesTried(pow)
+		for hashesTried(pow) <= first || pow.HashRate() <= 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: hash counter stuck at %d", test.name, first)
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		test.stop(cancel, tipChanged)
+		var err error
+		select {
+		case err = <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: seal did not return", test.name)
+		}
+		cancel()
+		if !errors.Is(err, test.want) {
+			t.Fatalf("%s: seal returned %v, want %v", test.name, err, test.want)
+		}
+		if block.Hash != "" {
+			t.Fatalf("%s: unsolved block was given hash %s", test.name, block.Hash)
+		}
+		assertStopped(t, pow)
+		if pow.HashRate() <= 0 {
+			t.Fatalf("%s: no hash rate recorded for the stopped seal", test.name)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package core
+
+// GetBlockByHash returns the block with the given hash, or nil if there is none
+func (bc *Blockchain) GetBlockByHash(hash string) *Block {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if height, ok := bc.index.heights[hash]; ok {
+		return bc.Chain[height]
+	}
+	return nil
+}
+
+// GetTransaction finds a transaction by ID in the chain or the mempool. It
+// returns the block that includes it, which is nil while the transaction is
+// still pending, and a nil transaction if it is unknown.
+func (bc *Blockchain) GetTransaction(id string) (*Transaction, *Block) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if location, ok := bc.index.txs[id]; ok {
+		block := bc.Chain[location.height]
+		tx := block.Transactions[location.position]
+		return &tx, block
+	}
+
+	if tx, ok := bc.mempool.Get(id); ok {
+		return &tx, nil
+	}
+	return nil, nil
+}
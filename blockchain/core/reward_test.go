@@ -0,0 +1,125 @@
+package core
+
+import "testing"
+
+func TestRewardHalving(t *testing.T) {
+	config := DefaultConfig()
+	config.GenesisTimestamp = 1700000000
+	config.MiningReward = 8
+	config.HalvingInterval = 2
+	chain, err := OpenBlockchain(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, block := range mineBlocks(t, chain, "0x00000000000000000000000000000000000000bb", 6) {
+		want := []float64{8, 4, 4, 2, 2, 1}[i]
+		if reward := block.Transactions[len(block.Transactions)-1].Amount; reward != want {
+			t.Errorf("block %d pays %v, want %v", block.Index, reward, want)
+		}
+	}
+
+	// The reward shrinks to whatever is left under the maximum supply
+	config.HalvingInterval = 0
+	config.MiningReward = 10
+	config.MaxSupply = 15
+	capped, err := OpenBlockchain(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, block := range mineBlocks(t, capped, "0x00000000000000000000000000000000000000bb", 3) {
+		want := []float64{10, 5, 0}[i]
+		if reward := block.Transactions[len(block.Transactions)-1].Amount; reward != want {
+			t.Errorf("capped block %d pays %v, want %v", block.Index, reward, want)
+		}
+	}
+	if supply := capped.state.Supply; supply != 15 {
+		t.Fatalf("supply %v, want the maximum of 15", supply)
+	}
+}
+
+func TestCoinbaseMaturity(t *testing.T) {
+	miner := newTestKey(t)
+	address := AddressFromPublicKey(miner.PubKey())
+	config := DefaultConfig()
+	config.GenesisTimestamp = 1700000000
+	config.CoinbaseMaturity = 3
+	chain, err := OpenBlockchain(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mineBlocks(t, chain, address, 1)
+	if immature := chain.GetImmatureBalance(address); immature != 10 {
+		t.Fatalf("immature balance %v, want the whole reward", immature)
+	}
+
+	// The reward of block 1 unlocks at block 4
+	spend := signedTransfer(t, miner, "0x00000000000000000000000000000000000000aa", 5, 0.1, 0)
+	for i := 0; i < 2; i++ {
+		if err := chain.AddTransaction(spend); err == nil {
+			t.Fatalf("immature reward spent after block %d", chain.Height())
+		}
+		mineBlocks(t, chain, "0x00000000000000000000000000000000000000bb", 1)
+	}
+	if immature := chain.GetImmatureBalance(address); immature != 0 {
+		t.Fatalf("immature balance %v at block %d, want none", immature, chain.Height())
+	}
+	if err := chain.AddTransaction(spend); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRewardRules(t *testing.T) {
+	chain := testChain(t, nil)
+	mineBlocks(t, chain, "0x00000000000000000000000000000000000000bb", 1)
+	encoded := EncodeBlocks(chain.GetBlocks(0))
+
+	// withReward changes a copy of block 1's reward and keeps its ID canonical
+	withReward := func(change func(reward *Transaction)) func(block *Block) {
+		return func(block *Block) {
+			reward := &block.Transactions[len(block.Transactions)-1]
+			change(reward)
+			reward.ID = reward.ComputeID()
+		}
+	}
+	for name, alter := range map[string]func(block *Block){
+		"no reward": func(block *Block) {
+			block.Transactions = []Transaction{}
+		},
+		"extra reward": func(block *Block) {
+			extra := block.Transactions[0]
+			extra.Nonce++
+			extra.ID = extra.ComputeID()
+			block.Transactions = append(block.Transactions, extra)
+		},
+		"reward not last": func(block *Block) {
+			other := block.Transactions[0]
+			other.Data = map[string]interface{}{"type": TxTypeMiningReward, "memo": "x"}
+			other.ID = other.ComputeID()
+			block.Transactions = append(block.Transactions, other)
+			block.Transactions[0], block.Transactions[1] = block.Transactions[1], block.Transactions[0]
+		},
+		"wrong amount": withReward(func(reward *Transaction) { reward.Amount++ }),
+		"wrong type":   withReward(func(reward *Transaction) { reward.Data = map[string]interface{}{"type": TxTypeAlloc} }),
+		"no recipient": withReward(func(reward *Transaction) { reward.To = "" }),
+		"fee":          withReward(func(reward *Transaction) { reward.Fee = 1 }),
+		"wrong nonce":  withReward(func(reward *Transaction) { reward.Nonce = 7 }),
+	} {
+		blocks, err := DecodeBlocks(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		alter(blocks[1])
+		reseal(blocks[1])
+		if report := ValidateBlocks(chain.Config, blocks); !hasRule(report, RuleReward) {
+			t.Errorf("block with %s reported as %v", name, report.Errors)
+		}
+
+		// A fresh node refuses the block outright
+		fresh := testChain(t, nil)
+		if err := fresh.AddBlock(blocks[1]); err == nil {
+			t.Errorf("block with %s added", name)
+		}
+	}
+}
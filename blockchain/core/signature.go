@@ -0,0 +1,97 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+// SystemAddress is the sender of protocol-generated transactions such as mining rewards
+const SystemAddress = "SYSTEM"
+
+var (
+	// ErrMissingSignature is returned when a user transaction carries no signature
+	ErrMissingSignature = errors.New("missing transaction signature")
+	// ErrInvalidSignature is returned when a signature does not match the sender
+	ErrInvalidSignature = errors.New("invalid transaction signature")
+	// ErrInvalidTransactionID is returned when a transaction ID is not its canonical hash
+	ErrInvalidTransactionID = errors.New("transaction ID does not match its contents")
+)
+
+// AddressFromPublicKey derives the chain address of a secp256k1 public key
+func AddressFromPublicKey(pub *secp256k1.PublicKey) string {
+	hash := sha256.Sum256(pub.SerializeCompressed())
+	return "0x" + hex.EncodeToString(hash[12:])
+}
+
+// Digest returns the canonical hash of the transaction that gets signed.
+// Every field except the ID and signature is committed, with Data keys
+// sorted, so the chain ID and nonce are covered.
+func (tx *Transaction) Digest() []byte {
+	digest := sha256.Sum256(encodeSigningPayload(tx))
+	return digest[:]
+}
+
+// ComputeID returns the ID a transaction must carry: its hex digest
+func (tx *Transaction) ComputeID() string {
+	return hex.EncodeToString(tx.Digest())
+}
+
+// VerifyTransactionID checks that a transaction's ID is its canonical hash
+func VerifyTransactionID(tx *Transaction) error {
+	if tx.ID != tx.ComputeID() {
+		return ErrInvalidTransactionID
+	}
+	return nil
+}
+
+// SignTransaction signs the transaction with the given private key and
+// sets its ID. The sender is filled in from the key if empty and must
+// otherwise match it; it is written in its canonical lowercase form.
+func SignTransaction(tx *Transaction, key *secp256k1.PrivateKey) error {
+	if tx.Multisig != nil {
+		return fmt.Errorf("transactions from a multisig account are signed with CosignTransaction")
+	}
+	address := AddressFromPublicKey(key.PubKey())
+	if tx.From != "" && !strings.EqualFold(tx.From, address) {
+		return fmt.Errorf("key for %s cannot sign for sender %s", address, tx.From)
+	}
+	tx.From = address
+
+	tx.ID = tx.ComputeID()
+	signature := ecdsa.SignCompact(key, tx.Digest(), true)
+	tx.Signature = hex.EncodeToString(signature)
+	return nil
+}
+
+// VerifyTransactionSignature checks that the transaction was signed by the
+// key behind its From address, or by enough keys of its multisig account
+func VerifyTransactionSignature(tx *Transaction) error {
+	if tx.Multisig != nil || len(tx.Signatures) > 0 {
+		return verifyMultisig(tx)
+	}
+	if tx.Signature == "" {
+		return ErrMissingSignature
+	}
+
+	signature, err := hex.DecodeString(tx.Signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	pub, _, err := ecdsa.RecoverCompact(signature, tx.Digest())
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	if AddressFromPublicKey(pub) != tx.From {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
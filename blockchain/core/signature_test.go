@@ -0,0 +1,138 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestNonceGapIsRejected(t *testing.T) {
+	key := newTestKey(t)
+	chain := testChain(t, map[string]float64{AddressFromPublicKey(key.PubKey()): 100})
+	const to = "0x00000000000000000000000000000000000000aa"
+
+	if err := chain.AddTransaction(signedTransfer(t, key, to, 1, 0, 1)); !errors.Is(err, ErrNonceGap) {
+		t.Fatalf("nonce 1 before 0 gave %v, want %v", err, ErrNonceGap)
+	}
+	state := chain.state.Copy()
+	state.advance(1, 1700000000)
+	skipped := signedTransfer(t, key, to, 1, 0, 1)
+	if err := state.ApplyTransaction(&skipped); !errors.Is(err, ErrNonceGap) {
+		t.Fatalf("nonce 1 applied before 0 gave %v, want %v", err, ErrNonceGap)
+	}
+
+	// Pending transactions fill the gap, but only up to the next nonce
+	for nonce := uint64(0); nonce < 2; nonce++ {
+		if err := chain.AddTransaction(signedTransfer(t, key, to, 1, 0, nonce)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := chain.AddTransaction(signedTransfer(t, key, to, 1, 0, 3)); !errors.Is(err, ErrNonceGap) {
+		t.Fatalf("nonce 3 after 1 gave %v, want %v", err, ErrNonceGap)
+	}
+}
+
+func TestMinedTransactionCannotReplay(t *testing.T) {
+	key := newTestKey(t)
+	chain := testChain(t, map[string]float64{AddressFromPublicKey(key.PubKey()): 100})
+	tx := signedTransfer(t, key, "0x00000000000000000000000000000000000000aa", 5, 0.1, 0)
+	if err := chain.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, chain, "0x00000000000000000000000000000000000000bb", 1)
+
+	if err := chain.AddTransaction(tx); err == nil {
+		t.Fatal("mined transaction entered the pending pool again")
+	}
+	state := chain.state.Copy()
+	state.advance(2, 1700000000)
+	if err := state.ApplyTransaction(&tx); !errors.Is(err, ErrNonceTooLow) {
+		t.Fatalf("replay applied to the state gave %v, want %v", err, ErrNonceTooLow)
+	}
+
+	// A block carrying the transaction again is refused too
+	mineBlocks(t, chain, "0x00000000000000000000000000000000000000bb", 1)
+	blocks, err := DecodeBlocks(EncodeBlocks(chain.GetBlocks(0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks[2].Transactions = append([]Transaction{tx}, blocks[2].Transactions...)
+	reseal(blocks[2])
+	if report := ValidateBlocks(chain.Config, blocks); !hasRule(report, RuleNonce) {
+		t.Errorf("replay in a block reported as %v", report.Errors)
+	}
+	fresh := testChain(t, map[string]float64{AddressFromPublicKey(key.PubKey()): 100})
+	if err := fresh.AddBlock(blocks[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := fresh.AddBlock(blocks[2]); err == nil {
+		t.Fatal("block replaying a mined transaction added")
+	}
+	if got := fresh.GetBalance("0x00000000000000000000000000000000000000aa"); got != 5 {
+		t.Fatalf("recipient balance %v, want 5", got)
+	}
+}
+
+func TestTransactionForAnotherChain(t *testing.T) {
+	key := newTestKey(t)
+	config := DefaultConfig()
+	config.GenesisTimestamp = 1700000000
+	config.ChainID = 7
+	config.Alloc = map[string]float64{AddressFromPublicKey(key.PubKey()): 100}
+	chain, err := OpenBlockchain(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := Transaction{To: "0x00000000000000000000000000000000000000aa", Amount: 5, Fee: 0.1, ChainID: 8, Timestamp: 1700000000}
+	if err := SignTransaction(&tx, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.AddTransaction(tx); err == nil {
+		t.Fatal("transaction for chain 8 accepted on chain 7")
+	}
+
+	// The chain ID is signed, so it cannot be rewritten to fit
+	tx.ChainID = 7
+	tx.ID = tx.ComputeID()
+	if err := chain.AddTransaction(tx); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("transaction moved to chain 7 gave %v, want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestTransactionIDIsSignedHash(t *testing.T) {
+	key := newTestKey(t)
+	tx := signedTransfer(t, key, "0x00000000000000000000000000000000000000aa", 5, 0.1, 3)
+	digest := sha256.Sum256(encodeSigningPayload(&tx))
+	if tx.ID != hex.EncodeToString(digest[:]) {
+		t.Fatalf("ID %s is not the hash of the signed fields", tx.ID)
+	}
+
+	// Every signed field moves the ID
+	for name, alter := range map[string]func(tx *Transaction){
+		"from":        func(tx *Transaction) { tx.From = "0x00000000000000000000000000000000000000cc" },
+		"to":          func(tx *Transaction) { tx.To = "0x00000000000000000000000000000000000000cc" },
+		"amount":      func(tx *Transaction) { tx.Amount++ },
+		"fee":         func(tx *Transaction) { tx.Fee++ },
+		"nonce":       func(tx *Transaction) { tx.Nonce++ },
+		"chain ID":    func(tx *Transaction) { tx.ChainID++ },
+		"timestamp":   func(tx *Transaction) { tx.Timestamp++ },
+		"lock height": func(tx *Transaction) { tx.LockHeight++ },
+		"lock time":   func(tx *Transaction) { tx.LockTime++ },
+		"data":        func(tx *Transaction) { tx.Data = map[string]interface{}{"memo": "x"} },
+	} {
+		altered := tx
+		alter(&altered)
+		if err := VerifyTransactionID(&altered); !errors.Is(err, ErrInvalidTransactionID) {
+			t.Errorf("changing the %s gave %v, want %v", name, err, ErrInvalidTransactionID)
+		}
+	}
+
+	// The signature is derived from the ID, not part of it
+	resigned := tx
+	resigned.Signature = ""
+	if resigned.ComputeID() != tx.ID {
+		t.Fatal("ID depends on the signature")
+	}
+}
@@ -0,0 +1,277 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+var (
+	// ErrNonceTooLow is returned when a sender's nonce was already used
+	ErrNonceTooLow = errors.New("nonce too low")
+	// ErrNonceGap is returned when a nonce skips past the sender's next one
+	ErrNonceGap = errors.New("nonce gap")
+	// ErrTransactionLocked is returned when a transaction's lock height or time has not been reached
+	ErrTransactionLocked = errors.New("transaction is time-locked")
+)
+
+// AccountState holds the balances, nonces and tokens derived from the chain
+type AccountState struct {
+	Balances map[string]float64
+	Nonces   map[string]uint64
+	Tokens   map[string]Token
+	// OwnedTokens indexes token IDs by owner
+	OwnedTokens map[string]map[string]bool
+	// Supply is the total number of coins in existence
+	Supply float64
+	// CoinbaseMaturity is how many blocks a mining reward stays unspendable
+	CoinbaseMaturity int64
+
+	// height and time are the index and timestamp of the block being applied
+	height int64
+	time   int64
+	// maturing lists locked mining rewards in the order they unlock
+	maturing []coinbaseLock
+}
+
+// coinbaseLock is a mining reward that cannot be spent before a height
+type coinbaseLock struct {
+	address string
+	amount  float64
+	height  int64
+}
+
+// NewAccountState creates an empty account state
+func NewAccountState() *AccountState {
+	return &AccountState{
+		Balances:    map[string]float64{},
+		Nonces:      map[string]uint64{},
+		Tokens:      map[string]Token{},
+		OwnedTokens: map[string]map[string]bool{},
+	}
+}
+
+// newState creates an empty account state following the chain's rules
+func (bc *Blockchain) newState() *AccountState {
+	state := NewAccountState()
+	state.CoinbaseMaturity = bc.Config.CoinbaseMaturity
+	return state
+}
+
+// Copy returns a deep copy of the state
+func (s *AccountState) Copy() *AccountState {
+	cp := NewAccountState()
+	for address, balance := range s.Balances {
+		cp.Balances[address] = balance
+	}
+	for address, nonce := range s.Nonces {
+		cp.Nonces[address] = nonce
+	}
+	for id, token := range s.Tokens {
+		cp.Tokens[id] = token
+	}
+	for owner, owned := range s.OwnedTokens {
+		cp.OwnedTokens[owner] = make(map[string]bool, len(owned))
+		for id := range owned {
+			cp.OwnedTokens[owner][id] = true
+		}
+	}
+	cp.Supply = s.Supply
+	cp.CoinbaseMaturity = s.CoinbaseMaturity
+	cp.height = s.height
+	cp.time = s.time
+	cp.maturing = append([]coinbaseLock{}, s.maturing...)
+	return cp
+}
+
+// advance moves the state to the block at height with the given
+// timestamp, unlocking the mining rewards that have matured by then
+func (s *AccountState) advance(height, timestamp int64) {
+	s.height = height
+	s.time = timestamp
+	for len(s.maturing) > 0 && s.maturing[0].height <= height {
+		s.maturing = s.maturing[1:]
+	}
+}
+
+// immatureBalance returns how much of an address's balance is mining
+// rewards that are still locked at height
+func (s *AccountState) immatureBalance(address string, height int64) float64 {
+	var locked float64
+	for _, lock := range s.maturing {
+		if lock.address == address && lock.height > height {
+			locked += lock.amount
+		}
+	}
+	return locked
+}
+
+// ApplyTransaction moves the transaction amount from sender to recipient.
+// SYSTEM transactions create new coins; every other sender must be able to
+// cover the amount from its balance. Token transactions must also pass the
+// NFT rules, and update ownership when they do. Time-locked transactions
+// only apply once the block reaches their lock height and time.
+func (s *AccountState) ApplyTransaction(tx *Transaction) error {
+	// NaN fails every comparison, so it must be ruled out before balances are checked
+	if !validAmount(tx.Amount) {
+		return fmt.Errorf("transaction %s has invalid amount %v", tx.ID, tx.Amount)
+	}
+	if !validAmount(tx.Fee) {
+		return fmt.Errorf("transaction %s has invalid fee %v", tx.ID, tx.Fee)
+	}
+	if err := checkAddresses(tx); err != nil {
+		return err
+	}
+	if err := checkLock(tx, s.height, s.time); err != nil {
+		return err
+	}
+	isNFT := tx.IsNFT()
+	if isNFT {
+		if err := s.checkNFT(tx); err != nil {
+			return err
+		}
+	}
+
+	if tx.From != SystemAddress {
+		// Each sender's transactions apply strictly in nonce order, once each
+		if err := checkNonce(tx, s.Nonces[tx.From]); err != nil {
+			return err
+		}
+
+		// The fee leaves the sender here and reaches the miner through the reward
+		cost := tx.Amount + tx.Fee
+		spendable := s.Balances[tx.From] - s.immatureBalance(tx.From, s.height)
+		if spendable < cost {
+			return fmt.Errorf("transaction %s spends %v but %s can only spend %v",
+				tx.ID, cost, tx.From, spendable)
+		}
+		s.Balances[tx.From] -= cost
+		s.Nonces[tx.From]++
+		s.Supply -= tx.Fee
+	} else {
+		s.Supply += tx.Amount
+		if tx.Type() == TxTypeMiningReward && s.CoinbaseMaturity > 0 && tx.Amount > 0 {
+			s.maturing = append(s.maturing, coinbaseLock{
+				address: tx.To,
+				amount:  tx.Amount,
+				height:  s.height + s.CoinbaseMaturity,
+			})
+		}
+	}
+
+	if tx.Amount > 0 {
+		s.Balances[tx.To] += tx.Amount
+	}
+	if isNFT {
+		s.applyNFT(tx)
+	}
+	return nil
+}
+
+// validAmount reports whether v is a finite, non-negative amount of coins
+func validAmount(v float64) bool {
+	return v >= 0 && !math.IsInf(v, 0)
+}
+
+// checkNonce checks a transaction's nonce against the next one its sender may use
+func checkNonce(tx *Transaction, next uint64) error {
+	if tx.Nonce < next {
+		return fmt.Errorf("transaction %s: %w: %s is at nonce %d, got %d", tx.ID, ErrNonceTooLow, tx.From, next, tx.Nonce)
+	}
+	if tx.Nonce > next {
+		return fmt.Errorf("transaction %s: %w: %s is at nonce %d, got %d", tx.ID, ErrNonceGap, tx.From, next, tx.Nonce)
+	}
+	return nil
+}
+
+// checkAddresses checks that a transaction names its accounts in their
+// canonical lowercase form, since balances and nonces are keyed by it, and
+// that a transfer has a recipient
+func checkAddresses(tx *Transaction) error {
+	if tx.From != SystemAddress && tx.From != strings.ToLower(tx.From) {
+		return fmt.Errorf("transaction %s: sender %s is not in lowercase", tx.ID, tx.From)
+	}
+	if tx.To != SystemAddress && tx.To != strings.ToLower(tx.To) {
+		return fmt.Errorf("transaction %s: recipient %s is not in lowercase", tx.ID, tx.To)
+	}
+	if tx.From != SystemAddress && !tx.IsNFT() && tx.To == "" {
+		return fmt.Errorf("transaction %s: transfer has no recipient", tx.ID)
+	}
+	return nil
+}
+
+// checkLock checks a transaction's locks against the index and timestamp
+// of the block that would include it
+func checkLock(tx *Transaction, height, timestamp int64) error {
+	if tx.LockHeight > height {
+		return fmt.Errorf("transaction %s: %w until block %d, at %d", tx.ID, ErrTransactionLocked, tx.LockHeight, height)
+	}
+	if tx.LockTime > timestamp {
+		return fmt.Errorf("transaction %s: %w until time %d, at %d", tx.ID, ErrTransactionLocked, tx.LockTime, timestamp)
+	}
+	return nil
+}
+
+// ApplyBlock applies every transaction of a block in order
+func (s *AccountState) ApplyBlock(block *Block) error {
+	s.advance(block.Index, block.Timestamp)
+	for i := range block.Transactions {
+		if err := s.ApplyTransaction(&block.Transactions[i]); err != nil {
+			return fmt.Errorf("block %d: %v", block.Index, err)
+		}
+	}
+	return nil
+}
+
+// GetBalance returns the confirmed balance of an address
+func (bc *Blockchain) GetBalance(address string) float64 {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.state.Balances[address]
+}
+
+// GetNonce returns the number of confirmed transactions sent by an address
+func (bc *Blockchain) GetNonce(address string) uint64 {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.state.Nonces[address]
+}
+
+// GetImmatureBalance returns the part of an address's balance that is
+// mining rewards too recent to spend in the next block
+func (bc *Blockchain) GetImmatureBalance(address string) float64 {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.state.immatureBalance(address, bc.Chain[len(bc.Chain)-1].Index+1)
+}
+
+// Balances returns a copy of every confirmed balance, by address
+func (bc *Blockchain) Balances() map[string]float64 {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	balances := make(map[string]float64, len(bc.state.Balances))
+	for address, balance := range bc.state.Balances {
+		balances[address] = balance
+	}
+	return balances
+}
+
+// Supply returns the total number of coins in existence
+func (bc *Blockchain) Supply() float64 {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.state.Supply
+}
+
+// spendableBalance returns the balance the next block could spend minus
+// what the address is already spending in pending transactions
+func (bc *Blockchain) spendableBalance(address string) float64 {
+	next := bc.Chain[len(bc.Chain)-1].Index + 1
+	balance := bc.state.Balances[address] - bc.state.immatureBalance(address, next)
+	for _, tx := range bc.mempool.SenderTransactions(address) {
+		balance -= tx.Amount + tx.Fee
+	}
+	return balance
+}
@@ -0,0 +1,101 @@
+package core
+
+import (
+	"encoding/hex"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+func TestTransactionAddressesAreCanonical(t *testing.T) {
+	key := newTestKey(t)
+	sender := AddressFromPublicKey(key.PubKey())
+	config := DefaultConfig()
+	config.GenesisTimestamp = 1700000000
+	config.Alloc = map[string]float64{sender: 100}
+	chain, err := OpenBlockchain(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const to = "0x00000000000000000000000000000000000000aa"
+
+	// signedAs signs a transfer for the sender's key under any spelling of
+	// its address, as a hand-rolled client might
+	signedAs := func(from, to string) Transaction {
+		tx := Transaction{From: from, To: to, Amount: 1, Timestamp: 1700000000}
+		tx.ID = tx.ComputeID()
+		tx.Signature = hex.EncodeToString(ecdsa.SignCompact(key, tx.Digest(), true))
+		return tx
+	}
+
+	for _, c := range []struct {
+		tx   Transaction
+		want string
+	}{
+		{signedAs("0x"+strings.ToUpper(sender[2:]), to), "sender"},
+		{signedAs(sender, strings.ToUpper(to)), "recipient"},
+		{signedAs(sender, ""), "no recipient"},
+	} {
+		if err := chain.AddTransaction(c.tx); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("transaction from %q to %q gave %v", c.tx.From, c.tx.To, err)
+		}
+		state := chain.state.Copy()
+		state.advance(1, 1700000000)
+		if err := state.ApplyTransaction(&c.tx); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("transaction from %q to %q applied with %v", c.tx.From, c.tx.To, err)
+		}
+	}
+
+	// SignTransaction writes the sender in its canonical form
+	tx := Transaction{From: strings.ToUpper(sender), To: to, Amount: 1, Timestamp: 1700000000}
+	if err := SignTransaction(&tx, key); err != nil {
+		t.Fatal(err)
+	}
+	if tx.From != sender {
+		t.Fatalf("signed sender is %s, want %s", tx.From, sender)
+	}
+	if err := chain.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransactionsCannotOverspend(t *testing.T) {
+	rich, poor := newTestKey(t), newTestKey(t)
+	chain := testChain(t, map[string]float64{AddressFromPublicKey(rich.PubKey()): 100})
+	const to = "0x00000000000000000000000000000000000000aa"
+
+	for name, tx := range map[string]Transaction{
+		"overspend":          signedTransfer(t, poor, to, 1, 0, 0),
+		"overspend by fee":   signedTransfer(t, rich, to, 100, 0.1, 0),
+		"NaN amount":         signedTransfer(t, poor, to, math.NaN(), 0, 0),
+		"infinite amount":    signedTransfer(t, rich, to, math.Inf(1), 0, 0),
+		"negative amount":    signedTransfer(t, rich, to, -1, 0, 0),
+		"NaN amount, funded": signedTransfer(t, rich, to, math.NaN(), 0, 0),
+		"NaN fee":            signedTransfer(t, rich, to, 1, math.NaN(), 0),
+		"infinite fee":       signedTransfer(t, rich, to, 1, math.Inf(1), 0),
+		"negative fee":       signedTransfer(t, rich, to, 1, -0.1, 0),
+	} {
+		if err := chain.AddTransaction(tx); err == nil {
+			t.Errorf("%s entered the pending pool", name)
+		}
+
+		// Blocks from peers skip the pool, so the state must refuse it too
+		state := chain.state.Copy()
+		state.advance(1, 1700000000)
+		if err := state.ApplyTransaction(&tx); err == nil {
+			t.Errorf("%s applied to the state", name)
+		}
+	}
+
+	if _, err := chain.MinePendingTransactions("0x00000000000000000000000000000000000000bb"); err != nil {
+		t.Fatal(err)
+	}
+	if got := chain.GetBalance(to); got != 0 {
+		t.Fatalf("recipient balance %v, want 0", got)
+	}
+	if got := chain.GetBalance(AddressFromPublicKey(poor.PubKey())); got != 0 {
+		t.Fatalf("unfunded sender balance %v, want 0", got)
+	}
+}
@@ -0,0 +1,318 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	blocksFileName  = "blocks.dat"
+	pendingFileName = "pending.dat"
+	lockFileName    = "LOCK"
+
+	// Every record is prefixed with its payload length, a CRC32 checksum of
+	// that length and a CRC32 checksum of the payload
+	recordHeaderSize = 12
+)
+
+var (
+	// ErrReadOnlyStore is returned when writing to a store opened read-only
+	ErrReadOnlyStore = errors.New("store is read-only")
+	// ErrStoreLocked is returned when another process has the data directory open
+	ErrStoreLocked = errors.New("data directory is in use by another process")
+
+	// errTornRecord marks a record cut short by an interrupted append
+	errTornRecord = errors.New("torn record")
+	// errCorruptRecord marks a record damaged after it was written
+	errCorruptRecord = errors.New("corrupt record")
+)
+
+// Store persists blocks and pending transactions for a blockchain
+type Store interface {
+	// LoadBlocks returns every stored block in the order it was appended
+	LoadBlocks() ([]*Block, error)
+	// AppendBlock durably appends a block to the store
+	AppendBlock(block *Block) error
+	// TruncateBlocks removes every stored block from the given height on,
+	// so a reorg can append the blocks of its new branch
+	TruncateBlocks(height int64) error
+	// LoadPending returns the last saved set of pending transactions
+	LoadPending() ([]Transaction, error)
+	// SavePending replaces the saved set of pending transactions
+	SavePending(txs []Transaction) error
+	// Close releases any resources held by the store
+	Close() error
+}
+
+// FileStore is an append-only file store rooted at a data directory.
+// Blocks are appended to blocks.dat as checksummed codec records, and the
+// pending transactions are rewritten atomically to pending.dat.
+type FileStore struct {
+	dir    string
+	blocks *os.File
+	// offsets holds where the record of each block starts in the block log
+	offsets []int64
+	// lock holds an exclusive lock on the data directory for as long as a
+	// writable store is open
+	lock *os.File
+	// readOnly stores never write, so they are safe to open next to a
+	// running node. Their block log is nil when the directory has none.
+	readOnly bool
+}
+
+// NewFileStore opens (or creates) a file store in the given directory
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	lock, err := os.OpenFile(filepath.Join(dir, lockFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %v", err)
+	}
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	blocks, err := os.OpenFile(filepath.Join(dir, blocksFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("failed to open block log: %v", err)
+	}
+
+	return &FileStore{dir: dir, blocks: blocks, lock: lock}, nil
+}
+
+// NewReadOnlyFileStore opens the file store in the given directory for
+// reading only. It takes no lock and creates nothing, and a torn trailing
+// record is skipped rather than truncated, as a running node may still be
+// writing it.
+func NewReadOnlyFileStore(dir string) (*FileStore, error) {
+	blocks, err := os.Open(filepath.Join(dir, blocksFileName))
+	if os.IsNotExist(err) {
+		return &FileStore{dir: dir, readOnly: true}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open block log: %v", err)
+	}
+
+	return &FileStore{dir: dir, blocks: blocks, readOnly: true}, nil
+}
+
+// LoadBlocks reads every block from the block log. A trailing record that
+// was only partially written (for example after a crash) is truncated away,
+// or just skipped by a read-only store. Damage anywhere else is an error,
+// so no intact block is ever thrown away.
+func (s *FileStore) LoadBlocks() ([]*Block, error) {
+	if s.blocks == nil {
+		return []*Block{}, nil
+	}
+	if _, err := s.blocks.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek block log: %v", err)
+	}
+	data, err := io.ReadAll(s.blocks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read block log: %v", err)
+	}
+
+	blocks := []*Block{}
+	s.offsets = []int64{}
+	offset := 0
+	for offset < len(data) {
+		payload, size, err := readRecord(data[offset:])
+		if errors.Is(err, errCorruptRecord) {
+			return nil, fmt.Errorf("corrupt block record at offset %d", offset)
+		}
+		if err != nil {
+			if !s.readOnly {
+				if err := s.truncate(int64(offset)); err != nil {
+					return nil, err
+				}
+			}
+			break
+		}
+
+		block, err := DecodeBlock(payload)
+		if err != nil {
+			return nil, fmt.Errorf("corrupt block record at offset %d: %v", offset, err)
+		}
+
+		blocks = append(blocks, block)
+		s.offsets = append(s.offsets, int64(offset))
+		offset += size
+	}
+
+	if _, err := s.blocks.Seek(0, io.SeekEnd); err != nil {
+		return nil, fmt.Errorf("failed to seek block log: %v", err)
+	}
+
+	return blocks, nil
+}
+
+// AppendBlock appends a block to the block log and syncs it to disk
+func (s *FileStore) AppendBlock(block *Block) error {
+	if s.readOnly {
+		return ErrReadOnlyStore
+	}
+	offset, err := s.blocks.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("failed to seek block log: %v", err)
+	}
+	if _, err := s.blocks.Write(makeRecord(EncodeBlock(block))); err != nil {
+		return fmt.Errorf("failed to write block: %v", err)
+	}
+	if err := s.blocks.Sync(); err != nil {
+		return fmt.Errorf("failed to sync block log: %v", err)
+	}
+	s.offsets = append(s.offsets, offset)
+
+	return nil
+}
+
+// TruncateBlocks cuts the block log back to its first height blocks.
+// Interrupted before the new branch is appended, it leaves a shorter but
+// valid chain.
+func (s *FileStore) TruncateBlocks(height int64) error {
+	if s.readOnly {
+		return ErrReadOnlyStore
+	}
+	if height < 0 || height > int64(len(s.offsets)) {
+		return fmt.Errorf("cannot truncate %d stored blocks to %d", len(s.offsets), height)
+	}
+	if height == int64(len(s.offsets)) {
+		return nil
+	}
+
+	offset := s.offsets[height]
+	if err := s.truncate(offset); err != nil {
+		return err
+	}
+	if err := s.blocks.Sync(); err != nil {
+		return fmt.Errorf("failed to sync block log: %v", err)
+	}
+	if _, err := s.blocks.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek block log: %v", err)
+	}
+	s.offsets = s.offsets[:height]
+
+	return nil
+}
+
+// LoadPending reads the saved pending transactions
+func (s *FileStore) LoadPending() ([]Transaction, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, pendingFileName))
+	if os.IsNotExist(err) {
+		return []Transaction{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pending transactions: %v", err)
+	}
+
+	payload, size, err := readRecord(data)
+	if err != nil || size != len(data) {
+		return nil, fmt.Errorf("pending transactions file is corrupt")
+	}
+
+	return DecodeTransactions(payload)
+}
+
+// SavePending atomically replaces the saved pending transactions
+func (s *FileStore) SavePending(txs []Transaction) error {
+	if s.readOnly {
+		return ErrReadOnlyStore
+	}
+	data := makeRecord(EncodeTransactions(txs))
+	return writeFileAtomic(filepath.Join(s.dir, pendingFileName), data)
+}
+
+// Close closes the block log and releases the data directory
+func (s *FileStore) Close() error {
+	var err error
+	if s.blocks != nil {
+		err = s.blocks.Close()
+	}
+	if s.lock != nil {
+		s.lock.Close()
+	}
+	return err
+}
+
+// truncate cuts the block log back to the given offset
+func (s *FileStore) truncate(offset int64) error {
+	if err := s.blocks.Truncate(offset); err != nil {
+		return fmt.Errorf("failed to truncate block log: %v", err)
+	}
+	return nil
+}
+
+// makeRecord frames a payload with its length and checksums
+func makeRecord(payload []byte) []byte {
+	record := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(record[0:4]))
+	binary.BigEndian.PutUint32(record[8:12], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeaderSize:], payload)
+	return record
+}
+
+// readRecord reads one framed record from the start of data and returns its
+// payload and full size. An append cut short leaves a record that runs past
+// the end of data, or fails its payload checksum as the last record; that
+// is errTornRecord. Any other damage is errCorruptRecord.
+func readRecord(data []byte) ([]byte, int, error) {
+	if len(data) < recordHeaderSize {
+		return nil, len(data), errTornRecord
+	}
+	// The length is checked on its own, so a damaged one is never mistaken
+	// for a record running past the end
+	if crc32.ChecksumIEEE(data[0:4]) != binary.BigEndian.Uint32(data[4:8]) {
+		return nil, 0, errCorruptRecord
+	}
+
+	length := int(binary.BigEndian.Uint32(data[0:4]))
+	if length > len(data)-recordHeaderSize {
+		return nil, len(data), errTornRecord
+	}
+
+	size := recordHeaderSize + length
+	payload := data[recordHeaderSize:size]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(data[8:12]) {
+		if size == len(data) {
+			return nil, size, errTornRecord
+		}
+		return nil, size, errCorruptRecord
+	}
+	return payload, size, nil
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", tmp, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %v", tmp, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync %s: %v", tmp, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %v", tmp, err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to rename %s: %v", tmp, err)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package core
+
+import "os"
+
+// lockFile does nothing on platforms without flock, so a data directory
+// is not protected from being opened twice there
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,249 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// storedChain opens a chain persisted in a fresh directory and mines
+// blocks on it, returning the directory
+func storedChain(t *testing.T, blocks int) string {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultConfig()
+	config.GenesisTimestamp = 1700000000
+	chain, err := OpenBlockchain(store, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < blocks; i++ {
+		if _, err := chain.MinePendingTransactions("0x00000000000000000000000000000000000000bb"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := chain.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestFileStoreReload(t *testing.T) {
+	key := newTestKey(t)
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.GenesisTimestamp = 1700000000
+	config.Alloc = map[string]float64{AddressFromPublicKey(key.PubKey()): 100}
+
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := OpenBlockchain(store, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.AddTransaction(signedTransfer(t, key, "0x00000000000000000000000000000000000000aa", 5, 0.1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.MinePendingTransactions("0x00000000000000000000000000000000000000bb"); err != nil {
+		t.Fatal(err)
+	}
+	pending := signedTransfer(t, key, "0x00000000000000000000000000000000000000aa", 1, 0.1, 1)
+	if err := chain.AddTransaction(pending); err != nil {
+		t.Fatal(err)
+	}
+	tip := chain.LastBlock().Hash
+	chain.Close()
+
+	store, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := OpenBlockchain(store, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloaded.Close()
+	if reloaded.Height() != 1 || reloaded.LastBlock().Hash != tip {
+		t.Fatalf("reloaded chain ends at %d %s, want 1 %s", reloaded.Height(), reloaded.LastBlock().Hash, tip)
+	}
+	if got := reloaded.GetBalance("0x00000000000000000000000000000000000000aa"); got != 5 {
+		t.Fatalf("reloaded balance %v, want 5", got)
+	}
+	if txs := reloaded.Pending(); len(txs) != 1 || txs[0].ID != pending.ID {
+		t.Fatalf("reloaded pending %v, want the saved transaction", txs)
+	}
+
+	// Blocks mined after the reload are appended to the same log
+	if _, err := reloaded.MinePendingTransactions("0x00000000000000000000000000000000000000bb"); err != nil {
+		t.Fatal(err)
+	}
+	read, _ := NewReadOnlyFileStore(dir)
+	defer read.Close()
+	if blocks, err := read.LoadBlocks(); err != nil || len(blocks) != 3 {
+		t.Fatalf("log holds %d blocks after appending: %v", len(blocks), err)
+	}
+}
+
+func TestFileStoreTruncatesTornTail(t *testing.T) {
+	dir := storedChain(t, 2)
+	path := filepath.Join(dir, blocksFileName)
+	intact, _ := os.ReadFile(path)
+
+	// A crash halfway through appending a record leaves a torn tail
+	if err := os.WriteFile(path, append(append([]byte{}, intact...), 0, 0, 1, 0, 9), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := store.LoadBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 3 {
+		t.Fatalf("loaded %d blocks, want 3", len(blocks))
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(after, intact) {
+		t.Fatal("torn tail was not truncated")
+	}
+
+	// New blocks follow the last intact record
+	if err := store.AppendBlock(blocks[2]); err != nil {
+		t.Fatal(err)
+	}
+	if blocks, err := store.LoadBlocks(); err != nil || len(blocks) != 4 {
+		t.Fatalf("loaded %d blocks after appending: %v", len(blocks), err)
+	}
+	store.Close()
+
+	// A complete header whose payload was cut short is torn too
+	first, _, err := readRecord(intact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial := makeRecord(first)[:recordHeaderSize+len(first)/2]
+	if err := os.WriteFile(path, append(append([]byte{}, intact...), partial...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocks, err := store.LoadBlocks(); err != nil || len(blocks) != 3 {
+		t.Fatalf("loaded %d blocks past a torn payload: %v", len(blocks), err)
+	}
+	store.Close()
+
+	// Damage before the tail is corruption, not a torn write, whether it
+	// hits a payload or a length claiming to run past the end of the log
+	for name, offset := range map[string]int{
+		"payload": recordHeaderSize + 1,
+		"length":  0,
+	} {
+		damaged := append([]byte{}, intact...)
+		damaged[offset] ^= 0x7f
+		if err := os.WriteFile(path, damaged, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		store, err = NewFileStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.LoadBlocks(); err == nil {
+			t.Errorf("record with a corrupt %s was loaded", name)
+		}
+		store.Close()
+		if after, _ := os.ReadFile(path); !bytes.Equal(after, damaged) {
+			t.Errorf("corrupt %s truncated the block log", name)
+		}
+	}
+}
+
+func TestFileStoreLocksDataDirectory(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileStore(dir); !errors.Is(err, ErrStoreLocked) {
+		t.Fatalf("second store on one directory gave %v", err)
+	}
+
+	// Readers may still look at a directory a node holds
+	read, err := NewReadOnlyFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read.Close()
+
+	store.Close()
+	again, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("directory still locked after close: %v", err)
+	}
+	again.Close()
+}
+
+func TestReadOnlyFileStore(t *testing.T) {
+	dir := storedChain(t, 2)
+	path := filepath.Join(dir, blocksFileName)
+
+	// A record a running node is halfway through writing
+	torn := []byte{0xff, 0, 0, 0, 1, 2}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write(torn)
+	file.Close()
+	before, _ := os.ReadFile(path)
+
+	store, err := NewReadOnlyFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	blocks, err := store.LoadBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 3 {
+		t.Fatalf("loaded %d blocks, want 3", len(blocks))
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(after, before) {
+		t.Fatal("read-only store changed the block log")
+	}
+
+	if err := store.AppendBlock(blocks[0]); !errors.Is(err, ErrReadOnlyStore) {
+		t.Fatalf("append gave %v", err)
+	}
+	if err := store.SavePending(nil); !errors.Is(err, ErrReadOnlyStore) {
+		t.Fatalf("saving pending transactions gave %v", err)
+	}
+	if err := store.TruncateBlocks(1); !errors.Is(err, ErrReadOnlyStore) {
+		t.Fatalf("truncate gave %v", err)
+	}
+
+	// A directory without a chain is not created or written to
+	missing := filepath.Join(t.TempDir(), "missing")
+	empty, err := NewReadOnlyFileStore(missing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocks, err := empty.LoadBlocks(); err != nil || len(blocks) != 0 {
+		t.Fatalf("empty store loaded %d blocks: %v", len(blocks), err)
+	}
+	empty.Close()
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Fatal("read-only store created its directory")
+	}
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on f without waiting. The lock is
+// released when f is closed, including when the process dies.
+func lockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return ErrStoreLocked
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock data directory: %v", err)
+	}
+	return nil
+}
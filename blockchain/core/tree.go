@@ -0,0 +1,373 @@
+package core
+
+import (
+	"fmt"
+	"math/big"
+)
+
+const (
+	// maxOrphans bounds how many blocks with unknown parents are kept
+	maxOrphans = 256
+	// finalityDepth is how many blocks below the tip a block becomes final.
+	// No branch may fork below a final block, so side branches that do are
+	// pruned.
+	finalityDepth = 100
+)
+
+// blockNode is a block in the tree of every known branch
+type blockNode struct {
+	block    *Block
+	parent   *blockNode
+	children []*blockNode
+	// work is the cumulative fork-choice weight up to and including block
+	work *big.Int
+	// state is the account state after block, kept for validated blocks
+	// that are not final so a branch forking there is validated from it.
+	// It is never modified.
+	state *AccountState
+}
+
+// orphanPool holds blocks whose parent has not arrived yet
+type orphanPool struct {
+	blocks   map[string]*Block
+	byParent map[string][]string
+	// order lists orphans oldest first so the oldest is evicted first
+	order []string
+}
+
+// newOrphanPool creates an empty orphan pool
+func newOrphanPool() *orphanPool {
+	return &orphanPool{
+		blocks:   map[string]*Block{},
+		byParent: map[string][]string{},
+	}
+}
+
+// has reports whether a block is waiting in the pool
+func (p *orphanPool) has(hash string) bool {
+	_, ok := p.blocks[hash]
+	return ok
+}
+
+// add stores an orphan, evicting the oldest when the pool is full
+func (p *orphanPool) add(block *Block) {
+	if p.has(block.Hash) {
+		return
+	}
+	for len(p.order) >= maxOrphans {
+		p.remove(p.order[0])
+	}
+
+	p.blocks[block.Hash] = block
+	p.byParent[block.PrevHash] = append(p.byParent[block.PrevHash], block.Hash)
+	p.order = append(p.order, block.Hash)
+}
+
+// takeChildren removes and returns the orphans waiting on a parent
+func (p *orphanPool) takeChildren(parentHash string) []*Block {
+	children := []*Block{}
+	for _, hash := range append([]string{}, p.byParent[parentHash]...) {
+		children = append(children, p.blocks[hash])
+		p.remove(hash)
+	}
+	return children
+}
+
+// remove drops an orphan from every index
+func (p *orphanPool) remove(hash string) {
+	block, ok := p.blocks[hash]
+	if !ok {
+		return
+	}
+	delete(p.blocks, hash)
+
+	siblings := p.byParent[block.PrevHash]
+	for i, sibling := range siblings {
+		if sibling == hash {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(p.byParent, block.PrevHash)
+	} else {
+		p.byParent[block.PrevHash] = siblings
+	}
+
+	for i, ordered := range p.order {
+		if ordered == hash {
+			p.order = append(p.order[:i], p.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// resetTree rebuilds the block tree from the canonical chain alone. Only
+// the tip keeps its state; the others are rebuilt when a branch needs them.
+func (bc *Blockchain) resetTree() {
+	bc.nodes = map[string]*blockNode{}
+	var parent *blockNode
+	for _, block := range bc.Chain {
+		parent = bc.addNode(block, parent)
+	}
+	parent.state = bc.state
+	bc.finalized = max(bc.finalHeight(), 0)
+}
+
+// addNode records a block in the tree under its parent
+func (bc *Blockchain) addNode(block *Block, parent *blockNode) *blockNode {
+	if node, ok := bc.nodes[block.Hash]; ok {
+		return node
+	}
+
+	work := new(big.Int).Set(bc.Config.Engine.Work(block))
+	if parent != nil {
+		work.Add(work, parent.work)
+	}
+	node := &blockNode{block: block, parent: parent, work: work}
+	if parent != nil {
+		parent.children = append(parent.children, node)
+	}
+	bc.nodes[block.Hash] = node
+	return node
+}
+
+// removeBranch drops a node and everything built on it from the tree
+func (bc *Blockchain) removeBranch(root *blockNode) {
+	if parent := root.parent; parent != nil {
+		for i, child := range parent.children {
+			if child == root {
+				parent.children = append(parent.children[:i:i], parent.children[i+1:]...)
+				break
+			}
+		}
+	}
+
+	queue := []*blockNode{root}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		delete(bc.nodes, node.block.Hash)
+		queue = append(queue, node.children...)
+	}
+}
+
+// finalHeight returns the height of the newest final block
+func (bc *Blockchain) finalHeight() int64 {
+	return int64(len(bc.Chain)-1) - finalityDepth
+}
+
+// pruneTree drops the side branches forking below the final height and the
+// states of the final blocks, which no branch can fork from any more
+func (bc *Blockchain) pruneTree() {
+	for ; bc.finalized < bc.finalHeight(); bc.finalized++ {
+		node := bc.nodes[bc.Chain[bc.finalized].Hash]
+		node.state = nil
+		for _, child := range append([]*blockNode{}, node.children...) {
+			if !bc.canonical(child) {
+				bc.removeBranch(child)
+			}
+		}
+	}
+}
+
+// canonical reports whether a node is on the canonical chain
+func (bc *Blockchain) canonical(node *blockNode) bool {
+	index := node.block.Index
+	return index < int64(len(bc.Chain)) && bc.Chain[index].Hash == node.block.Hash
+}
+
+// stateAt returns the state after a canonical block. Blocks whose state was
+// not kept, as after a restart, have it rebuilt from genesis.
+func (bc *Blockchain) stateAt(node *blockNode) *AccountState {
+	if node.state != nil {
+		return node.state
+	}
+	return bc.checkChain(&ValidationReport{}, bc.Chain[:node.block.Index+1])
+}
+
+// checkBranch records every violation in the blocks of node's branch that
+// are not on the canonical chain, checking them from the state where the
+// branch forks. It returns the state after each of those blocks, the state
+// after node itself and a report of the transaction IDs used up to it. The
+// state after node must be copied before it is changed.
+func (bc *Blockchain) checkBranch(report *ValidationReport, node *blockNode) ([]*AccountState, *AccountState, func(id string) bool) {
+	fork := node
+	for !bc.canonical(fork) {
+		fork = fork.parent
+	}
+	height := fork.block.Index
+	inBranch := map[string]bool{}
+	seen := func(id string) bool {
+		if location, ok := bc.index.txs[id]; ok && location.height <= height {
+			return true
+		}
+		return inBranch[id]
+	}
+
+	chain := bc.branch(node)
+	state := bc.stateAt(fork)
+	states := []*AccountState{}
+	for i := height + 1; i <= node.block.Index; i++ {
+		state = state.Copy()
+		bc.checkBlock(report, chain[:i], chain[i], state, seen)
+		for _, tx := range chain[i].Transactions {
+			inBranch[tx.ID] = true
+		}
+		states = append(states, state)
+	}
+	report.Blocks += len(states)
+
+	return states, state, seen
+}
+
+// tipNode returns the tree node of the canonical tip
+func (bc *Blockchain) tipNode() *blockNode {
+	return bc.nodes[bc.Chain[len(bc.Chain)-1].Hash]
+}
+
+// branch returns the blocks from genesis up to and including node
+func (bc *Blockchain) branch(node *blockNode) []*Block {
+	blocks := make([]*Block, node.block.Index+1)
+	for n := node; n != nil; n = n.parent {
+		blocks[n.block.Index] = n.block
+	}
+	return blocks
+}
+
+// addSideBlock stores a header-valid block that does not extend the tip,
+// and switches to its branch if that now carries the most work
+func (bc *Blockchain) addSideBlock(block *Block, parent *blockNode) error {
+	if block.Index <= bc.finalized {
+		return fmt.Errorf("block %d forks below final block %d", block.Index, bc.finalized)
+	}
+	if err := bc.validateHeader(bc.branch(parent), block); err != nil {
+		return err
+	}
+
+	node := bc.addNode(block, parent)
+	if node.work.Cmp(bc.tipNode().work) <= 0 {
+		return nil
+	}
+
+	// The transactions of the new branch are only checked now that it wins,
+	// and only from where it forks
+	report := &ValidationReport{}
+	states, state, _ := bc.checkBranch(report, node)
+	if err := report.Err(); err != nil {
+		bc.removeBranch(node)
+		return fmt.Errorf("heavier branch is invalid: %v", err)
+	}
+	for i, n := len(states)-1, node; i >= 0; i, n = i-1, n.parent {
+		n.state = states[i]
+	}
+	return bc.switchChain(bc.branch(node), state)
+}
+
+// switchChain makes chain canonical, persisting it, returning the
+// transactions of abandoned blocks to the mempool and announcing the reorg
+func (bc *Blockchain) switchChain(chain []*Block, state *AccountState) error {
+	// Find where the old and new chains diverge
+	fork := 0
+	for fork < len(chain) && fork < len(bc.Chain) && chain[fork].Hash == bc.Chain[fork].Hash {
+		fork++
+	}
+	removed := append([]*Block{}, bc.Chain[fork:]...)
+	added := append([]*Block{}, chain[fork:]...)
+
+	// Only the blocks past the fork are rewritten
+	if bc.store != nil {
+		if err := bc.store.TruncateBlocks(int64(fork)); err != nil {
+			return fmt.Errorf("failed to persist chain: %v", err)
+		}
+		for _, block := range added {
+			if err := bc.store.AppendBlock(block); err != nil {
+				return fmt.Errorf("failed to persist chain: %v", err)
+			}
+		}
+	}
+
+	bc.Chain = append([]*Block{}, chain...)
+	bc.state = state
+	if fork == 0 {
+		// A different genesis starts a new tree
+		bc.resetTree()
+		bc.orphans = newOrphanPool()
+		bc.index = newChainIndex(chain)
+	} else {
+		// Roll the index back to the fork point, then forward along the new branch
+		for i := len(removed) - 1; i >= 0; i-- {
+			bc.index.removeBlock(removed[i])
+		}
+		parent := bc.nodes[chain[fork-1].Hash]
+		for _, block := range added {
+			bc.index.addBlock(block)
+			parent = bc.addNode(block, parent)
+		}
+		parent.state = state
+		bc.pruneTree()
+	}
+	bc.notifyTipChanged()
+
+	// Abandoned transactions get another chance unless the new chain has them
+	included := transactionIDs(added...)
+	returned := []Transaction{}
+	for _, block := range removed {
+		for _, tx := range block.Transactions {
+			if tx.From != SystemAddress && !included[tx.ID] {
+				returned = append(returned, tx)
+			}
+		}
+	}
+	if err := bc.resetPending(included, returned); err != nil {
+		return err
+	}
+
+	for _, block := range added {
+		bc.blockFeed.send(block)
+	}
+	if len(removed) > 0 {
+		event := ReorgEvent{Removed: removed, Added: added}
+		if fork > 0 {
+			event.CommonAncestor = chain[fork-1]
+		}
+		bc.reorgFeed.send(event)
+	}
+	return nil
+}
+
+// checkOrphan checks the parts of a block with an unknown parent that do
+// not depend on the parent: its hash, Merkle root, timestamp and seal
+func (bc *Blockchain) checkOrphan(block *Block) error {
+	if block.Index <= 0 {
+		return fmt.Errorf("orphan block has index %d", block.Index)
+	}
+	if block.Hash != calculateHash(block) {
+		return fmt.Errorf("orphan block hash is incorrect")
+	}
+	if block.MerkleRoot != ComputeMerkleRoot(block.Transactions) {
+		return fmt.Errorf("orphan block merkle root is incorrect")
+	}
+	if block.Timestamp > bc.Config.now().Add(maxFutureBlockTime).Unix() {
+		return fmt.Errorf("orphan block timestamp %d is too far in the future", block.Timestamp)
+	}
+	if err := bc.Config.Engine.VerifySeal(block); err != nil {
+		return fmt.Errorf("orphan block seal is invalid: %v", err)
+	}
+	return nil
+}
+
+// connectOrphans adds every orphan that was waiting on the given blocks,
+// and in turn any waiting on those
+func (bc *Blockchain) connectOrphans(blocks ...*Block) {
+	queue := append([]*Block{}, blocks...)
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		for _, orphan := range bc.orphans.takeChildren(parent.Hash) {
+			if bc.addBlock(orphan) == nil {
+				queue = append(queue, orphan)
+			}
+		}
+	}
+}
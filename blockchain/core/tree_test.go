@@ -0,0 +1,285 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testChain opens an in-memory chain with a fixed genesis funding alloc
+func testChain(t *testing.T, alloc map[string]float64) *Blockchain {
+	t.Helper()
+	config := DefaultConfig()
+	config.GenesisTimestamp = 1700000000
+	config.Alloc = alloc
+	chain, err := OpenBlockchain(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain
+}
+
+// mineBlocks mines count blocks paying miner and returns them
+func mineBlocks(t *testing.T, chain *Blockchain, miner string, count int) []*Block {
+	t.Helper()
+	blocks := make([]*Block, count)
+	for i := range blocks {
+		block, err := chain.MinePendingTransactions(miner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks[i] = block
+	}
+	return blocks
+}
+
+// nextReorg waits for the next event of a reorg subscription
+func nextReorg(t *testing.T, sub *Subscription[ReorgEvent]) ReorgEvent {
+	t.Helper()
+	select {
+	case event := <-sub.C:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reorg event")
+		return ReorgEvent{}
+	}
+}
+
+func TestReorgReturnsTransactionsToMempool(t *testing.T) {
+	key := newTestKey(t)
+	alloc := map[string]float64{AddressFromPublicKey(key.PubKey()): 100}
+	local, remote := testChain(t, alloc), testChain(t, alloc)
+	sub := local.SubscribeReorgs()
+	defer sub.Unsubscribe()
+
+	tx := signedTransfer(t, key, "0x00000000000000000000000000000000000000aa", 5, 0.1, 0)
+	if err := local.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	abandoned := mineBlocks(t, local, "0x00000000000000000000000000000000000000a1", 1)
+	if len(local.Pending()) != 0 {
+		t.Fatal("mined transaction is still pending")
+	}
+
+	// A longer branch without the transaction overtakes the local one
+	branch := mineBlocks(t, remote, "0x00000000000000000000000000000000000000a2", 2)
+	for _, block := range branch {
+		if err := local.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if local.LastBlock().Hash != branch[1].Hash {
+		t.Fatal("chain did not switch to the heavier branch")
+	}
+
+	event := nextReorg(t, sub)
+	if event.CommonAncestor == nil || event.CommonAncestor.Index != 0 {
+		t.Fatalf("common ancestor %+v, want the genesis block", event.CommonAncestor)
+	}
+	if len(event.Removed) != 1 || event.Removed[0].Hash != abandoned[0].Hash {
+		t.Fatalf("removed %d blocks, want the abandoned one", len(event.Removed))
+	}
+	if len(event.Added) != 2 || event.Added[1].Hash != branch[1].Hash {
+		t.Fatalf("added %d blocks, want the branch", len(event.Added))
+	}
+
+	pending := local.Pending()
+	if len(pending) != 1 || pending[0].ID != tx.ID {
+		t.Fatalf("pending %v, want the abandoned transaction back", pending)
+	}
+}
+
+func TestReorgFromGenesis(t *testing.T) {
+	source := testChain(t, nil)
+	blocks := mineBlocks(t, source, "0x00000000000000000000000000000000000000a1", 1)
+
+	// A fresh chain stamps its own genesis, so it shares no block with source
+	config := DefaultConfig()
+	fresh, err := OpenBlockchain(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldGenesis := fresh.GetBlock(0)
+	sub := fresh.SubscribeReorgs()
+	defer sub.Unsubscribe()
+
+	if err := fresh.ReplaceChain(source.GetBlocks(0)); err != nil {
+		t.Fatal(err)
+	}
+	event := nextReorg(t, sub)
+	if event.CommonAncestor != nil {
+		t.Fatalf("common ancestor %+v, want none", event.CommonAncestor)
+	}
+	if len(event.Removed) != 1 || event.Removed[0].Hash != oldGenesis.Hash {
+		t.Fatal("reorg does not remove the old genesis block")
+	}
+	if len(event.Added) != 2 || event.Added[1].Hash != blocks[0].Hash {
+		t.Fatal("reorg does not add the new chain")
+	}
+}
+
+func TestOrphanBlocks(t *testing.T) {
+	local, remote := testChain(t, nil), testChain(t, nil)
+	blocks := mineBlocks(t, remote, "0x00000000000000000000000000000000000000a1", 2)
+
+	// Blocks that fail the checks an orphan can get are not pooled
+	for name, alter := range map[string]func(block *Block){
+		"wrong hash": func(block *Block) { block.Hash = blocks[0].Hash },
+		"wrong merkle root": func(block *Block) {
+			block.MerkleRoot = blocks[0].MerkleRoot
+			block.Hash = calculateHash(block)
+		},
+		"unmet difficulty": func(block *Block) {
+			block.Difficulty = 1 << 62
+			block.Hash = calculateHash(block)
+		},
+		"far future": func(block *Block) {
+			block.Timestamp = time.Now().Add(24 * time.Hour).Unix()
+			block.Hash = calculateHash(block)
+		},
+	} {
+		bad := *blocks[1]
+		alter(&bad)
+		if err := local.AddBlock(&bad); err == nil || errors.Is(err, ErrUnknownParent) {
+			t.Errorf("orphan with %s gave %v", name, err)
+		}
+	}
+
+	// A sound orphan waits for its parent and connects when it arrives
+	if err := local.AddBlock(blocks[1]); !errors.Is(err, ErrUnknownParent) {
+		t.Fatalf("orphan gave %v", err)
+	}
+	if err := local.AddBlock(blocks[0]); err != nil {
+		t.Fatal(err)
+	}
+	if local.LastBlock().Hash != blocks[1].Hash {
+		t.Fatal("orphan was not connected once its parent arrived")
+	}
+}
+
+func TestSideBranchesArePruned(t *testing.T) {
+	config := DefaultConfig()
+	config.GenesisTimestamp = 1700000000
+	config.RetargetInterval = 0
+	local, err := OpenBlockchain(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := OpenBlockchain(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A side branch of equal work is kept until its fork becomes final
+	mineBlocks(t, local, "0x00000000000000000000000000000000000000a1", 1)
+	side := mineBlocks(t, remote, "0x00000000000000000000000000000000000000a2", 2)
+	if err := local.AddBlock(side[0]); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, local, "0x00000000000000000000000000000000000000a1", finalityDepth-1)
+	if _, ok := local.nodes[side[0].Hash]; !ok {
+		t.Fatal("side branch pruned before its fork was final")
+	}
+	mineBlocks(t, local, "0x00000000000000000000000000000000000000a1", 1)
+	if _, ok := local.nodes[side[0].Hash]; ok {
+		t.Fatal("side branch forking below the final block was kept")
+	}
+	if err := local.AddBlock(side[1]); !errors.Is(err, ErrUnknownParent) {
+		t.Fatalf("block on a pruned branch gave %v", err)
+	}
+
+	// Nor may a new branch replace a final block
+	fork, err := OpenBlockchain(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	late := mineBlocks(t, fork, "0x00000000000000000000000000000000000000a3", 1)
+	if err := local.AddBlock(late[0]); err == nil || errors.Is(err, ErrUnknownParent) {
+		t.Fatalf("branch replacing a final block gave %v", err)
+	}
+	if local.nodes[local.GetBlock(0).Hash].state != nil || local.nodes[local.GetBlock(1).Hash].state == nil {
+		t.Fatal("states kept below the final block, or dropped above it")
+	}
+}
+
+func TestReorgRewritesOnlyTheNewBranch(t *testing.T) {
+	key := newTestKey(t)
+	config := DefaultConfig()
+	config.GenesisTimestamp = 1700000000
+	config.Alloc = map[string]float64{AddressFromPublicKey(key.PubKey()): 100}
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := OpenBlockchain(store, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := OpenBlockchain(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both chains share the block holding a transfer, then part ways
+	if err := local.AddTransaction(signedTransfer(t, key, "0x00000000000000000000000000000000000000aa", 5, 0.1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	shared := mineBlocks(t, local, "0x00000000000000000000000000000000000000a1", 1)
+	if err := remote.AddBlock(shared[0]); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, local, "0x00000000000000000000000000000000000000a1", 2)
+	if err := remote.AddTransaction(signedTransfer(t, key, "0x00000000000000000000000000000000000000aa", 5, 0.1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	branch := mineBlocks(t, remote, "0x00000000000000000000000000000000000000a2", 3)
+	prefix := store.offsets[2]
+	before, err := os.ReadFile(filepath.Join(dir, blocksFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopened, the chain keeps no state below its tip, so the branch is
+	// validated from a state rebuilt at the fork
+	local.Close()
+	if store, err = NewFileStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	local, err = OpenBlockchain(store, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	for _, block := range branch {
+		if err := local.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if local.LastBlock().Hash != branch[2].Hash || local.GetBalance("0x00000000000000000000000000000000000000aa") != 10 {
+		t.Fatal("chain did not switch to the heavier branch")
+	}
+	for _, block := range branch {
+		if local.nodes[block.Hash].state == nil {
+			t.Fatalf("validated block %d kept no state", block.Index)
+		}
+	}
+
+	after, err := os.ReadFile(filepath.Join(dir, blocksFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after[:prefix], before[:prefix]) || len(store.offsets) != 5 {
+		t.Fatal("reorg rewrote blocks below the fork")
+	}
+	blocks, err := store.LoadBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 5 || blocks[4].Hash != branch[2].Hash || blocks[1].Hash != shared[0].Hash {
+		t.Fatalf("store holds %d blocks, not the new chain", len(blocks))
+	}
+}
@@ -0,0 +1,371 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxFutureBlockTime is how far ahead of local time a block timestamp may be
+const maxFutureBlockTime = 2 * time.Hour
+
+// Rule names a consensus rule a block or transaction can break
+type Rule string
+
+// Rules checked by the validator
+const (
+	// RuleGenesis covers the shape and hash of the genesis block
+	RuleGenesis Rule = "genesis"
+	// RuleLinkage requires each block to name its parent's hash and follow its index
+	RuleLinkage Rule = "linkage"
+	// RuleTimestamp requires timestamps to not go backwards or run ahead of local time
+	RuleTimestamp Rule = "timestamp"
+	// RuleMerkleRoot requires the header to commit to exactly the block's transactions
+	RuleMerkleRoot Rule = "merkle-root"
+	// RuleHash requires the block hash to match its contents
+	RuleHash Rule = "hash"
+	// RuleConsensus covers the engine's checks: difficulty, proof of work or signer
+	RuleConsensus Rule = "consensus"
+	// RuleReward requires exactly one correct mining reward per block
+	RuleReward Rule = "reward"
+	// RuleDuplicateTx forbids a transaction ID from appearing twice in a chain
+	RuleDuplicateTx Rule = "duplicate-tx"
+	// RuleSignature requires every user transaction to be signed by its sender,
+	// or by enough of its keys for a multisig account
+	RuleSignature Rule = "signature"
+	// RuleTxID requires every transaction ID to be the transaction's canonical hash
+	RuleTxID Rule = "tx-id"
+	// RuleChainID requires every transaction to name the chain it is on
+	RuleChainID Rule = "chain-id"
+	// RuleNonce requires each sender's transactions to use consecutive nonces
+	RuleNonce Rule = "nonce"
+	// RuleLockTime keeps time-locked transactions out of blocks before their lock height or time
+	RuleLockTime Rule = "lock-time"
+	// RuleState requires transactions to apply to the account state, for
+	// example that senders can afford them
+	RuleState Rule = "state"
+)
+
+// ValidationError is a single rule violation found by the validator
+type ValidationError struct {
+	BlockIndex int64  `json:"blockIndex"`
+	BlockHash  string `json:"blockHash"`
+	// TxID is set when the violation is specific to one transaction
+	TxID    string `json:"txId,omitempty"`
+	Rule    Rule   `json:"rule"`
+	Message string `json:"message"`
+
+	err error
+}
+
+// Error describes the violation with its location
+func (e *ValidationError) Error() string {
+	if e.TxID != "" {
+		return fmt.Sprintf("block %d transaction %s: %s", e.BlockIndex, e.TxID, e.Message)
+	}
+	return fmt.Sprintf("block %d: %s", e.BlockIndex, e.Message)
+}
+
+// Unwrap returns the underlying error, such as ErrUnauthorizedSigner
+func (e *ValidationError) Unwrap() error {
+	return e.err
+}
+
+// ValidationReport lists every rule violation found in a chain or block
+type ValidationReport struct {
+	// Blocks is the number of blocks checked
+	Blocks int                `json:"blocks"`
+	Errors []*ValidationError `json:"errors"`
+}
+
+// Valid reports whether no rule was broken
+func (r *ValidationReport) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+// Err returns the first violation, or nil if there is none
+func (r *ValidationReport) Err() error {
+	if r.Valid() {
+		return nil
+	}
+	return r.Errors[0]
+}
+
+// add records a violation in block, optionally of a single transaction
+func (r *ValidationReport) add(block *Block, txID string, rule Rule, err error) {
+	r.Errors = append(r.Errors, &ValidationError{
+		BlockIndex: block.Index,
+		BlockHash:  block.Hash,
+		TxID:       txID,
+		Rule:       rule,
+		Message:    err.Error(),
+		err:        err,
+	})
+}
+
+// ValidateBlocks checks a full chain against the rules of config without
+// opening it. Unlike OpenBlockchain it does not stop at the first problem,
+// so it can diagnose a corrupted store.
+func ValidateBlocks(config Config, blocks []*Block) *ValidationReport {
+	bc := &Blockchain{Config: config.withDefaults()}
+	report := &ValidationReport{Errors: []*ValidationError{}}
+	bc.checkChain(report, blocks)
+	return report
+}
+
+// Validate checks the current chain against every rule and reports all
+// violations
+func (bc *Blockchain) Validate() *ValidationReport {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	report := &ValidationReport{Errors: []*ValidationError{}}
+	bc.checkChain(report, bc.Chain)
+	return report
+}
+
+// ValidateBlock checks a block against its parent, which may be on the
+// canonical chain or a side branch, without adding it
+func (bc *Blockchain) ValidateBlock(block *Block) *ValidationReport {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	report := &ValidationReport{Blocks: 1, Errors: []*ValidationError{}}
+	parent, ok := bc.nodes[block.PrevHash]
+	if !ok {
+		report.add(block, "", RuleLinkage, fmt.Errorf("parent %s is unknown", block.PrevHash))
+		return report
+	}
+
+	state, seen := bc.state, bc.index.hasTransaction
+	if parent.block.Hash != bc.Chain[len(bc.Chain)-1].Hash {
+		// Side branches are stored unvalidated beyond their headers
+		_, state, seen = bc.checkBranch(&ValidationReport{}, parent)
+	}
+	bc.checkBlock(report, bc.branch(parent), block, state.Copy(), seen)
+	return report
+}
+
+// validateChain checks a full chain starting at its genesis block and
+// returns the account state it produces
+func (bc *Blockchain) validateChain(chain []*Block) (*AccountState, error) {
+	report := &ValidationReport{}
+	state := bc.checkChain(report, chain)
+	if err := report.Err(); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// validateBlock checks a block against the chain it extends, in which
+// seen reports the transaction IDs already used, and applies it to state
+func (bc *Blockchain) validateBlock(chain []*Block, block *Block, state *AccountState, seen func(id string) bool) error {
+	report := &ValidationReport{}
+	bc.checkBlock(report, chain, block, state, seen)
+	return report.Err()
+}
+
+// validateHeader checks everything about a block that does not depend on
+// the account state, so side branches can be checked before they win
+func (bc *Blockchain) validateHeader(chain []*Block, block *Block) error {
+	report := &ValidationReport{}
+	bc.checkHeader(report, chain, block)
+	return report.Err()
+}
+
+// checkChain records every violation in chain and returns the account
+// state it produces, skipping transactions that do not apply
+func (bc *Blockchain) checkChain(report *ValidationReport, chain []*Block) *AccountState {
+	state := bc.newState()
+	if len(chain) == 0 {
+		report.Errors = append(report.Errors, &ValidationError{
+			Rule:    RuleGenesis,
+			Message: "chain is empty",
+			err:     errors.New("chain is empty"),
+		})
+		return state
+	}
+
+	bc.checkGenesis(report, chain[0], state)
+	seen := transactionIDs(chain[0])
+	for i := 1; i < len(chain); i++ {
+		bc.checkBlock(report, chain[:i], chain[i], state, func(id string) bool { return seen[id] })
+		for _, tx := range chain[i].Transactions {
+			seen[tx.ID] = true
+		}
+	}
+	report.Blocks += len(chain)
+
+	return state
+}
+
+// checkGenesis records violations in the genesis block and applies it to state
+func (bc *Blockchain) checkGenesis(report *ValidationReport, genesis *Block, state *AccountState) {
+	if genesis.Index != 0 || genesis.PrevHash != "0" {
+		report.add(genesis, "", RuleGenesis, fmt.Errorf("chain does not start with a genesis block"))
+	}
+	if genesis.MerkleRoot != ComputeMerkleRoot(genesis.Transactions) || genesis.Hash != calculateHash(genesis) {
+		report.add(genesis, "", RuleGenesis, fmt.Errorf("genesis block hash is incorrect"))
+	}
+	if genesis.Difficulty != bc.Config.Difficulty {
+		report.add(genesis, "", RuleGenesis, fmt.Errorf("genesis difficulty %d does not match configured %d",
+			genesis.Difficulty, bc.Config.Difficulty))
+	}
+	expected := newGenesisBlock(bc.Config)
+	if bc.Config.GenesisTimestamp != 0 && genesis.Hash != expected.Hash {
+		report.add(genesis, "", RuleGenesis, fmt.Errorf("genesis block %s does not match the configured genesis", genesis.Hash))
+	} else if genesis.MerkleRoot != expected.MerkleRoot {
+		// Only the configured chain ID and allocations may be minted at genesis
+		report.add(genesis, "", RuleGenesis, fmt.Errorf("genesis transactions do not match the configured allocations"))
+	}
+
+	bc.applyTransactions(report, genesis, state)
+}
+
+// checkBlock records every violation in a block extending chain, in which
+// seen reports the transaction IDs already used, and applies the block to state
+func (bc *Blockchain) checkBlock(report *ValidationReport, chain []*Block, block *Block, state *AccountState, seen func(id string) bool) {
+	bc.checkHeader(report, chain, block)
+	bc.checkReward(report, block, state.Supply)
+
+	inBlock := map[string]bool{}
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		if seen(tx.ID) || inBlock[tx.ID] {
+			report.add(block, tx.ID, RuleDuplicateTx, fmt.Errorf("transaction ID was already used"))
+		}
+		inBlock[tx.ID] = true
+
+		// IDs are derived, and the chain ID keeps transactions from being
+		// replayed on another chain
+		if err := VerifyTransactionID(tx); err != nil {
+			report.add(block, tx.ID, RuleTxID, err)
+		}
+		if tx.ChainID != bc.Config.ChainID {
+			report.add(block, tx.ID, RuleChainID, fmt.Errorf("transaction is for chain %d, not %d", tx.ChainID, bc.Config.ChainID))
+		}
+
+		// Check every user transaction is properly signed
+		if tx.From == SystemAddress {
+			continue
+		}
+		if err := VerifyTransactionSignature(tx); err != nil {
+			report.add(block, tx.ID, RuleSignature, err)
+		}
+	}
+
+	// Replay balances so no block spends more than its senders had
+	bc.applyTransactions(report, block, state)
+}
+
+// checkHeader records violations of the rules that do not depend on the
+// account state
+func (bc *Blockchain) checkHeader(report *ValidationReport, chain []*Block, block *Block) {
+	checkSeal(report, bc.Config.Engine, chain, block, bc.Config.now())
+
+	// Check the header commits to exactly these transactions
+	if block.MerkleRoot != ComputeMerkleRoot(block.Transactions) {
+		report.add(block, "", RuleMerkleRoot, fmt.Errorf("merkle root is incorrect"))
+	}
+}
+
+// VerifyHeader checks a header-only block against the headers of the chain
+// it extends: linkage, timestamps, hash and the engine's consensus seal.
+// Future timestamps are judged by clock, or the system clock if it is nil.
+// Light clients use it to follow a chain without its transactions.
+func VerifyHeader(engine Engine, chain []*Block, header *Block, clock Clock) error {
+	report := &ValidationReport{}
+	checkSeal(report, engine, chain, header, Config{Clock: clock}.now())
+	return report.Err()
+}
+
+// checkSeal records violations of the rules a header can be checked
+// against without its transactions, at the given current time
+func checkSeal(report *ValidationReport, engine Engine, chain []*Block, block *Block, now time.Time) {
+	if len(chain) == 0 {
+		report.add(block, "", RuleLinkage, fmt.Errorf("block has no parent to extend"))
+		return
+	}
+	prev := chain[len(chain)-1]
+
+	// Check if previous hash is correct
+	if block.PrevHash != prev.Hash || block.Index != prev.Index+1 {
+		report.add(block, "", RuleLinkage, fmt.Errorf("block does not link to its parent %s", prev.Hash))
+	}
+
+	// Timestamps drive retargeting, so they must move forward and not run ahead
+	if block.Timestamp < prev.Timestamp {
+		report.add(block, "", RuleTimestamp, fmt.Errorf("timestamp %d is before its parent's %d", block.Timestamp, prev.Timestamp))
+	}
+	if block.Timestamp > now.Add(maxFutureBlockTime).Unix() {
+		report.add(block, "", RuleTimestamp, fmt.Errorf("timestamp %d is too far in the future", block.Timestamp))
+	}
+
+	// Check if hash is correct
+	if block.Hash != calculateHash(block) {
+		report.add(block, "", RuleHash, fmt.Errorf("hash is incorrect"))
+	}
+
+	// Check the consensus seal: proof of work, or an authorized signature
+	if err := engine.VerifyHeader(chain, block); err != nil {
+		report.add(block, "", RuleConsensus, err)
+	}
+}
+
+// checkReward records a violation unless the block pays exactly one
+// mining reward, as its last transaction, of the block reward plus fees.
+// Supply is the number of coins that existed before the block.
+func (bc *Blockchain) checkReward(report *ValidationReport, block *Block, supply float64) {
+	var fees float64
+	var reward *Transaction
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		if tx.From != SystemAddress {
+			fees += tx.Fee
+			continue
+		}
+		if reward != nil || i != len(block.Transactions)-1 {
+			report.add(block, tx.ID, RuleReward, fmt.Errorf("only one reward is allowed, as the last transaction"))
+			continue
+		}
+		reward = tx
+	}
+
+	if reward == nil {
+		report.add(block, "", RuleReward, fmt.Errorf("block has no mining reward"))
+		return
+	}
+	if reward.Type() != TxTypeMiningReward {
+		report.add(block, reward.ID, RuleReward, fmt.Errorf("reward has type %q", reward.Type()))
+	}
+	if reward.To == "" {
+		report.add(block, reward.ID, RuleReward, fmt.Errorf("reward has no recipient"))
+	}
+	if reward.Fee != 0 {
+		report.add(block, reward.ID, RuleReward, fmt.Errorf("reward cannot pay a fee"))
+	}
+	if reward.Nonce != uint64(block.Index) {
+		report.add(block, reward.ID, RuleReward, fmt.Errorf("reward nonce %d is not the block index", reward.Nonce))
+	}
+	if expected := bc.blockReward(block.Index, supply) + fees; reward.Amount != expected {
+		report.add(block, reward.ID, RuleReward, fmt.Errorf("reward is %v, expected %v", reward.Amount, expected))
+	}
+}
+
+// applyTransactions applies a block's transactions to state one by one,
+// recording and skipping any that do not apply
+func (bc *Blockchain) applyTransactions(report *ValidationReport, block *Block, state *AccountState) {
+	state.advance(block.Index, block.Timestamp)
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		if err := state.ApplyTransaction(tx); err != nil {
+			rule := RuleState
+			if errors.Is(err, ErrNonceTooLow) || errors.Is(err, ErrNonceGap) {
+				rule = RuleNonce
+			} else if errors.Is(err, ErrTransactionLocked) {
+				rule = RuleLockTime
+			}
+			report.add(block, tx.ID, rule, err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+// reseal recomputes a tampered block's Merkle root and hash, so only the
+// rule under test is broken. Difficulty 1 accepts any hash.
+func reseal(block *Block) {
+	block.MerkleRoot = ComputeMerkleRoot(block.Transactions)
+	block.Hash = calculateHash(block)
+}
+
+func TestValidationReportRules(t *testing.T) {
+	key := newTestKey(t)
+	config := DefaultConfig()
+	config.GenesisTimestamp = 1700000000
+	config.RetargetInterval = 0
+	config.Alloc = map[string]float64{AddressFromPublicKey(key.PubKey()): 100}
+	chain, err := OpenBlockchain(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const to = "0x00000000000000000000000000000000000000aa"
+	mineBlocks(t, chain, "0x00000000000000000000000000000000000000bb", 1)
+	if err := chain.AddTransaction(signedTransfer(t, key, to, 5, 0.1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, chain, "0x00000000000000000000000000000000000000bb", 1)
+	encoded := EncodeBlocks(chain.GetBlocks(0))
+
+	if report := ValidateBlocks(config, chain.GetBlocks(0)); !report.Valid() || report.Blocks != 3 {
+		t.Fatalf("valid chain gave %+v", report)
+	}
+
+	// Each alteration applies to block 2, whose first transaction is the transfer
+	locked := Transaction{To: to, Amount: 5, Fee: 0.1, Timestamp: 1700000000, LockHeight: 10}
+	if err := SignTransaction(&locked, key); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		rule  Rule
+		alter func(blocks []*Block)
+	}{
+		{RuleGenesis, func(blocks []*Block) {
+			blocks[0].Timestamp++
+			reseal(blocks[0])
+		}},
+		{RuleLinkage, func(blocks []*Block) {
+			blocks[2].PrevHash = blocks[0].Hash
+			reseal(blocks[2])
+		}},
+		{RuleTimestamp, func(blocks []*Block) {
+			blocks[2].Timestamp = blocks[1].Timestamp - 1
+			reseal(blocks[2])
+		}},
+		{RuleTimestamp, func(blocks []*Block) {
+			blocks[2].Timestamp = time.Now().Add(3 * time.Hour).Unix()
+			reseal(blocks[2])
+		}},
+		{RuleMerkleRoot, func(blocks []*Block) {
+			blocks[2].MerkleRoot = blocks[1].MerkleRoot
+			blocks[2].Hash = calculateHash(blocks[2])
+		}},
+		{RuleHash, func(blocks []*Block) {
+			blocks[2].Nonce++
+		}},
+		{RuleConsensus, func(blocks []*Block) {
+			blocks[2].Difficulty = 1 << 62
+			reseal(blocks[2])
+		}},
+		{RuleReward, func(blocks []*Block) {
+			blocks[2].Transactions[1].Amount++
+			reseal(blocks[2])
+		}},
+		{RuleDuplicateTx, func(blocks []*Block) {
+			txs := blocks[2].Transactions
+			blocks[2].Transactions = []Transaction{txs[0], txs[0], txs[1]}
+			reseal(blocks[2])
+		}},
+		{RuleTxID, func(blocks []*Block) {
+			blocks[2].Transactions[0].ID = blocks[2].Transactions[1].ID
+			reseal(blocks[2])
+		}},
+		{RuleSignature, func(blocks []*Block) {
+			blocks[2].Transactions[0].Signature = locked.Signature
+			reseal(blocks[2])
+		}},
+		{RuleNonce, func(blocks []*Block) {
+			blocks[2].Transactions[0] = signedTransfer(t, key, to, 5, 0.1, 3)
+			reseal(blocks[2])
+		}},
+		{RuleLockTime, func(blocks []*Block) {
+			blocks[2].Transactions[0] = locked
+			reseal(blocks[2])
+		}},
+		{RuleState, func(blocks []*Block) {
+			blocks[2].Transactions[0] = signedTransfer(t, key, to, 500, 0.1, 0)
+			reseal(blocks[2])
+		}},
+	} {
+		blocks, err := DecodeBlocks(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		test.alter(blocks)
+		report := ValidateBlocks(config, blocks)
+		if !hasRule(report, test.rule) {
+			t.Errorf("%s violation reported as %v", test.rule, report.Errors)
+		}
+	}
+
+	// A transaction is only valid on the chain it names
+	other := config
+	other.ChainID = 7
+	if report := ValidateBlocks(other, chain.GetBlocks(0)); !hasRule(report, RuleChainID) {
+		t.Errorf("transfer for another chain reported as %v", report.Errors)
+	}
+}
+
+// hasRule reports whether a report holds a violation of rule
+func hasRule(report *ValidationReport, rule Rule) bool {
+	for _, violation := range report.Errors {
+		if violation.Rule == rule {
+			return true
+		}
+	}
+	return false
+}
+
+func TestVerifyHeaderUsesClock(t *testing.T) {
+	chain := testChain(t, nil)
+	block := mineBlocks(t, chain, "0x00000000000000000000000000000000000000bb", 1)[0]
+	headers := chain.GetHeaders(0, 1)
+	engine := chain.Engine()
+
+	if err := VerifyHeader(engine, headers, block.Header(), nil); err != nil {
+		t.Fatal(err)
+	}
+	// Judged by a clock long before it, the block is from the future
+	past := &fixedClock{now: time.Unix(block.Timestamp, 0).Add(-3 * time.Hour)}
+	if err := VerifyHeader(engine, headers, block.Header(), past); err == nil {
+		t.Fatal("header ahead of the clock accepted")
+	}
+	// A header with nothing to extend is an error, not a panic
+	if err := VerifyHeader(engine, nil, block.Header(), nil); err == nil {
+		t.Fatal("header without a parent accepted")
+	}
+}
@@ -0,0 +1,250 @@
+package light
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"0xygen.thesphere.online/blockchain/core"
+	"0xygen.thesphere.online/blockchain/rpc"
+)
+
+// headerBatch is how many headers are requested at a time
+const headerBatch = 500
+
+var (
+	// ErrUnknownTransaction is returned when the source does not know a transaction
+	ErrUnknownTransaction = errors.New("transaction is unknown")
+	// ErrPending is returned when a transaction has not been mined yet
+	ErrPending = errors.New("transaction is not mined yet")
+	// ErrUnknownBlock is returned when a proof points at a block outside the verified header chain
+	ErrUnknownBlock = errors.New("block is not on the verified header chain")
+)
+
+// Source is a full node a light client fetches headers and proofs from,
+// usually an *rpc.Client. Nothing it returns is trusted without checking.
+type Source interface {
+	Headers(ctx context.Context, from int64, count int) ([]*core.Block, error)
+	TransactionByID(ctx context.Context, id string) (*rpc.TransactionResult, error)
+	TransactionProof(ctx context.Context, id string) (*core.MerkleProof, error)
+}
+
+// VerifiedTransaction is a transaction proven to be in a verified block
+type VerifiedTransaction struct {
+	Transaction   core.Transaction `json:"transaction"`
+	BlockIndex    int64            `json:"blockIndex"`
+	BlockHash     string           `json:"blockHash"`
+	Confirmations int64            `json:"confirmations"`
+}
+
+// Client follows a chain by its headers alone. It checks each header's
+// linkage, timestamps, hash and consensus seal, keeps the branch with the
+// most work, and checks transactions against it with Merkle proofs.
+type Client struct {
+	engine core.Engine
+	source Source
+
+	// syncing serialises Sync calls so branches are built on a stable base
+	syncing sync.Mutex
+
+	mu      sync.RWMutex
+	headers []*core.Block
+	work    *big.Int
+	// clock bounds future header timestamps; nil is the system clock
+	clock core.Clock
+}
+
+// NewClient creates a light client for the chain a genesis file defines.
+// The genesis header is derived locally, so the source cannot substitute
+// another chain.
+func NewClient(genesis *core.Genesis, source Source) *Client {
+	config := genesis.Config()
+	engine := config.Engine
+	if engine == nil {
+		engine = core.NewPoW(config.RetargetInterval, config.TargetBlockTime)
+	}
+
+	root := genesis.Block().Header()
+	return &Client{
+		engine:  engine,
+		source:  source,
+		headers: []*core.Block{root},
+		work:    new(big.Int).Set(engine.Work(root)),
+	}
+}
+
+// Height returns the index of the verified tip
+func (c *Client) Height() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return int64(len(c.headers) - 1)
+}
+
+// Tip returns the verified tip header
+func (c *Client) Tip() *core.Block {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.headers[len(c.headers)-1]
+}
+
+// Header returns the verified header at an index, or nil if there is none
+func (c *Client) Header(index int64) *core.Block {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if index < 0 || index >= int64(len(c.headers)) {
+		return nil
+	}
+	return c.headers[index]
+}
+
+// SetClock makes the client judge header timestamps by clock instead of the
+// system clock
+func (c *Client) SetClock(clock core.Clock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clock = clock
+}
+
+// Work returns the cumulative work of the verified header chain
+func (c *Client) Work() *big.Int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return new(big.Int).Set(c.work)
+}
+
+// Sync fetches the source's headers past the point where it agrees with
+// the local chain, verifies them and switches to them if they carry more
+// work. A source on a lighter branch leaves the local chain unchanged.
+func (c *Client) Sync(ctx context.Context) error {
+	c.syncing.Lock()
+	defer c.syncing.Unlock()
+
+	start, err := c.forkPoint(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.mu.RLock()
+	candidate := append([]*core.Block{}, c.headers[:start]...)
+	clock := c.clock
+	c.mu.RUnlock()
+
+	for from := start; ; {
+		batch, err := c.source.Headers(ctx, from, headerBatch)
+		if err != nil {
+			return fmt.Errorf("failed to fetch headers: %v", err)
+		}
+		for _, header := range batch {
+			if err := core.VerifyHeader(c.engine, candidate, header, clock); err != nil {
+				return fmt.Errorf("source sent an invalid header: %v", err)
+			}
+			candidate = append(candidate, header)
+		}
+		if len(batch) < headerBatch {
+			break
+		}
+		from += int64(len(batch))
+	}
+
+	work := new(big.Int)
+	for _, header := range candidate {
+		work.Add(work, c.engine.Work(header))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if work.Cmp(c.work) > 0 {
+		c.headers = candidate
+		c.work = work
+	}
+	return nil
+}
+
+// forkPoint returns the first index at which the source's chain may differ
+// from ours, stepping back exponentially until its header there links to
+// one we have verified
+func (c *Client) forkPoint(ctx context.Context) (int64, error) {
+	height := c.Height()
+	for back := int64(0); ; back = back*2 + 1 {
+		start := height + 1 - back
+		if start < 1 {
+			start = 1
+		}
+
+		batch, err := c.source.Headers(ctx, start, 1)
+		if err != nil {
+			return 0, fmt.Errorf("failed to fetch headers: %v", err)
+		}
+		if len(batch) > 0 && batch[0].PrevHash == c.Header(start-1).Hash {
+			return start, nil
+		}
+		if start == 1 {
+			if len(batch) == 0 {
+				// The source has nothing beyond genesis
+				return start, nil
+			}
+			return 0, fmt.Errorf("source is on a different chain")
+		}
+	}
+}
+
+// VerifyProof checks a Merkle proof against the verified header it names
+func (c *Client) VerifyProof(proof *core.MerkleProof) error {
+	header := c.Header(proof.BlockIndex)
+	if header == nil || header.Hash != proof.BlockHash {
+		return ErrUnknownBlock
+	}
+	if proof.MerkleRoot != header.MerkleRoot {
+		return fmt.Errorf("proof merkle root does not match block %d", header.Index)
+	}
+	if !core.VerifyMerkleProof(proof.TxHash, proof.Steps, header.MerkleRoot) {
+		return fmt.Errorf("merkle proof is invalid")
+	}
+	return nil
+}
+
+// VerifyTransaction fetches a transaction and its inclusion proof from the
+// source and checks both against the verified headers. Sync first so
+// recent blocks are known.
+func (c *Client) VerifyTransaction(ctx context.Context, id string) (*VerifiedTransaction, error) {
+	result, err := c.source.TransactionByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch transaction: %v", err)
+	}
+	if result == nil {
+		return nil, ErrUnknownTransaction
+	}
+	if result.Pending {
+		return nil, ErrPending
+	}
+	// The source could answer with another transaction and its genuine proof,
+	// so the transaction must be the one asked for and carry its real ID
+	if result.Transaction.ID != id {
+		return nil, fmt.Errorf("source returned transaction %s for %s", result.Transaction.ID, id)
+	}
+	if err := core.VerifyTransactionID(&result.Transaction); err != nil {
+		return nil, fmt.Errorf("transaction %s: %v", id, err)
+	}
+
+	proof, err := c.source.TransactionProof(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch proof: %v", err)
+	}
+	// Verify recomputes the leaf from the transaction, binding the proof to it
+	if proof.TxID != id || !proof.Verify(&result.Transaction) {
+		return nil, fmt.Errorf("proof does not match transaction %s", id)
+	}
+	if err := c.VerifyProof(proof); err != nil {
+		return nil, err
+	}
+
+	return &VerifiedTransaction{
+		Transaction:   result.Transaction,
+		BlockIndex:    proof.BlockIndex,
+		BlockHash:     proof.BlockHash,
+		Confirmations: c.Height() - proof.BlockIndex + 1,
+	}, nil
+}
@@ -0,0 +1,258 @@
+package light
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+
+	"0xygen.thesphere.online/blockchain/core"
+	"0xygen.thesphere.online/blockchain/rpc"
+)
+
+const (
+	testRecipient = "0x00000000000000000000000000000000000000aa"
+	testMiner     = "0x00000000000000000000000000000000000000bb"
+)
+
+// chainSource serves a light client straight from a full chain, letting a
+// test tamper with the headers and proofs it hands out
+type chainSource struct {
+	chain        *core.Blockchain
+	alterHeaders func(headers []*core.Block)
+	alterProof   func(proof *core.MerkleProof)
+	alterResult  func(result *rpc.TransactionResult)
+}
+
+func (s *chainSource) Headers(ctx context.Context, from int64, count int) ([]*core.Block, error) {
+	headers := s.chain.GetHeaders(from, count)
+	if s.alterHeaders != nil {
+		s.alterHeaders(headers)
+	}
+	return headers, nil
+}
+
+func (s *chainSource) TransactionByID(ctx context.Context, id string) (*rpc.TransactionResult, error) {
+	for _, tx := range s.chain.Pending() {
+		if tx.ID == id {
+			return &rpc.TransactionResult{Transaction: tx, Pending: true}, nil
+		}
+	}
+	tx, block := s.chain.GetTransaction(id)
+	if tx == nil {
+		return nil, nil
+	}
+	result := &rpc.TransactionResult{Transaction: *tx, BlockIndex: block.Index, BlockHash: block.Hash}
+	if s.alterResult != nil {
+		s.alterResult(result)
+	}
+	return result, nil
+}
+
+func (s *chainSource) TransactionProof(ctx context.Context, id string) (*core.MerkleProof, error) {
+	proof, err := s.chain.GetTransactionProof(id)
+	if err != nil {
+		return nil, err
+	}
+	if s.alterProof != nil {
+		s.alterProof(proof)
+	}
+	return proof, nil
+}
+
+// testGenesis returns a proof-of-work genesis funding a new key
+func testGenesis(t *testing.T) (*core.Genesis, *secp256k1.PrivateKey) {
+	t.Helper()
+	key, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis := &core.Genesis{
+		Timestamp:            1700000000,
+		Difficulty:           1,
+		MiningReward:         10,
+		RetargetInterval:     10,
+		TargetBlockTime:      15,
+		MaxBlockTransactions: 500,
+		Alloc:                map[string]float64{core.AddressFromPublicKey(key.PubKey()): 100},
+	}
+	return genesis, key
+}
+
+// openChain opens a full chain for a genesis
+func openChain(t *testing.T, genesis *core.Genesis) *core.Blockchain {
+	t.Helper()
+	chain, err := core.OpenBlockchain(nil, genesis.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain
+}
+
+// mine mines count blocks on chain
+func mine(t *testing.T, chain *core.Blockchain, miner string, count int) {
+	t.Helper()
+	for i := 0; i < count; i++ {
+		if _, err := chain.MinePendingTransactions(miner); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// transfer adds a signed transfer to chain's pending pool
+func transfer(t *testing.T, chain *core.Blockchain, key *secp256k1.PrivateKey, nonce uint64) core.Transaction {
+	t.Helper()
+	tx := core.Transaction{To: testRecipient, Amount: 1, Fee: 0.1, Nonce: nonce, Timestamp: 1700000000}
+	if err := core.SignTransaction(&tx, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestVerifyTransaction(t *testing.T) {
+	genesis, key := testGenesis(t)
+	chain := openChain(t, genesis)
+	mined := transfer(t, chain, key, 0)
+	mine(t, chain, testMiner, 3)
+	pending := transfer(t, chain, key, 1)
+
+	source := &chainSource{chain: chain}
+	client := NewClient(genesis, source)
+	ctx := context.Background()
+	if err := client.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if client.Height() != 3 || client.Tip().Hash != chain.LastBlock().Hash {
+		t.Fatalf("client synced to %d, want 3", client.Height())
+	}
+
+	verified, err := client.VerifyTransaction(ctx, mined.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified.BlockIndex != 1 || verified.Confirmations != 3 {
+		t.Fatalf("verified in block %d with %d confirmations, want 1 and 3", verified.BlockIndex, verified.Confirmations)
+	}
+
+	if _, err := client.VerifyTransaction(ctx, pending.ID); !errors.Is(err, ErrPending) {
+		t.Fatalf("pending transaction gave %v", err)
+	}
+	if _, err := client.VerifyTransaction(ctx, "missing"); !errors.Is(err, ErrUnknownTransaction) {
+		t.Fatalf("unknown transaction gave %v", err)
+	}
+
+	// A proof must lead to the root of the verified header it names, from
+	// the transaction asked for
+	other := chain.GetBlock(2).Transactions[0]
+	otherProof, err := chain.GetTransactionProof(other.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, alter := range map[string]func(proof *core.MerkleProof){
+		"other transaction's proof": func(proof *core.MerkleProof) {
+			*proof = *otherProof
+		},
+		"forged root": func(proof *core.MerkleProof) {
+			proof.MerkleRoot = chain.GetBlock(2).MerkleRoot
+		},
+		"unknown block": func(proof *core.MerkleProof) {
+			proof.BlockIndex = 7
+		},
+		"wrong path": func(proof *core.MerkleProof) {
+			proof.Steps = proof.Steps[:0]
+		},
+	} {
+		source.alterProof = alter
+		if _, err := client.VerifyTransaction(ctx, mined.ID); err == nil {
+			t.Errorf("proof with %s accepted", name)
+		}
+	}
+
+	// Nor may the source swap in another mined transaction, with or without
+	// its own ID
+	for name, alter := range map[string]func(result *rpc.TransactionResult){
+		"other transaction": func(result *rpc.TransactionResult) {
+			result.Transaction = other
+		},
+		"other transaction under the ID": func(result *rpc.TransactionResult) {
+			result.Transaction = other
+			result.Transaction.ID = mined.ID
+		},
+	} {
+		source.alterResult = alter
+		source.alterProof = func(proof *core.MerkleProof) { *proof = *otherProof }
+		if _, err := client.VerifyTransaction(ctx, mined.ID); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
+
+func TestSyncRejectsInvalidHeaders(t *testing.T) {
+	genesis, _ := testGenesis(t)
+	chain := openChain(t, genesis)
+	mine(t, chain, testMiner, 3)
+
+	for name, alter := range map[string]func(headers []*core.Block){
+		"wrong hash": func(headers []*core.Block) {
+			for _, header := range headers {
+				header.Nonce++
+			}
+		},
+		"broken link": func(headers []*core.Block) {
+			if len(headers) > 1 {
+				headers[1].PrevHash = headers[0].PrevHash
+			}
+		},
+	} {
+		client := NewClient(genesis, &chainSource{chain: chain, alterHeaders: alter})
+		if err := client.Sync(context.Background()); err == nil {
+			t.Errorf("headers with %s accepted", name)
+		}
+		if client.Height() != 0 {
+			t.Errorf("client with %s headers moved to %d", name, client.Height())
+		}
+	}
+
+	other, _ := testGenesis(t)
+	client := NewClient(other, &chainSource{chain: chain})
+	if err := client.Sync(context.Background()); err == nil {
+		t.Fatal("client synced a chain with another genesis")
+	}
+}
+
+func TestSyncFollowsMostWork(t *testing.T) {
+	genesis, _ := testGenesis(t)
+	short, long := openChain(t, genesis), openChain(t, genesis)
+	mine(t, short, "0x00000000000000000000000000000000000000a1", 2)
+	mine(t, long, "0x00000000000000000000000000000000000000a2", 3)
+
+	source := &chainSource{chain: short}
+	client := NewClient(genesis, source)
+	ctx := context.Background()
+	if err := client.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	work := client.Work()
+
+	// A heavier branch replaces the synced one from the fork point
+	source.chain = long
+	if err := client.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if client.Tip().Hash != long.LastBlock().Hash || client.Work().Cmp(work) <= 0 {
+		t.Fatal("client did not switch to the heavier branch")
+	}
+
+	// A lighter one leaves it in place
+	source.chain = short
+	if err := client.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if client.Tip().Hash != long.LastBlock().Hash {
+		t.Fatal("client switched to a lighter branch")
+	}
+}
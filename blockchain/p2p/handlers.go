@@ -0,0 +1,239 @@
+package p2p
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"0xygen.thesphere.online/blockchain/core"
+)
+
+// peerMessage announces a node's address to a peer
+type peerMessage struct {
+	Address string `json:"address"`
+}
+
+// statusMessage describes the tip of a node's chain
+type statusMessage struct {
+	Height      int64  `json:"height"`
+	TipHash     string `json:"tipHash"`
+	GenesisHash string `json:"genesisHash"`
+	Work        string `json:"work"`
+}
+
+// Blocks and transactions travel in the core binary codec. The gossiping
+// node identifies itself through this header so it can be synced from,
+// provided it is a registered peer.
+const (
+	fromHeader        = "X-Sphere-From"
+	binaryContentType = "application/octet-stream"
+
+	// maxMessageSize bounds how much of a request body is read
+	maxMessageSize = 32 << 20
+)
+
+// routes sets up the peer-facing HTTP endpoints
+func (n *Node) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /p2p/peers", n.handleRegisterPeer)
+	mux.HandleFunc("GET /p2p/peers", n.handleGetPeers)
+	mux.HandleFunc("GET /p2p/status", n.handleStatus)
+	mux.HandleFunc("GET /p2p/blocks", n.handleGetBlocks)
+	mux.HandleFunc("POST /p2p/blocks", n.handleNewBlock)
+	mux.HandleFunc("POST /p2p/transactions", n.handleNewTransaction)
+	return mux
+}
+
+// handleRegisterPeer records the calling node and replies with our other
+// peers. A caller that gives no address is not serving, so it only gets
+// the list.
+func (n *Node) handleRegisterPeer(w http.ResponseWriter, r *http.Request) {
+	var message peerMessage
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxMessageSize)).Decode(&message); err != nil {
+		http.Error(w, "invalid peer message", http.StatusBadRequest)
+		return
+	}
+
+	address := strings.TrimRight(message.Address, "/")
+	if address != "" {
+		if _, err := n.rememberPeer(address); errors.Is(err, errPeerTableFull) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	others := []string{}
+	for _, peer := range n.Peers() {
+		if peer != address {
+			others = append(others, peer)
+		}
+	}
+	writeJSON(w, others)
+}
+
+// handleGetPeers lists the known peers
+func (n *Node) handleGetPeers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, n.Peers())
+}
+
+// handleStatus reports the tip of the local chain
+func (n *Node) handleStatus(w http.ResponseWriter, r *http.Request) {
+	tip := n.chain.LastBlock()
+	status := statusMessage{
+		Height:      tip.Index,
+		TipHash:     tip.Hash,
+		GenesisHash: n.chain.GetBlock(0).Hash,
+		Work:        n.chain.CumulativeWork().String(),
+	}
+
+	writeJSON(w, status)
+}
+
+// handleGetBlocks returns a page of blocks following the first hash of a
+// comma-separated "locator" found on the local chain, or starting at the
+// "from" index. The optional "count" caps the page below maxSyncBlocks.
+func (n *Node) handleGetBlocks(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	count := maxSyncBlocks
+	if raw := query.Get("count"); raw != "" {
+		requested, err := strconv.Atoi(raw)
+		if err != nil || requested <= 0 {
+			http.Error(w, "invalid count", http.StatusBadRequest)
+			return
+		}
+		count = min(requested, maxSyncBlocks)
+	}
+
+	var blocks []*core.Block
+	if locator := query.Get("locator"); locator != "" {
+		var ok bool
+		blocks, ok = n.chain.BlocksAfter(strings.Split(locator, ","), count)
+		if !ok {
+			http.Error(w, "no locator block is on this chain", http.StatusBadRequest)
+			return
+		}
+	} else {
+		from, err := strconv.ParseInt(query.Get("from"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid from index", http.StatusBadRequest)
+			return
+		}
+		blocks = n.chain.GetBlocks(from)
+		if len(blocks) > count {
+			blocks = blocks[:count]
+		}
+	}
+
+	// Keep the page well inside what the requester will read
+	size := 0
+	for i, block := range blocks {
+		size += len(core.EncodeBlock(block))
+		if i > 0 && size > maxMessageSize/2 {
+			blocks = blocks[:i]
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", binaryContentType)
+	w.Write(core.EncodeBlocks(blocks))
+}
+
+// handleNewBlock imports a gossiped block, syncing from the sender when the
+// block is ahead of the local chain
+func (n *Node) handleNewBlock(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMessageSize))
+	if err != nil {
+		http.Error(w, "failed to read block", http.StatusBadRequest)
+		return
+	}
+	block, err := core.DecodeBlock(body)
+	if err != nil {
+		http.Error(w, "invalid block message", http.StatusBadRequest)
+		return
+	}
+
+	from := r.Header.Get(fromHeader)
+	if !n.markBlock(block.Hash) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	err = n.chain.AddBlock(block)
+	height := n.chain.Height()
+
+	switch {
+	case err == nil:
+		n.broadcast("/p2p/blocks", body, from)
+		n.releaseBlockSenders(block)
+	case err == core.ErrKnownBlock:
+	case err == core.ErrUnknownParent:
+		// We are missing blocks, fetch them from whoever sent this one. Only
+		// registered peers are synced from, as the header is the sender's word.
+		if block.Index > height && n.isPeer(from) {
+			go func() {
+				if err := n.syncWith(from); err != nil {
+					n.forgetBlock(block.Hash)
+				}
+			}()
+		} else {
+			n.forgetBlock(block.Hash)
+		}
+	default:
+		// A block that failed may still be delivered again once it fits
+		n.forgetBlock(block.Hash)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleNewTransaction adds a gossiped transaction to the pending pool
+func (n *Node) handleNewTransaction(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMessageSize))
+	if err != nil {
+		http.Error(w, "failed to read transaction", http.StatusBadRequest)
+		return
+	}
+	tx, err := core.DecodeTransaction(body)
+	if err != nil {
+		http.Error(w, "invalid transaction message", http.StatusBadRequest)
+		return
+	}
+
+	if !n.markTx(tx.ID) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	from := r.Header.Get(fromHeader)
+	err = n.chain.AddTransaction(*tx)
+	switch {
+	case err == nil:
+		n.broadcast("/p2p/transactions", body, from)
+		n.releaseHeld(tx.From)
+	case errors.Is(err, core.ErrNonceGap) && n.holdTx(heldTx{tx: *tx, body: body, from: from, at: n.now()}):
+		// Gossip may overtake itself; keep the transaction until the
+		// sender's earlier ones arrive. They may have done so while this
+		// one was checked, so retry straight away.
+		n.releaseHeld(tx.From)
+	default:
+		// Forget a rejected transaction so a later delivery is checked again
+		n.forgetTx(tx.ID)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
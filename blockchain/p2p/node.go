@@ -0,0 +1,575 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"0xygen.thesphere.online/blockchain/core"
+)
+
+// Node connects a blockchain to its peers. Peers are addressed by the base
+// URL of their HTTP endpoint, for example http://127.0.0.1:7000.
+type Node struct {
+	chain *core.Blockchain
+
+	listenAddr string
+	listener   net.Listener
+	server     *http.Server
+	client     *http.Client
+
+	mu         sync.RWMutex
+	self       string
+	peers      map[string]bool
+	seenTxs    *seenSet
+	seenBlocks *seenSet
+	// held keeps gossiped transactions that arrived ahead of an earlier
+	// nonce, by sender, until the missing ones are accepted or they expire
+	held      map[string][]heldTx
+	heldCount int
+	// syncPageSize is how many blocks are asked for per sync request
+	syncPageSize int
+
+	// releaseMu serializes retries of held transactions, so one retry
+	// cannot take a transaction away while another looks for it
+	releaseMu sync.Mutex
+}
+
+// heldTx is a gossiped transaction waiting for its sender's earlier nonces
+type heldTx struct {
+	tx   core.Transaction
+	body []byte
+	from string
+	at   time.Time
+}
+
+// Limits on the state kept about peers and gossip
+const (
+	maxPeers      = 64
+	maxSeenTxs    = 1 << 14
+	maxSeenBlocks = 1 << 12
+	maxHeldTxs    = 1024
+	// maxHeldPerSender matches the mempool's default limit per sender, so
+	// one account cannot take the whole held set
+	maxHeldPerSender = 64
+	// heldTxExpiry is how long a transaction waits for its nonce gap to fill
+	heldTxExpiry = 10 * time.Minute
+
+	// maxSyncBlocks is the most blocks one sync request returns. A page is
+	// also cut short once it passes half of maxMessageSize.
+	maxSyncBlocks = 500
+)
+
+// errPeerTableFull is returned when a new peer would exceed maxPeers
+var errPeerTableFull = errors.New("peer table is full")
+
+// NewNode creates a node for the given chain that will listen on listenAddr
+func NewNode(chain *core.Blockchain, listenAddr string) *Node {
+	return &Node{
+		chain:        chain,
+		listenAddr:   listenAddr,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		peers:        map[string]bool{},
+		seenTxs:      newSeenSet(maxSeenTxs),
+		seenBlocks:   newSeenSet(maxSeenBlocks),
+		held:         map[string][]heldTx{},
+		syncPageSize: maxSyncBlocks,
+	}
+}
+
+// Start begins listening for peer requests. Use "127.0.0.1:0" to pick a free port.
+func (n *Node) Start() error {
+	listener, err := net.Listen("tcp", n.listenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", n.listenAddr, err)
+	}
+
+	n.mu.Lock()
+	n.listener = listener
+	n.self = "http://" + listener.Addr().String()
+	n.mu.Unlock()
+
+	n.server = &http.Server{Handler: n.routes()}
+	go func() {
+		if err := n.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("p2p server stopped: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// Stop shuts the node's server down
+func (n *Node) Stop() error {
+	if n.server == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return n.server.Shutdown(ctx)
+}
+
+// Address returns the URL other nodes use to reach this node
+func (n *Node) Address() string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.self
+}
+
+// Peers returns the addresses of all known peers
+func (n *Node) Peers() []string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	peers := make([]string, 0, len(n.peers))
+	for peer := range n.peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// AddPeer registers this node with a peer and learns the peers it knows about
+func (n *Node) AddPeer(address string) error {
+	address = strings.TrimRight(address, "/")
+	added, err := n.rememberPeer(address)
+	if err != nil {
+		return fmt.Errorf("cannot add peer %s: %w", address, err)
+	}
+	if !added {
+		return nil
+	}
+
+	var known []string
+	if err := n.post(address, "/p2p/peers", peerMessage{Address: n.Address()}, &known); err != nil {
+		n.forgetPeer(address)
+		return fmt.Errorf("failed to register with peer %s: %v", address, err)
+	}
+
+	for _, peer := range known {
+		if err := n.AddPeer(peer); err != nil {
+			log.Printf("failed to add peer %s: %v", peer, err)
+			if errors.Is(err, errPeerTableFull) {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// Connect registers every given peer and then syncs missing blocks from them
+func (n *Node) Connect(peers ...string) error {
+	for _, peer := range peers {
+		if err := n.AddPeer(peer); err != nil {
+			return err
+		}
+	}
+	return n.Sync()
+}
+
+// SubmitTransaction adds a transaction to the local chain and gossips it
+func (n *Node) SubmitTransaction(tx core.Transaction) error {
+	err := n.chain.AddTransaction(tx)
+	if err != nil {
+		return err
+	}
+
+	n.markTx(tx.ID)
+	n.broadcast("/p2p/transactions", core.EncodeTransaction(&tx), "")
+	n.releaseHeld(tx.From)
+	return nil
+}
+
+// StartMining runs a background miner that gossips every block it finds.
+// Cancel ctx to stop it.
+func (n *Node) StartMining(ctx context.Context, minerAddress string) *core.Miner {
+	miner := core.NewMiner(n.chain, minerAddress, n.announceBlock)
+	miner.Start(ctx)
+	return miner
+}
+
+// MineBlock mines the pending transactions and gossips the new block
+func (n *Node) MineBlock(minerAddress string) (*core.Block, error) {
+	block, err := n.chain.MinePendingTransactions(minerAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	n.announceBlock(block)
+	return block, nil
+}
+
+// announceBlock gossips a locally mined block to every peer
+func (n *Node) announceBlock(block *core.Block) {
+	n.markBlock(block.Hash)
+	n.broadcast("/p2p/blocks", core.EncodeBlock(block), "")
+}
+
+// Sync pulls blocks from every peer whose chain carries more work than ours
+func (n *Node) Sync() error {
+	var errs []error
+	for _, peer := range n.Peers() {
+		if err := n.syncWith(peer); err != nil {
+			errs = append(errs, fmt.Errorf("sync with %s: %v", peer, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// syncWith fetches missing blocks from a single peer a page at a time,
+// starting after the last block the two chains share. A chain grown from
+// another genesis shares none, so it is downloaded whole and replaces ours.
+func (n *Node) syncWith(peer string) error {
+	var status statusMessage
+	if err := n.get(peer, "/p2p/status", &status); err != nil {
+		return err
+	}
+
+	work, ok := new(big.Int).SetString(status.Work, 10)
+	if !ok {
+		return fmt.Errorf("peer reported invalid work %q", status.Work)
+	}
+	if work.Cmp(n.chain.CumulativeWork()) <= 0 {
+		return nil
+	}
+
+	if status.GenesisHash != n.chain.GetBlock(0).Hash {
+		chain, err := n.fetchChain(peer, status.Height)
+		if err != nil {
+			return err
+		}
+		return n.chain.ReplaceChain(chain)
+	}
+
+	query := url.Values{"locator": {strings.Join(n.chain.Locator(), ",")}}
+	for {
+		blocks, err := n.getBlocks(peer, query)
+		if err != nil {
+			return err
+		}
+		for _, block := range blocks {
+			if err := n.chain.AddBlock(block); err != nil && err != core.ErrKnownBlock {
+				return err
+			}
+			n.markBlock(block.Hash)
+			n.releaseBlockSenders(block)
+		}
+		if len(blocks) == 0 || blocks[len(blocks)-1].Index >= status.Height {
+			return nil
+		}
+		// The peer knows the last block it sent, so carry on from there
+		query.Set("locator", blocks[len(blocks)-1].Hash)
+	}
+}
+
+// fetchChain downloads a peer's chain up to the height it reported
+func (n *Node) fetchChain(peer string, height int64) ([]*core.Block, error) {
+	chain := []*core.Block{}
+	for int64(len(chain)) <= height {
+		blocks, err := n.getBlocks(peer, url.Values{"from": {strconv.Itoa(len(chain))}})
+		if err != nil {
+			return nil, err
+		}
+		if len(blocks) == 0 {
+			break
+		}
+		chain = append(chain, blocks...)
+	}
+	return chain, nil
+}
+
+// broadcast sends an encoded payload to every peer except one, without
+// waiting for replies
+func (n *Node) broadcast(path string, payload []byte, except string) {
+	for _, peer := range n.Peers() {
+		if peer == except {
+			continue
+		}
+		go func(peer string) {
+			if err := n.postBinary(peer, path, payload); err != nil {
+				log.Printf("failed to gossip to %s: %v", peer, err)
+			}
+		}(peer)
+	}
+}
+
+// isPeer reports whether an address belongs to a registered peer
+func (n *Node) isPeer(address string) bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.peers[address]
+}
+
+// rememberPeer records a peer and reports whether it was new. Only bare
+// http(s) base URLs are taken, and no new ones once the table is full.
+func (n *Node) rememberPeer(address string) (bool, error) {
+	if err := checkPeerAddress(address); err != nil {
+		return false, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if address == n.self || n.peers[address] {
+		return false, nil
+	}
+	if len(n.peers) >= maxPeers {
+		return false, errPeerTableFull
+	}
+	n.peers[address] = true
+	return true, nil
+}
+
+// checkPeerAddress checks that a peer address is the base URL of an HTTP endpoint
+func checkPeerAddress(address string) error {
+	u, err := url.Parse(address)
+	if err != nil {
+		return fmt.Errorf("invalid peer address: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return fmt.Errorf("peer address %q is not an http(s) base URL", address)
+	}
+	return nil
+}
+
+// forgetPeer removes a peer
+func (n *Node) forgetPeer(address string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.peers, address)
+}
+
+// markTx records a transaction ID and reports whether it was unseen
+func (n *Node) markTx(id string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.seenTxs.add(id)
+}
+
+// forgetTx clears a transaction ID so the transaction can be processed again
+func (n *Node) forgetTx(id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.seenTxs.remove(id)
+}
+
+// markBlock records a block hash and reports whether it was unseen
+func (n *Node) markBlock(hash string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.seenBlocks.add(hash)
+}
+
+// forgetBlock clears a block hash so the block can be processed again
+func (n *Node) forgetBlock(hash string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.seenBlocks.remove(hash)
+}
+
+// holdTx keeps a transaction that skipped ahead of its sender's next nonce
+// and reports whether there was room for it. Expired transactions make way
+// first, and a sender cannot hold more than it has or than its share.
+func (n *Node) holdTx(held heldTx) bool {
+	balance := n.chain.GetBalance(held.tx.From)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.expireHeld(held.at)
+	sender := n.held[held.tx.From]
+	if n.heldCount >= maxHeldTxs || len(sender) >= maxHeldPerSender {
+		return false
+	}
+	cost := held.tx.Amount + held.tx.Fee
+	for _, h := range sender {
+		cost += h.tx.Amount + h.tx.Fee
+	}
+	if cost > balance {
+		return false
+	}
+
+	n.held[held.tx.From] = append(sender, held)
+	n.heldCount++
+	return true
+}
+
+// takeHeld removes and returns the unexpired transactions held for a sender
+func (n *Node) takeHeld(sender string) []heldTx {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.expireHeld(n.now())
+	held := n.held[sender]
+	delete(n.held, sender)
+	n.heldCount -= len(held)
+	return held
+}
+
+// expireHeld drops held transactions older than heldTxExpiry and forgets
+// them, so a later delivery is checked afresh. n.mu must be held.
+func (n *Node) expireHeld(now time.Time) {
+	for sender, held := range n.held {
+		kept := held[:0]
+		for _, h := range held {
+			if now.Sub(h.at) > heldTxExpiry {
+				n.seenTxs.remove(h.tx.ID)
+				n.heldCount--
+				continue
+			}
+			kept = append(kept, h)
+		}
+		if len(kept) == 0 {
+			delete(n.held, sender)
+		} else {
+			n.held[sender] = kept
+		}
+	}
+}
+
+// now reads the chain's clock, so held transactions age along with it
+func (n *Node) now() time.Time {
+	if clock := n.chain.Config.Clock; clock != nil {
+		return clock.Now()
+	}
+	return time.Now()
+}
+
+// releaseHeld retries the transactions held for a sender after one of its
+// transactions was accepted. Those that now fit are gossiped on, those
+// still ahead of a gap are held again and the rest are dropped.
+func (n *Node) releaseHeld(sender string) {
+	n.releaseMu.Lock()
+	defer n.releaseMu.Unlock()
+
+	for {
+		held := n.takeHeld(sender)
+		if len(held) == 0 {
+			return
+		}
+		sort.Slice(held, func(i, j int) bool { return held[i].tx.Nonce < held[j].tx.Nonce })
+
+		accepted := false
+		for _, h := range held {
+			err := n.chain.AddTransaction(h.tx)
+			switch {
+			case err == nil:
+				accepted = true
+				n.broadcast("/p2p/transactions", h.body, h.from)
+			case errors.Is(err, core.ErrNonceGap) && n.holdTx(h):
+			default:
+				n.forgetTx(h.tx.ID)
+			}
+		}
+		if !accepted {
+			return
+		}
+	}
+}
+
+// releaseBlockSenders retries the held transactions of every sender in a
+// block, as the block may have filled their gaps
+func (n *Node) releaseBlockSenders(block *core.Block) {
+	released := map[string]bool{}
+	for _, tx := range block.Transactions {
+		if !released[tx.From] {
+			released[tx.From] = true
+			n.releaseHeld(tx.From)
+		}
+	}
+}
+
+// get fetches a JSON document from a peer
+func (n *Node) get(peer, path string, out interface{}) error {
+	resp, err := n.client.Get(peer + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer returned %s", resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxMessageSize)).Decode(out)
+}
+
+// getBlocks fetches one page of encoded blocks, selected by query
+func (n *Node) getBlocks(peer string, query url.Values) ([]*core.Block, error) {
+	query.Set("count", strconv.Itoa(n.syncPageSize))
+	resp, err := n.client.Get(peer + "/p2p/blocks?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("peer returned %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxMessageSize {
+		return nil, fmt.Errorf("peer sent more than %d bytes of blocks", maxMessageSize)
+	}
+	return core.DecodeBlocks(body)
+}
+
+// postBinary sends an encoded payload to a peer, identifying this node as the sender
+func (n *Node) postBinary(peer, path string, payload []byte) error {
+	req, err := http.NewRequest(http.MethodPost, peer+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", binaryContentType)
+	req.Header.Set(fromHeader, n.Address())
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer returned %s", resp.Status)
+	}
+	return nil
+}
+
+// post sends a JSON document to a peer and optionally decodes the reply
+func (n *Node) post(peer, path string, message interface{}, out interface{}) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	resp, err := n.client.Post(peer+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer returned %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,320 @@
+package p2p
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+
+	"0xygen.thesphere.online/blockchain/core"
+)
+
+const testMiner = "0x00000000000000000000000000000000000000bb"
+
+// startNode opens a chain with a fixed genesis funding alloc and serves it
+// on a free local port until the test ends
+func startNode(t *testing.T, alloc map[string]float64) *Node {
+	t.Helper()
+	config := core.DefaultConfig()
+	config.GenesisTimestamp = 1700000000
+	config.Alloc = alloc
+	chain, err := core.OpenBlockchain(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node := NewNode(chain, "127.0.0.1:0")
+	if err := node.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { node.Stop() })
+	return node
+}
+
+// waitFor polls until done reports true or fails the test after a while
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGossipKeepsOutOfOrderTransactions(t *testing.T) {
+	key, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := core.AddressFromPublicKey(key.PubKey())
+	alloc := map[string]float64{sender: 1000}
+	a, b := startNode(t, alloc), startNode(t, alloc)
+	if err := a.Connect(b.Address()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each transaction is gossiped on its own goroutine, so they reach the
+	// peer in any order and most arrive ahead of a nonce gap
+	const count = 30
+	for nonce := uint64(0); nonce < count; nonce++ {
+		tx := core.Transaction{To: testMiner, Amount: 1, Fee: 0.01, Nonce: nonce, Timestamp: 1700000000}
+		if err := core.SignTransaction(&tx, key); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.SubmitTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, "the peer to accept every transaction", func() bool {
+		return len(b.chain.Pending()) == count
+	})
+	b.mu.RLock()
+	held := b.heldCount
+	b.mu.RUnlock()
+	if held != 0 {
+		t.Fatalf("%d transactions still held after all were accepted", held)
+	}
+}
+
+func TestGossipRechecksRejectedTransaction(t *testing.T) {
+	key, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := startNode(t, nil)
+
+	// The sender has no funds, so every delivery must be checked and
+	// rejected rather than the later ones skipped as already seen
+	tx := core.Transaction{To: testMiner, Amount: 1, Timestamp: 1700000000}
+	if err := core.SignTransaction(&tx, key); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if status := postTransaction(t, node, &tx); status != http.StatusBadRequest {
+			t.Fatalf("delivery %d of an unfunded transaction got status %d", i, status)
+		}
+	}
+}
+
+// postTransaction gossips a transaction to a node and returns the status
+func postTransaction(t *testing.T, node *Node, tx *core.Transaction) int {
+	t.Helper()
+	resp, err := http.Post(node.Address()+"/p2p/transactions", binaryContentType, bytes.NewReader(core.EncodeTransaction(tx)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// postBlock gossips a block to a node claiming to come from sender
+func postBlock(t *testing.T, node *Node, block *core.Block, sender string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, node.Address()+"/p2p/blocks", bytes.NewReader(core.EncodeBlock(block)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(fromHeader, sender)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("block gossip got status %d", resp.StatusCode)
+	}
+}
+
+func TestSyncOnlyFromRegisteredPeers(t *testing.T) {
+	miner, node := startNode(t, nil), startNode(t, nil)
+	blocks := make([]*core.Block, 3)
+	for i := range blocks {
+		block, err := miner.chain.MinePendingTransactions(testMiner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks[i] = block
+	}
+
+	// A block ahead of the chain names a sender that is not a peer
+	var requests atomic.Int32
+	stranger := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer stranger.Close()
+	postBlock(t, node, blocks[1], stranger.URL)
+	time.Sleep(100 * time.Millisecond)
+	if requests.Load() != 0 {
+		t.Fatalf("node made %d requests to an unregistered sender", requests.Load())
+	}
+
+	// The next block from a registered peer triggers a sync
+	if err := node.AddPeer(miner.Address()); err != nil {
+		t.Fatal(err)
+	}
+	postBlock(t, node, blocks[2], miner.Address())
+	waitFor(t, "the node to sync from its peer", func() bool {
+		return node.chain.Height() == 3
+	})
+}
+
+func TestSeenSetIsBounded(t *testing.T) {
+	seen := newSeenSet(3)
+	for i := 0; i < 5; i++ {
+		if !seen.add(fmt.Sprint(i)) {
+			t.Fatalf("key %d reported as seen", i)
+		}
+	}
+	if seen.len() != 3 {
+		t.Fatalf("set holds %d keys, limit is 3", seen.len())
+	}
+	if !seen.add("0") {
+		t.Fatal("oldest key was not evicted")
+	}
+	if seen.add("4") {
+		t.Fatal("recent key was evicted")
+	}
+	seen.remove("4")
+	if !seen.add("4") {
+		t.Fatal("removed key still reported as seen")
+	}
+}
+
+func TestHeldTransactionsAreBounded(t *testing.T) {
+	funded, broke := newKey(t), newKey(t)
+	sender := core.AddressFromPublicKey(funded.PubKey())
+	node := startNode(t, map[string]float64{sender: 1000})
+
+	// A sender that could not pay is turned away rather than held
+	gap := signed(t, broke, 1, 1)
+	if status := postTransaction(t, node, &gap); status != http.StatusBadRequest {
+		t.Fatalf("unfunded transaction past a gap got status %d", status)
+	}
+
+	// One sender holds at most its share, and no more than it has
+	start := time.Unix(1700000000, 0)
+	for nonce := uint64(1); nonce <= maxHeldPerSender; nonce++ {
+		if !node.holdTx(heldTx{tx: signed(t, funded, nonce, 1), at: start}) {
+			t.Fatalf("transaction %d was not held", nonce)
+		}
+	}
+	if node.holdTx(heldTx{tx: signed(t, funded, maxHeldPerSender+1, 1), at: start}) {
+		t.Fatal("sender held more than its share")
+	}
+	node.takeHeld(sender)
+	if node.holdTx(heldTx{tx: signed(t, funded, 1, 600), at: start}) && node.holdTx(heldTx{tx: signed(t, funded, 2, 600), at: start}) {
+		t.Fatal("sender held more than it could pay for")
+	}
+
+	// Held transactions expire and are forgotten, so they can come again
+	node.takeHeld(sender)
+	node.markTx("expiring")
+	node.holdTx(heldTx{tx: core.Transaction{ID: "expiring", From: sender}, at: start})
+	node.holdTx(heldTx{tx: signed(t, funded, 3, 1), at: start.Add(heldTxExpiry + time.Second)})
+	node.mu.RLock()
+	held := len(node.held[sender])
+	node.mu.RUnlock()
+	if held != 1 {
+		t.Fatalf("%d transactions held after expiry, want 1", held)
+	}
+	if !node.markTx("expiring") {
+		t.Fatal("expired transaction is still marked as seen")
+	}
+}
+
+func TestPeerTableIsBounded(t *testing.T) {
+	node := startNode(t, nil)
+	register := func(address string) int {
+		t.Helper()
+		body := fmt.Sprintf(`{"address":%q}`, address)
+		resp, err := http.Post(node.Address()+"/p2p/peers", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for _, address := range []string{"ftp://127.0.0.1:1", "http://127.0.0.1:1/path", "not a url"} {
+		if status := register(address); status != http.StatusBadRequest {
+			t.Errorf("peer address %q got status %d", address, status)
+		}
+	}
+	for i := 0; i < maxPeers; i++ {
+		if status := register(fmt.Sprintf("http://127.0.0.1:%d", 10000+i)); status != http.StatusOK {
+			t.Fatalf("peer %d got status %d", i, status)
+		}
+	}
+	if status := register("http://127.0.0.1:9999"); status != http.StatusServiceUnavailable {
+		t.Fatalf("peer past the limit got status %d", status)
+	}
+	if status := register("http://127.0.0.1:10000"); status != http.StatusOK {
+		t.Fatalf("known peer got status %d", status)
+	}
+	if len(node.Peers()) != maxPeers {
+		t.Fatalf("node has %d peers, limit is %d", len(node.Peers()), maxPeers)
+	}
+}
+
+func TestSyncInPages(t *testing.T) {
+	miner, node := startNode(t, nil), startNode(t, nil)
+	for i := 0; i < 7; i++ {
+		if _, err := miner.chain.MinePendingTransactions(testMiner); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The node has a shorter branch of its own, so sync must find the fork
+	for i := 0; i < 2; i++ {
+		if _, err := node.chain.MinePendingTransactions("0x00000000000000000000000000000000000000a1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	node.syncPageSize = 2
+	if err := node.Connect(miner.Address()); err != nil {
+		t.Fatal(err)
+	}
+	if node.chain.LastBlock().Hash != miner.chain.LastBlock().Hash {
+		t.Fatalf("node synced to %d, want %d", node.chain.Height(), miner.chain.Height())
+	}
+
+	// Pages never exceed the count asked for
+	blocks, err := node.getBlocks(miner.Address(), url.Values{"from": {"1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || blocks[0].Index != 1 {
+		t.Fatalf("page held %d blocks, want 2 from index 1", len(blocks))
+	}
+}
+
+// newKey generates a signing key
+func newKey(t *testing.T) *secp256k1.PrivateKey {
+	t.Helper()
+	key, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// signed returns a transfer from key with the given nonce and amount
+func signed(t *testing.T, key *secp256k1.PrivateKey, nonce uint64, amount float64) core.Transaction {
+	t.Helper()
+	tx := core.Transaction{To: testMiner, Amount: amount, Nonce: nonce, Timestamp: 1700000000}
+	if err := core.SignTransaction(&tx, key); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
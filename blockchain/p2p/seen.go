@@ -0,0 +1,48 @@
+package p2p
+
+import "container/list"
+
+// seenSet remembers the most recent keys up to a limit, forgetting the
+// oldest first, so gossip deduplication does not grow without bound
+type seenSet struct {
+	limit   int
+	entries map[string]*list.Element
+	// order lists keys oldest first
+	order *list.List
+}
+
+// newSeenSet creates an empty set holding at most limit keys
+func newSeenSet(limit int) *seenSet {
+	return &seenSet{
+		limit:   limit,
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+// add records a key and reports whether it was new
+func (s *seenSet) add(key string) bool {
+	if _, ok := s.entries[key]; ok {
+		return false
+	}
+	for s.order.Len() >= s.limit {
+		oldest := s.order.Front()
+		delete(s.entries, oldest.Value.(string))
+		s.order.Remove(oldest)
+	}
+	s.entries[key] = s.order.PushBack(key)
+	return true
+}
+
+// remove forgets a key
+func (s *seenSet) remove(key string) {
+	if element, ok := s.entries[key]; ok {
+		delete(s.entries, key)
+		s.order.Remove(element)
+	}
+}
+
+// len returns the number of keys held
+func (s *seenSet) len() int {
+	return s.order.Len()
+}
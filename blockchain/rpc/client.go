@@ -0,0 +1,245 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"0xygen.thesphere.online/blockchain/core"
+)
+
+// Client calls a node's JSON-RPC server
+type Client struct {
+	endpoint string
+	http     *http.Client
+	// stream carries subscriptions, which must not time out
+	stream *http.Client
+	nextID atomic.Int64
+}
+
+// Dial creates a client for the JSON-RPC server at rawurl
+func Dial(rawurl string) (*Client, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RPC URL: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported RPC URL scheme %q", u.Scheme)
+	}
+
+	return &Client{
+		endpoint: u.String(),
+		http:     &http.Client{Timeout: 30 * time.Second},
+		stream:   &http.Client{},
+	}, nil
+}
+
+// Call invokes a method with positional params and decodes its result into
+// result, which may be nil to discard it
+func (c *Client) Call(ctx context.Context, result interface{}, method string, params ...interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to encode params: %v", err)
+	}
+	id, _ := json.Marshal(c.nextID.Add(1))
+
+	body, err := json.Marshal(request{JSONRPC: "2.0", ID: id, Method: method, Params: rawParams})
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s failed: %v", method, err)
+	}
+	defer resp.Body.Close()
+
+	var reply struct {
+		Result json.RawMessage `json:"result"`
+		Error  *Error          `json:"error"`
+	}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&reply); err != nil {
+		return fmt.Errorf("%s returned an invalid response (%s): %v", method, resp.Status, err)
+	}
+	if reply.Error != nil {
+		return reply.Error
+	}
+
+	if result == nil || len(reply.Result) == 0 {
+		return nil
+	}
+	decoder = json.NewDecoder(bytes.NewReader(reply.Result))
+	decoder.UseNumber()
+	return decoder.Decode(result)
+}
+
+// SendTransaction submits a signed transaction and returns its ID
+func (c *Client) SendTransaction(ctx context.Context, tx *core.Transaction) (string, error) {
+	var id string
+	err := c.Call(ctx, &id, "sphere_sendTransaction", tx)
+	return id, err
+}
+
+// BlockByIndex returns the block at index, or nil if there is none
+func (c *Client) BlockByIndex(ctx context.Context, index int64) (*core.Block, error) {
+	var block *core.Block
+	err := c.Call(ctx, &block, "sphere_getBlockByIndex", index)
+	return block, err
+}
+
+// BlockByHash returns the block with the given hash, or nil if there is none
+func (c *Client) BlockByHash(ctx context.Context, hash string) (*core.Block, error) {
+	var block *core.Block
+	err := c.Call(ctx, &block, "sphere_getBlockByHash", hash)
+	return block, err
+}
+
+// Headers returns up to count header-only blocks starting at an index
+func (c *Client) Headers(ctx context.Context, from int64, count int) ([]*core.Block, error) {
+	var headers []*core.Block
+	err := c.Call(ctx, &headers, "sphere_getHeaders", from, count)
+	return headers, err
+}
+
+// TransactionByID returns a mined or pending transaction, or nil if it is unknown
+func (c *Client) TransactionByID(ctx context.Context, id string) (*TransactionResult, error) {
+	var result *TransactionResult
+	err := c.Call(ctx, &result, "sphere_getTransaction", id)
+	return result, err
+}
+
+// AddressTransactions returns a page of the mined transactions sent to or
+// from an address, newest first
+func (c *Client) AddressTransactions(ctx context.Context, address string, offset, limit int) (*core.TransactionPage, error) {
+	var page *core.TransactionPage
+	err := c.Call(ctx, &page, "sphere_getAddressTransactions", address, offset, limit)
+	return page, err
+}
+
+// BlockHeight returns the height of a canonical block, or -1 if it is unknown
+func (c *Client) BlockHeight(ctx context.Context, hash string) (int64, error) {
+	var height *int64
+	if err := c.Call(ctx, &height, "sphere_getBlockHeight", hash); err != nil {
+		return 0, err
+	}
+	if height == nil {
+		return -1, nil
+	}
+	return *height, nil
+}
+
+// TransactionProof returns the Merkle inclusion proof of a mined transaction
+func (c *Client) TransactionProof(ctx context.Context, id string) (*core.MerkleProof, error) {
+	var proof *core.MerkleProof
+	err := c.Call(ctx, &proof, "sphere_getTransactionProof", id)
+	return proof, err
+}
+
+// BalanceAt returns the confirmed balance of an address
+func (c *Client) BalanceAt(ctx context.Context, address string) (float64, error) {
+	var balance float64
+	err := c.Call(ctx, &balance, "sphere_getBalance", address)
+	return balance, err
+}
+
+// ImmatureBalanceAt returns the part of an address's balance that is mining
+// rewards not yet spendable
+func (c *Client) ImmatureBalanceAt(ctx context.Context, address string) (float64, error) {
+	var balance float64
+	err := c.Call(ctx, &balance, "sphere_getImmatureBalance", address)
+	return balance, err
+}
+
+// NonceAt returns the number of confirmed transactions sent by an address
+func (c *Client) NonceAt(ctx context.Context, address string) (uint64, error) {
+	var nonce uint64
+	err := c.Call(ctx, &nonce, "sphere_getNonce", address)
+	return nonce, err
+}
+
+// TokenByID returns the current state of a token, or nil if it does not exist
+func (c *Client) TokenByID(ctx context.Context, id string) (*core.Token, error) {
+	var token *core.Token
+	err := c.Call(ctx, &token, "sphere_getToken", id)
+	return token, err
+}
+
+// TokensOf lists the tokens owned by an address
+func (c *Client) TokensOf(ctx context.Context, owner string) ([]core.Token, error) {
+	var tokens []core.Token
+	err := c.Call(ctx, &tokens, "sphere_getTokensByOwner", owner)
+	return tokens, err
+}
+
+// Listings lists every token currently for sale
+func (c *Client) Listings(ctx context.Context) ([]core.Token, error) {
+	var tokens []core.Token
+	err := c.Call(ctx, &tokens, "sphere_getListings")
+	return tokens, err
+}
+
+// PendingTransactions lists the node's mempool in mining priority order
+func (c *Client) PendingTransactions(ctx context.Context) ([]core.Transaction, error) {
+	var txs []core.Transaction
+	err := c.Call(ctx, &txs, "sphere_pendingTransactions")
+	return txs, err
+}
+
+// ChainInfo returns the height, tip and difficulty of the node's chain
+func (c *Client) ChainInfo(ctx context.Context) (*ChainInfo, error) {
+	var info ChainInfo
+	if err := c.Call(ctx, &info, "sphere_chainInfo"); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Mine asks the node to mine one block of pending transactions
+func (c *Client) Mine(ctx context.Context, minerAddress string) (*core.Block, error) {
+	var block *core.Block
+	err := c.Call(ctx, &block, "sphere_mine", minerAddress)
+	return block, err
+}
+
+// StartMining starts the node's background miner
+func (c *Client) StartMining(ctx context.Context, minerAddress string) (*core.MinerStatus, error) {
+	var status core.MinerStatus
+	if err := c.Call(ctx, &status, "sphere_startMining", minerAddress); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// StopMining stops the node's background miner
+func (c *Client) StopMining(ctx context.Context) (*core.MinerStatus, error) {
+	var status core.MinerStatus
+	if err := c.Call(ctx, &status, "sphere_stopMining"); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// MiningStatus reports what the node's background miner is doing
+func (c *Client) MiningStatus(ctx context.Context) (*core.MinerStatus, error) {
+	var status core.MinerStatus
+	if err := c.Call(ctx, &status, "sphere_miningStatus"); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
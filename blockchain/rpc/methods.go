@@ -0,0 +1,359 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"0xygen.thesphere.online/blockchain/core"
+	"0xygen.thesphere.online/blockchain/wallet"
+)
+
+// ChainInfo summarises the tip of a node's chain
+type ChainInfo struct {
+	ChainID        uint64  `json:"chainId"`
+	Height         int64   `json:"height"`
+	TipHash        string  `json:"tipHash"`
+	GenesisHash    string  `json:"genesisHash"`
+	NextDifficulty uint64  `json:"nextDifficulty"`
+	Work           string  `json:"work"`
+	Supply         float64 `json:"supply"`
+	Pending        int     `json:"pending"`
+}
+
+// TransactionResult is a transaction along with where it was found
+type TransactionResult struct {
+	Transaction core.Transaction `json:"transaction"`
+	Pending     bool             `json:"pending"`
+	BlockIndex  int64            `json:"blockIndex,omitempty"`
+	BlockHash   string           `json:"blockHash,omitempty"`
+}
+
+// method handles one JSON-RPC method with its raw positional params
+type method func(s *Server, params json.RawMessage) (interface{}, error)
+
+// methods maps every supported JSON-RPC method to its handler
+var methods = map[string]method{
+	"sphere_sendTransaction":        (*Server).sendTransaction,
+	"sphere_getBlockByIndex":        (*Server).getBlockByIndex,
+	"sphere_getBlockByHash":         (*Server).getBlockByHash,
+	"sphere_getHeaders":             (*Server).getHeaders,
+	"sphere_getTransaction":         (*Server).getTransaction,
+	"sphere_getTransactionProof":    (*Server).getTransactionProof,
+	"sphere_getAddressTransactions": (*Server).getAddressTransactions,
+	"sphere_getBlockHeight":         (*Server).getBlockHeight,
+	"sphere_getBalance":             (*Server).getBalance,
+	"sphere_getImmatureBalance":     (*Server).getImmatureBalance,
+	"sphere_getNonce":               (*Server).getNonce,
+	"sphere_getToken":               (*Server).getToken,
+	"sphere_getTokensByOwner":       (*Server).getTokensByOwner,
+	"sphere_getListings":            (*Server).getListings,
+	"sphere_pendingTransactions":    (*Server).pendingTransactions,
+	"sphere_chainInfo":              (*Server).chainInfo,
+	"sphere_mine":                   (*Server).mine,
+	"sphere_startMining":            (*Server).startMining,
+	"sphere_stopMining":             (*Server).stopMining,
+	"sphere_miningStatus":           (*Server).miningStatus,
+}
+
+// sendTransaction adds a signed transaction to the pending pool and returns its ID
+func (s *Server) sendTransaction(params json.RawMessage) (interface{}, error) {
+	var tx core.Transaction
+	if err := decodeParams(params, 1, &tx); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if s.node != nil {
+		err = s.node.SubmitTransaction(tx)
+	} else {
+		err = s.chain.AddTransaction(tx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tx.ID, nil
+}
+
+// getBlockByIndex returns the block at an index, or null
+func (s *Server) getBlockByIndex(params json.RawMessage) (interface{}, error) {
+	var index int64
+	if err := decodeParams(params, 1, &index); err != nil {
+		return nil, err
+	}
+
+	if block := s.chain.GetBlock(index); block != nil {
+		return block, nil
+	}
+	return nil, nil
+}
+
+// getBlockByHash returns the block with a hash, or null
+func (s *Server) getBlockByHash(params json.RawMessage) (interface{}, error) {
+	var hash string
+	if err := decodeParams(params, 1, &hash); err != nil {
+		return nil, err
+	}
+
+	if block := s.chain.GetBlockByHash(hash); block != nil {
+		return block, nil
+	}
+	return nil, nil
+}
+
+// getHeaders returns header-only blocks from an index, for light clients.
+// The count param is optional.
+func (s *Server) getHeaders(params json.RawMessage) (interface{}, error) {
+	var from int64
+	var count int
+	if err := decodeParams(params, 1, &from, &count); err != nil {
+		return nil, err
+	}
+	return s.chain.GetHeaders(from, count), nil
+}
+
+// getTransaction returns a mined or pending transaction by ID, or null
+func (s *Server) getTransaction(params json.RawMessage) (interface{}, error) {
+	var id string
+	if err := decodeParams(params, 1, &id); err != nil {
+		return nil, err
+	}
+
+	tx, block := s.chain.GetTransaction(id)
+	if tx == nil {
+		return nil, nil
+	}
+
+	result := &TransactionResult{Transaction: *tx, Pending: block == nil}
+	if block != nil {
+		result.BlockIndex = block.Index
+		result.BlockHash = block.Hash
+	}
+	return result, nil
+}
+
+// getTransactionProof returns the Merkle inclusion proof of a mined transaction
+func (s *Server) getTransactionProof(params json.RawMessage) (interface{}, error) {
+	var id string
+	if err := decodeParams(params, 1, &id); err != nil {
+		return nil, err
+	}
+	return s.chain.GetTransactionProof(id)
+}
+
+// getBalance returns the confirmed balance of an address
+func (s *Server) getBalance(params json.RawMessage) (interface{}, error) {
+	var address string
+	if err := decodeParams(params, 1, &address); err != nil {
+		return nil, err
+	}
+	address, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return s.chain.GetBalance(address), nil
+}
+
+// getImmatureBalance returns the part of an address's balance that is
+// mining rewards not yet spendable
+func (s *Server) getImmatureBalance(params json.RawMessage) (interface{}, error) {
+	var address string
+	if err := decodeParams(params, 1, &address); err != nil {
+		return nil, err
+	}
+	address, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return s.chain.GetImmatureBalance(address), nil
+}
+
+// getNonce returns the number of confirmed transactions sent by an address
+func (s *Server) getNonce(params json.RawMessage) (interface{}, error) {
+	var address string
+	if err := decodeParams(params, 1, &address); err != nil {
+		return nil, err
+	}
+	address, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return s.chain.GetNonce(address), nil
+}
+
+// getToken returns the current state of a token, or null
+func (s *Server) getToken(params json.RawMessage) (interface{}, error) {
+	var id string
+	if err := decodeParams(params, 1, &id); err != nil {
+		return nil, err
+	}
+
+	if token, ok := s.chain.GetToken(id); ok {
+		return token, nil
+	}
+	return nil, nil
+}
+
+// getAddressTransactions lists a page of the mined transactions sent to or
+// from an address, newest first. The offset and limit params are optional.
+func (s *Server) getAddressTransactions(params json.RawMessage) (interface{}, error) {
+	var address string
+	var offset, limit int
+	if err := decodeParams(params, 1, &address, &offset, &limit); err != nil {
+		return nil, err
+	}
+	address, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return s.chain.AddressTransactions(address, offset, limit), nil
+}
+
+// getBlockHeight returns the height of a canonical block by hash, or null
+func (s *Server) getBlockHeight(params json.RawMessage) (interface{}, error) {
+	var hash string
+	if err := decodeParams(params, 1, &hash); err != nil {
+		return nil, err
+	}
+
+	if height, ok := s.chain.BlockHeight(hash); ok {
+		return height, nil
+	}
+	return nil, nil
+}
+
+// getTokensByOwner lists the tokens owned by an address
+func (s *Server) getTokensByOwner(params json.RawMessage) (interface{}, error) {
+	var owner string
+	if err := decodeParams(params, 1, &owner); err != nil {
+		return nil, err
+	}
+	owner, err := parseAddress(owner)
+	if err != nil {
+		return nil, err
+	}
+	return s.chain.TokensOf(owner), nil
+}
+
+// getListings lists every token currently for sale
+func (s *Server) getListings(params json.RawMessage) (interface{}, error) {
+	if err := decodeParams(params, 0); err != nil {
+		return nil, err
+	}
+	return s.chain.Listings(), nil
+}
+
+// pendingTransactions lists the mempool in mining priority order
+func (s *Server) pendingTransactions(params json.RawMessage) (interface{}, error) {
+	if err := decodeParams(params, 0); err != nil {
+		return nil, err
+	}
+	return s.chain.Pending(), nil
+}
+
+// chainInfo reports the height, tip and difficulty of the chain
+func (s *Server) chainInfo(params json.RawMessage) (interface{}, error) {
+	if err := decodeParams(params, 0); err != nil {
+		return nil, err
+	}
+
+	tip := s.chain.LastBlock()
+	return &ChainInfo{
+		ChainID:        s.chain.Config.ChainID,
+		Height:         tip.Index,
+		TipHash:        tip.Hash,
+		GenesisHash:    s.chain.GetBlock(0).Hash,
+		NextDifficulty: s.chain.NextDifficulty(),
+		Work:           s.chain.CumulativeWork().String(),
+		Supply:         s.chain.Supply(),
+		Pending:        len(s.chain.Pending()),
+	}, nil
+}
+
+// mine mines one block of pending transactions and returns it
+func (s *Server) mine(params json.RawMessage) (interface{}, error) {
+	var address string
+	if err := decodeParams(params, 1, &address); err != nil {
+		return nil, err
+	}
+	address, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.node != nil {
+		return s.node.MineBlock(address)
+	}
+	return s.chain.MinePendingTransactions(address)
+}
+
+// startMining launches a background miner paying rewards to an address
+func (s *Server) startMining(params json.RawMessage) (interface{}, error) {
+	var address string
+	if err := decodeParams(params, 1, &address); err != nil {
+		return nil, err
+	}
+	address, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.miner != nil && s.miner.Status().Running {
+		return nil, fmt.Errorf("already mining to %s", s.miner.Status().Address)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if s.node != nil {
+		s.miner = s.node.StartMining(ctx, address)
+	} else {
+		s.miner = core.NewMiner(s.chain, address, nil)
+		s.miner.Start(ctx)
+	}
+	s.cancelMining = cancel
+
+	return s.miner.Status(), nil
+}
+
+// stopMining stops the background miner and returns its final status
+func (s *Server) stopMining(params json.RawMessage) (interface{}, error) {
+	if err := decodeParams(params, 0); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.miner == nil {
+		return core.MinerStatus{}, nil
+	}
+	s.cancelMining()
+	s.miner.Wait()
+	return s.miner.Status(), nil
+}
+
+// miningStatus reports what the background miner is doing
+func (s *Server) miningStatus(params json.RawMessage) (interface{}, error) {
+	if err := decodeParams(params, 0); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.miner == nil {
+		return core.MinerStatus{}, nil
+	}
+	return s.miner.Status(), nil
+}
+
+// parseAddress checks an address param and returns its on-chain form
+func parseAddress(address string) (string, error) {
+	parsed, err := wallet.ParseAddress(address)
+	if err != nil {
+		return "", &Error{Code: CodeInvalidParams, Message: err.Error()}
+	}
+	return parsed, nil
+}
@@ -0,0 +1,179 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"0xygen.thesphere.online/blockchain/core"
+	"0xygen.thesphere.online/blockchain/p2p"
+)
+
+// Standard JSON-RPC 2.0 error codes, plus one for failures inside a method
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeServerError    = -32000
+)
+
+// maxRequestSize bounds how much of a request body is read
+const maxRequestSize = 32 << 20
+
+// Error is a JSON-RPC error object
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// request is a JSON-RPC 2.0 call
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+// response is a JSON-RPC 2.0 reply
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Server answers JSON-RPC 2.0 calls against a chain. When a p2p node is
+// given, submitted transactions and mined blocks are gossiped through it.
+type Server struct {
+	chain *core.Blockchain
+	node  *p2p.Node
+
+	mu           sync.Mutex
+	miner        *core.Miner
+	cancelMining context.CancelFunc
+
+	// closed ends every subscription stream when the server closes
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewServer creates an RPC server for chain. node may be nil for a chain
+// that is not connected to any peers.
+func NewServer(chain *core.Blockchain, node *p2p.Node) *Server {
+	return &Server{chain: chain, node: node, closed: make(chan struct{})}
+}
+
+// Close ends subscription streams and stops the background miner, if one
+// is running
+func (s *Server) Close() {
+	s.closeOnce.Do(func() { close(s.closed) })
+
+	s.mu.Lock()
+	miner, cancel := s.miner, s.cancelMining
+	s.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		miner.Wait()
+	}
+}
+
+// ServeHTTP handles a JSON-RPC request posted to any path
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "JSON-RPC requests must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		writeResponse(w, errorResponse(nil, CodeParseError, "failed to read request"))
+		return
+	}
+
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeResponse(w, errorResponse(nil, CodeParseError, "invalid JSON"))
+		return
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		writeResponse(w, errorResponse(req.ID, CodeInvalidRequest, "invalid JSON-RPC 2.0 request"))
+		return
+	}
+
+	if req.Method == subscribeMethod {
+		s.serveSubscription(w, r, req)
+		return
+	}
+
+	handler, ok := methods[req.Method]
+	if !ok {
+		writeResponse(w, errorResponse(req.ID, CodeMethodNotFound, fmt.Sprintf("method %s not found", req.Method)))
+		return
+	}
+
+	result, err := handler(s, req.Params)
+	if err != nil {
+		rpcErr, ok := err.(*Error)
+		if !ok {
+			rpcErr = &Error{Code: CodeServerError, Message: err.Error()}
+		}
+		writeResponse(w, response{JSONRPC: "2.0", ID: req.ID, Error: rpcErr})
+		return
+	}
+
+	// A null result must still be sent, so encode it explicitly
+	if result == nil {
+		result = json.RawMessage("null")
+	}
+	writeResponse(w, response{JSONRPC: "2.0", ID: req.ID, Result: result})
+}
+
+// decodeParams unpacks positional parameters into args. The first required
+// parameters must be present; any after that are optional.
+func decodeParams(raw json.RawMessage, required int, args ...interface{}) error {
+	var params []json.RawMessage
+	if len(raw) > 0 && !bytes.Equal(raw, []byte("null")) {
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return &Error{Code: CodeInvalidParams, Message: "params must be an array"}
+		}
+	}
+	if len(params) < required || len(params) > len(args) {
+		return &Error{Code: CodeInvalidParams, Message: fmt.Sprintf("expected %d params, got %d", required, len(params))}
+	}
+
+	for i, param := range params {
+		// Numbers in transaction data keep their exact form so signatures still verify
+		decoder := json.NewDecoder(bytes.NewReader(param))
+		decoder.UseNumber()
+		if err := decoder.Decode(args[i]); err != nil {
+			return &Error{Code: CodeInvalidParams, Message: fmt.Sprintf("invalid param %d: %v", i, err)}
+		}
+	}
+	return nil
+}
+
+// errorResponse builds a reply carrying only an error
+func errorResponse(id json.RawMessage, code int, message string) response {
+	return response{JSONRPC: "2.0", ID: id, Error: &Error{Code: code, Message: message}}
+}
+
+// writeResponse writes a JSON-RPC reply
+func writeResponse(w http.ResponseWriter, resp response) {
+	if resp.ID == nil {
+		resp.ID = json.RawMessage("null")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,399 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+
+	"0xygen.thesphere.online/blockchain/core"
+)
+
+const (
+	testRecipient = "0x00000000000000000000000000000000000000aa"
+	testMiner     = "0x00000000000000000000000000000000000000bb"
+)
+
+// testServer serves a fresh chain funding a new key and returns a client
+// dialled to it
+func testServer(t *testing.T) (*Client, *core.Blockchain, *secp256k1.PrivateKey) {
+	t.Helper()
+	key, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := core.DefaultConfig()
+	config.GenesisTimestamp = 1700000000
+	config.CoinbaseMaturity = 5
+	config.Alloc = map[string]float64{core.AddressFromPublicKey(key.PubKey()): 100}
+	chain, err := core.OpenBlockchain(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServer(chain, nil)
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(func() {
+		server.Close()
+		httpServer.Close()
+	})
+	client, err := Dial(httpServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, chain, key
+}
+
+// signed signs tx with key
+func signed(t *testing.T, tx core.Transaction, key *secp256k1.PrivateKey) *core.Transaction {
+	t.Helper()
+	if err := core.SignTransaction(&tx, key); err != nil {
+		t.Fatal(err)
+	}
+	return &tx
+}
+
+func TestTransactionMethods(t *testing.T) {
+	client, chain, key := testServer(t)
+	ctx := context.Background()
+	sender := core.AddressFromPublicKey(key.PubKey())
+
+	tx := signed(t, core.Transaction{To: testRecipient, Amount: 5, Fee: 0.1, Timestamp: 1700000000}, key)
+	id, err := client.SendTransaction(ctx, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != tx.ID {
+		t.Fatalf("sent transaction has ID %s, want %s", id, tx.ID)
+	}
+	if pending, err := client.PendingTransactions(ctx); err != nil || len(pending) != 1 || pending[0].ID != id {
+		t.Fatalf("pending transactions %v: %v", pending, err)
+	}
+	if result, err := client.TransactionByID(ctx, id); err != nil || result == nil || !result.Pending {
+		t.Fatalf("pending transaction looked up as %+v: %v", result, err)
+	}
+
+	block, err := client.Mine(ctx, testMiner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Index != 1 || block.Hash != chain.LastBlock().Hash {
+		t.Fatalf("mined block %d %s is not the tip", block.Index, block.Hash)
+	}
+
+	if got, err := client.BlockByIndex(ctx, 1); err != nil || got == nil || got.Hash != block.Hash {
+		t.Fatalf("block by index %v: %v", got, err)
+	}
+	if got, err := client.BlockByHash(ctx, block.Hash); err != nil || got == nil || got.Index != 1 {
+		t.Fatalf("block by hash %v: %v", got, err)
+	}
+	if got, err := client.BlockByIndex(ctx, 9); err != nil || got != nil {
+		t.Fatalf("missing block gave %v: %v", got, err)
+	}
+	if height, err := client.BlockHeight(ctx, block.Hash); err != nil || height != 1 {
+		t.Fatalf("block height %d: %v", height, err)
+	}
+	if height, err := client.BlockHeight(ctx, "missing"); err != nil || height != -1 {
+		t.Fatalf("missing block height %d: %v", height, err)
+	}
+	headers, err := client.Headers(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 2 || headers[1].Hash != block.Hash || len(headers[1].Transactions) != 0 {
+		t.Fatalf("got %d headers, want 2 without transactions", len(headers))
+	}
+
+	result, err := client.TransactionByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result == nil || result.Pending || result.BlockIndex != 1 || result.BlockHash != block.Hash {
+		t.Fatalf("mined transaction looked up as %+v", result)
+	}
+	proof, err := client.TransactionProof(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proof.Verify(tx) {
+		t.Fatal("transaction proof does not verify")
+	}
+	page, err := client.AddressTransactions(ctx, testRecipient, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || page.Transactions[0].Transaction.ID != id {
+		t.Fatalf("recipient has %d transactions, want the transfer", page.Total)
+	}
+
+	if balance, err := client.BalanceAt(ctx, testRecipient); err != nil || balance != 5 {
+		t.Fatalf("recipient balance %v: %v", balance, err)
+	}
+	if nonce, err := client.NonceAt(ctx, sender); err != nil || nonce != 1 {
+		t.Fatalf("sender nonce %d: %v", nonce, err)
+	}
+	// The reward of the block just mined is not spendable yet
+	immature, err := client.ImmatureBalanceAt(ctx, testMiner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance, _ := client.BalanceAt(ctx, testMiner); immature <= 0 || immature != balance {
+		t.Fatalf("miner has %v immature of a %v balance", immature, balance)
+	}
+
+	info, err := client.ChainInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Height != 1 || info.TipHash != block.Hash || info.GenesisHash != chain.GetBlock(0).Hash || info.Pending != 0 {
+		t.Fatalf("chain info %+v does not match the chain", info)
+	}
+}
+
+func TestTokenMethods(t *testing.T) {
+	client, _, key := testServer(t)
+	ctx := context.Background()
+	owner := core.AddressFromPublicKey(key.PubKey())
+
+	mint := core.NewMintTransaction(owner, "token-1", "ipfs://token-1")
+	if _, err := client.SendTransaction(ctx, signed(t, mint, key)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Mine(ctx, testMiner); err != nil {
+		t.Fatal(err)
+	}
+	list := core.NewListTransaction("token-1", 2)
+	list.Nonce = 1
+	if _, err := client.SendTransaction(ctx, signed(t, list, key)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Mine(ctx, testMiner); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := client.TokenByID(ctx, "token-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == nil || token.Owner != owner || !token.Listed || token.Price != 2 {
+		t.Fatalf("token %+v is not listed by its owner", token)
+	}
+	if token, err := client.TokenByID(ctx, "missing"); err != nil || token != nil {
+		t.Fatalf("missing token gave %+v: %v", token, err)
+	}
+	if tokens, err := client.TokensOf(ctx, owner); err != nil || len(tokens) != 1 {
+		t.Fatalf("owner has tokens %v: %v", tokens, err)
+	}
+	if listings, err := client.Listings(ctx); err != nil || len(listings) != 1 || listings[0].ID != "token-1" {
+		t.Fatalf("listings %v: %v", listings, err)
+	}
+}
+
+func TestMiningMethods(t *testing.T) {
+	client, _, _ := testServer(t)
+	ctx := context.Background()
+
+	if status, err := client.MiningStatus(ctx); err != nil || status.Running {
+		t.Fatalf("idle miner status %+v: %v", status, err)
+	}
+	status, err := client.StartMining(ctx, testMiner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Running || status.Address != testMiner {
+		t.Fatalf("started miner status %+v", status)
+	}
+	if _, err := client.StartMining(ctx, testMiner); err == nil {
+		t.Fatal("second miner started")
+	}
+	if status, err := client.MiningStatus(ctx); err != nil || !status.Running {
+		t.Fatalf("running miner status %+v: %v", status, err)
+	}
+	if status, err := client.StopMining(ctx); err != nil || status.Running {
+		t.Fatalf("stopped miner status %+v: %v", status, err)
+	}
+}
+
+func TestMethodErrors(t *testing.T) {
+	client, _, _ := testServer(t)
+	ctx := context.Background()
+
+	for _, call := range []struct {
+		method string
+		params []interface{}
+		code   int
+	}{
+		{"sphere_unknown", nil, CodeMethodNotFound},
+		{"sphere_getBlockByIndex", nil, CodeInvalidParams},
+		{"sphere_getBlockByIndex", []interface{}{"one"}, CodeInvalidParams},
+		{"sphere_chainInfo", []interface{}{1}, CodeInvalidParams},
+		{"sphere_getBalance", []interface{}{"0xABC"}, CodeInvalidParams},
+		{"sphere_getImmatureBalance", []interface{}{"not an address"}, CodeInvalidParams},
+		{"sphere_sendTransaction", []interface{}{core.Transaction{To: testRecipient, Amount: 1}}, CodeServerError},
+		{"sphere_getTransactionProof", []interface{}{"missing"}, CodeServerError},
+	} {
+		err := client.Call(ctx, nil, call.method, call.params...)
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) || rpcErr.Code != call.code {
+			t.Errorf("%s%v gave %v, want code %d", call.method, call.params, err, call.code)
+		}
+	}
+
+	// Only POSTed requests are answered
+	server := httptest.NewServer(NewServer(nil, nil))
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("GET gave %s", resp.Status)
+	}
+}
+
+// streamServer serves chain through wrap and returns a client dialled to it
+// and a channel that receives each time a request has been handled
+func streamServer(t *testing.T, chain *core.Blockchain, wrap func(w http.ResponseWriter) http.ResponseWriter) (*Client, <-chan struct{}) {
+	t.Helper()
+	server := NewServer(chain, nil)
+	handled := make(chan struct{}, 16)
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.ServeHTTP(wrap(w), r)
+		handled <- struct{}{}
+	}))
+	t.Cleanup(func() {
+		server.Close()
+		httpServer.Close()
+	})
+	client, err := Dial(httpServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, handled
+}
+
+// stalledWriter lets its first write through and holds the rest until
+// release is closed, like a client that stops reading
+type stalledWriter struct {
+	http.ResponseWriter
+	release <-chan struct{}
+	once    sync.Once
+}
+
+func (w *stalledWriter) Write(p []byte) (int, error) {
+	first := false
+	w.once.Do(func() { first = true })
+	if !first {
+		<-w.release
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *stalledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func TestSubscriptionDropsSlowSubscriber(t *testing.T) {
+	config := core.DefaultConfig()
+	config.GenesisTimestamp = 1700000000
+	config.RetargetInterval = 0
+	chain, err := core.OpenBlockchain(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	client, handled := streamServer(t, chain, func(w http.ResponseWriter) http.ResponseWriter {
+		return &stalledWriter{ResponseWriter: w, release: release}
+	})
+
+	sub, err := client.SubscribeBlocks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	// With the stream stalled, the blocks pile up until the node gives up
+	for i := 0; i < 1100; i++ {
+		if _, err := chain.MinePendingTransactions(testMiner); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(release)
+
+	received := 0
+	timeout := time.After(5 * time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-sub.C:
+			if open {
+				received++
+			}
+		case <-timeout:
+			t.Fatal("stream was not closed")
+		}
+	}
+	if received >= 1100 {
+		t.Fatalf("slow subscriber received all %d blocks", received)
+	}
+	var rpcErr *Error
+	if err := sub.Err(); !errors.As(err, &rpcErr) || rpcErr.Code != CodeServerError ||
+		!strings.Contains(rpcErr.Message, core.ErrSubscriberTooSlow.Error()) {
+		t.Fatalf("stream ended with %v, want the slow subscriber error", err)
+	}
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server still serving the dropped stream")
+	}
+}
+
+func TestSubscriptionUnsubscribe(t *testing.T) {
+	_, chain, _ := testServer(t)
+	client, handled := streamServer(t, chain, func(w http.ResponseWriter) http.ResponseWriter { return w })
+
+	sub, err := client.SubscribeBlocks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := chain.MinePendingTransactions(testMiner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-sub.C:
+		if got.Hash != block.Hash {
+			t.Fatalf("received block %s, want %s", got.Hash, block.Hash)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no block notification")
+	}
+
+	// Unsubscribing closes the stream without an error and ends it on the node
+	sub.Unsubscribe()
+	if _, open := <-sub.C; open {
+		t.Fatal("notifications still delivered after unsubscribing")
+	}
+	if err := sub.Err(); err != nil {
+		t.Fatalf("unsubscribed stream reports %v", err)
+	}
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server still serving the closed stream")
+	}
+	if _, err := chain.MinePendingTransactions(testMiner); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unknown kinds are refused before any stream starts
+	if _, err := subscribe[core.Block](context.Background(), client, "unknown"); err == nil {
+		t.Fatal("unknown subscription accepted")
+	}
+}
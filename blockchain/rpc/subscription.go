@@ -0,0 +1,268 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"0xygen.thesphere.online/blockchain/core"
+)
+
+// Subscription kinds accepted by sphere_subscribe
+const (
+	SubscribeNewBlocks              = "newBlocks"
+	SubscribeNewPendingTransactions = "newPendingTransactions"
+	SubscribeReorgs                 = "reorgs"
+)
+
+// subscribeMethod streams chain events instead of returning one result
+const subscribeMethod = "sphere_subscribe"
+
+// notificationMethod names the notifications a subscription stream carries
+const notificationMethod = "sphere_subscription"
+
+// streamWriteTimeout is how long a stream may block on a slow client
+// before it is dropped
+const streamWriteTimeout = 30 * time.Second
+
+// notification is a JSON-RPC 2.0 notification carrying one event
+type notification struct {
+	JSONRPC string             `json:"jsonrpc"`
+	Method  string             `json:"method"`
+	Params  notificationParams `json:"params"`
+}
+
+// notificationParams names the subscription an event belongs to. The last
+// notification of a stream that fails carries an error instead.
+type notificationParams struct {
+	Subscription string          `json:"subscription"`
+	Result       json.RawMessage `json:"result,omitempty"`
+	Error        *Error          `json:"error,omitempty"`
+}
+
+// serveSubscription answers sphere_subscribe. After the usual response the
+// connection stays open and each event is written as a notification on its
+// own line. A client too slow to keep up is dropped with a final error
+// notification, so it never holds back the chain.
+func (s *Server) serveSubscription(w http.ResponseWriter, r *http.Request, req request) {
+	var kind string
+	if err := decodeParams(req.Params, 1, &kind); err != nil {
+		writeResponse(w, response{JSONRPC: "2.0", ID: req.ID, Error: err.(*Error)})
+		return
+	}
+
+	var events <-chan json.RawMessage
+	var stop func() error
+	switch kind {
+	case SubscribeNewBlocks:
+		events, stop = forward(s.chain.SubscribeBlocks())
+	case SubscribeNewPendingTransactions:
+		events, stop = forward(s.chain.SubscribeTransactions())
+	case SubscribeReorgs:
+		events, stop = forward(s.chain.SubscribeReorgs())
+	default:
+		writeResponse(w, errorResponse(req.ID, CodeInvalidParams, fmt.Sprintf("unknown subscription %q", kind)))
+		return
+	}
+	defer stop()
+
+	controller := http.NewResponseController(w)
+	encoder := json.NewEncoder(w)
+	write := func(v interface{}) error {
+		controller.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if err := encoder.Encode(v); err != nil {
+			return err
+		}
+		return controller.Flush()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := write(response{JSONRPC: "2.0", ID: req.ID, Result: kind}); err != nil {
+		return
+	}
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// The chain dropped the subscription for falling behind
+				params := notificationParams{Subscription: kind, Error: &Error{Code: CodeServerError, Message: stop().Error()}}
+				write(notification{JSONRPC: "2.0", Method: notificationMethod, Params: params})
+				return
+			}
+			params := notificationParams{Subscription: kind, Result: event}
+			if err := write(notification{JSONRPC: "2.0", Method: notificationMethod, Params: params}); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// forward encodes a chain subscription's events as JSON. The returned
+// function unsubscribes and reports why the events channel was closed.
+func forward[T any](sub *core.Subscription[T]) (<-chan json.RawMessage, func() error) {
+	events := make(chan json.RawMessage)
+	done := make(chan struct{})
+	go func() {
+		defer close(events)
+		for event := range sub.C {
+			raw, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			select {
+			case events <- raw:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return events, func() error {
+		once.Do(func() { close(done) })
+		err := sub.Err()
+		sub.Unsubscribe()
+		if err == nil {
+			err = fmt.Errorf("subscription closed")
+		}
+		return err
+	}
+}
+
+// Subscription delivers events streamed from a node on C, which is closed
+// when the stream ends. Err then reports why.
+type Subscription[T any] struct {
+	C <-chan T
+
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	err    error
+	done   chan struct{}
+}
+
+// Unsubscribe closes the stream. C is closed once it has shut down.
+func (s *Subscription[T]) Unsubscribe() {
+	s.cancel()
+	<-s.done
+}
+
+// Err returns why the stream ended, or nil if it is live or was unsubscribed
+func (s *Subscription[T]) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// SubscribeBlocks streams blocks as they join the node's canonical chain
+func (c *Client) SubscribeBlocks(ctx context.Context) (*Subscription[*core.Block], error) {
+	return subscribe[*core.Block](ctx, c, SubscribeNewBlocks)
+}
+
+// SubscribePendingTransactions streams transactions as the node accepts them
+// into its pending pool
+func (c *Client) SubscribePendingTransactions(ctx context.Context) (*Subscription[core.Transaction], error) {
+	return subscribe[core.Transaction](ctx, c, SubscribeNewPendingTransactions)
+}
+
+// SubscribeReorgs streams the node's chain reorganizations
+func (c *Client) SubscribeReorgs(ctx context.Context) (*Subscription[core.ReorgEvent], error) {
+	return subscribe[core.ReorgEvent](ctx, c, SubscribeReorgs)
+}
+
+// subscribe opens a sphere_subscribe stream and decodes its notifications.
+// The stream lasts until ctx is cancelled, Unsubscribe is called or the
+// node ends it. A reader that stops receiving from C eventually stalls the
+// stream, and the node drops it.
+func subscribe[T any](ctx context.Context, c *Client, kind string) (*Subscription[T], error) {
+	rawParams, err := json.Marshal([]interface{}{kind})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode params: %v", err)
+	}
+	id, _ := json.Marshal(c.nextID.Add(1))
+	body, err := json.Marshal(request{JSONRPC: "2.0", ID: id, Method: subscribeMethod, Params: rawParams})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// The stream outlives the client's request timeout
+	resp, err := c.stream.Do(req)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("%s failed: %v", subscribeMethod, err)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	var reply struct {
+		Error *Error `json:"error"`
+	}
+	if err := decoder.Decode(&reply); err != nil {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("%s returned an invalid response (%s): %v", subscribeMethod, resp.Status, err)
+	}
+	if reply.Error != nil {
+		resp.Body.Close()
+		cancel()
+		return nil, reply.Error
+	}
+
+	events := make(chan T)
+	sub := &Subscription[T]{C: events, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(sub.done)
+		defer close(events)
+		defer resp.Body.Close()
+
+		for {
+			var note notification
+			if err := decoder.Decode(&note); err != nil {
+				if ctx.Err() == nil {
+					sub.fail(fmt.Errorf("subscription stream failed: %v", err))
+				}
+				return
+			}
+			if note.Params.Error != nil {
+				sub.fail(note.Params.Error)
+				return
+			}
+
+			var event T
+			eventDecoder := json.NewDecoder(bytes.NewReader(note.Params.Result))
+			eventDecoder.UseNumber()
+			if err := eventDecoder.Decode(&event); err != nil {
+				sub.fail(fmt.Errorf("invalid %s event: %v", kind, err))
+				return
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+// fail records why the stream ended
+func (s *Subscription[T]) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
@@ -0,0 +1,90 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"0xygen.thesphere.online/blockchain/core"
+)
+
+// balanceTolerance absorbs float rounding when balances are summed
+const balanceTolerance = 1e-6
+
+// CheckConvergence returns an error unless every node has the same tip.
+// Branches of equal work never resolve on their own, so stop mining with
+// a final Mine on one node before checking.
+func (n *Network) CheckConvergence() error {
+	want := n.nodes[0].Chain.LastBlock()
+	for _, node := range n.nodes[1:] {
+		if tip := node.Chain.LastBlock(); tip.Hash != want.Hash {
+			return fmt.Errorf("node %d is at block %d (%s) but node 0 is at block %d (%s)",
+				node.ID, tip.Index, tip.Hash, want.Index, want.Hash)
+		}
+	}
+	return nil
+}
+
+// CheckBalances returns an error unless every node's chain re-validates
+// from genesis, replaying it gives the balances the node reports, those
+// balances add up to the coin supply, and nodes on the same tip agree
+func (n *Network) CheckBalances() error {
+	byTip := map[string]*Node{}
+	for _, node := range n.nodes {
+		blocks := node.Chain.GetBlocks(0)
+		if err := core.ValidateBlocks(n.config, blocks).Err(); err != nil {
+			return fmt.Errorf("node %d: chain is invalid: %v", node.ID, err)
+		}
+
+		replayed := core.NewAccountState()
+		for _, block := range blocks {
+			if err := replayed.ApplyBlock(block); err != nil {
+				return fmt.Errorf("node %d: replay failed: %v", node.ID, err)
+			}
+		}
+		balances := node.Chain.Balances()
+		if err := compareBalances(balances, replayed.Balances); err != nil {
+			return fmt.Errorf("node %d: reported balances differ from its chain: %v", node.ID, err)
+		}
+
+		var total float64
+		for _, balance := range balances {
+			total += balance
+		}
+		if supply := node.Chain.Supply(); math.Abs(total-supply) > balanceTolerance {
+			return fmt.Errorf("node %d: balances add up to %v but supply is %v", node.ID, total, supply)
+		}
+
+		tip := blocks[len(blocks)-1].Hash
+		if other, ok := byTip[tip]; ok {
+			if err := compareBalances(balances, other.Chain.Balances()); err != nil {
+				return fmt.Errorf("nodes %d and %d share a tip but not balances: %v", other.ID, node.ID, err)
+			}
+		} else {
+			byTip[tip] = node
+		}
+	}
+	return nil
+}
+
+// compareBalances reports the first address, in sorted order, whose
+// balance differs between two sets, treating missing ones as zero
+func compareBalances(a, b map[string]float64) error {
+	addresses := []string{}
+	for address := range a {
+		addresses = append(addresses, address)
+	}
+	for address := range b {
+		if _, ok := a[address]; !ok {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+
+	for _, address := range addresses {
+		if math.Abs(a[address]-b[address]) > balanceTolerance {
+			return fmt.Errorf("%s has %v, expected %v", address, a[address], b[address])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package sim
+
+import (
+	"time"
+
+	"0xygen.thesphere.online/blockchain/core"
+)
+
+// send delivers a message from one node to another after a random delay,
+// unless it is lost or a partition separates them when it is sent or
+// when it arrives
+func (n *Network) send(from, to int, deliver func()) {
+	if !n.connected(from, to) {
+		n.stats.Partitioned++
+		return
+	}
+	if n.opts.Loss > 0 && n.rng.Float64() < n.opts.Loss {
+		n.stats.Lost++
+		return
+	}
+
+	delay := n.opts.Latency
+	if n.opts.Jitter > 0 {
+		delay += time.Duration(n.rng.Int63n(int64(n.opts.Jitter) + 1))
+	}
+	n.After(delay, func() {
+		if !n.connected(from, to) {
+			n.stats.Partitioned++
+			return
+		}
+		n.stats.Delivered++
+		deliver()
+	})
+}
+
+// connected reports whether two nodes are in the same partition
+func (n *Network) connected(a, b int) bool {
+	return n.groups[a] == n.groups[b]
+}
+
+// broadcastBlock sends a block from a node to every other node but skip
+func (n *Network) broadcastBlock(from int, block *core.Block, skip int) {
+	// Peers get their own copy, as they would off the wire
+	data := core.EncodeBlock(block)
+	for _, node := range n.nodes {
+		if node.ID == from || node.ID == skip {
+			continue
+		}
+		to := node.ID
+		n.send(from, to, func() {
+			n.receiveBlock(to, from, data)
+		})
+	}
+}
+
+// receiveBlock adds a block to a node's chain and relays it if it was new.
+// A block whose parent the node does not have on its chain, because it is
+// unknown or only waiting as an orphan, makes the node sync from the
+// sender. Tip announcements retry a sync that lost a message.
+func (n *Network) receiveBlock(to, from int, data []byte) {
+	block, err := core.DecodeBlock(data)
+	if err != nil {
+		return
+	}
+
+	chain := n.nodes[to].Chain
+	err = chain.AddBlock(block)
+	if err == nil {
+		n.broadcastBlock(to, block, from)
+	}
+	if err == core.ErrUnknownParent ||
+		err == core.ErrKnownBlock && block.Index > 0 && chain.GetBlockByHash(block.PrevHash) == nil {
+		n.requestBlocks(to, from)
+	}
+}
+
+// requestBlocks asks a peer for its chain past the last block the two
+// have in common, found from a locator of the node's own chain
+func (n *Network) requestBlocks(to, from int) {
+	locator := n.nodes[to].Chain.Locator()
+	n.send(to, from, func() {
+		n.serveBlocks(from, to, locator)
+	})
+}
+
+// serveBlocks sends a peer the blocks after the highest locator entry on
+// the node's chain, up to core.MaxHeaders of them
+func (n *Network) serveBlocks(from, to int, locator []string) {
+	blocks, _ := n.nodes[from].Chain.BlocksAfter(locator, core.MaxHeaders)
+	if len(blocks) == 0 {
+		return
+	}
+	data := core.EncodeBlocks(blocks)
+	n.send(from, to, func() {
+		n.receiveBlocks(to, data)
+	})
+}
+
+// receiveBlocks adds a run of synced blocks to a node's chain in order
+func (n *Network) receiveBlocks(to int, data []byte) {
+	blocks, err := core.DecodeBlocks(data)
+	if err != nil {
+		return
+	}
+	for _, block := range blocks {
+		n.nodes[to].Chain.AddBlock(block)
+	}
+}
+
+// broadcastTransaction sends a transaction from a node to every other node but skip
+func (n *Network) broadcastTransaction(from int, tx core.Transaction, skip int) {
+	data := core.EncodeTransaction(&tx)
+	for _, node := range n.nodes {
+		if node.ID == from || node.ID == skip {
+			continue
+		}
+		to := node.ID
+		n.send(from, to, func() {
+			n.receiveTransaction(to, from, data)
+		})
+	}
+}
+
+// receiveTransaction adds a transaction to a node's pending pool and
+// relays it if it was accepted
+func (n *Network) receiveTransaction(to, from int, data []byte) {
+	tx, err := core.DecodeTransaction(data)
+	if err != nil {
+		return
+	}
+	if n.nodes[to].Chain.AddTransaction(*tx) == nil {
+		n.broadcastTransaction(to, *tx, from)
+	}
+}
@@ -0,0 +1,398 @@
+// Package sim runs several core chain nodes in one process, on a virtual
+// clock and a simulated network with latency, packet loss and partitions.
+// Everything is driven by one seeded random source and one event queue, so
+// a run with the same options and script always plays out the same way.
+// It lets forks, propagation delays and partitions be reproduced in tests.
+package sim
+
+import (
+	"container/heap"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+
+	"0xygen.thesphere.online/blockchain/core"
+)
+
+// defaultStart is the virtual time a simulation starts at unless told otherwise
+var defaultStart = time.Unix(1700000000, 0)
+
+// Options configure a simulation
+type Options struct {
+	// Nodes is the number of nodes
+	Nodes int
+	// Seed drives every random choice, so equal seeds give equal runs
+	Seed int64
+	// Config holds the consensus parameters every node shares. Engine must
+	// be nil, selecting proof-of-work; GenesisTimestamp defaults to Start.
+	Config core.Config
+	// Premine gives every node's account this balance at genesis
+	Premine float64
+	// Start is the virtual time the simulation starts at
+	Start time.Time
+	// Latency is the minimum one-way delay of a message
+	Latency time.Duration
+	// Jitter is the most random delay added to Latency
+	Jitter time.Duration
+	// Loss is the probability that a message is dropped
+	Loss float64
+	// BlockTime is the mean time between blocks across all mining nodes
+	BlockTime time.Duration
+	// AnnounceInterval is how often every node re-announces its tip, which
+	// is how nodes recover blocks lost to packet loss and partitions
+	AnnounceInterval time.Duration
+}
+
+// Stats counts what happened on the simulated network
+type Stats struct {
+	// Mined is the number of blocks mined, including ones later abandoned
+	Mined int
+	// Delivered is the number of messages that reached their node
+	Delivered int
+	// Lost is the number of messages dropped by random loss
+	Lost int
+	// Partitioned is the number of messages dropped by a partition
+	Partitioned int
+}
+
+// Clock is the virtual clock of a simulation. It only moves when the
+// network processes events.
+type Clock struct {
+	now time.Time
+}
+
+// Now returns the current virtual time
+func (c *Clock) Now() time.Time {
+	return c.now
+}
+
+// Node is one simulated node
+type Node struct {
+	// ID is the node's position in the network
+	ID int
+	// Chain is the node's own copy of the chain
+	Chain *core.Blockchain
+	// Address receives the node's mining rewards and sends its transfers
+	Address string
+
+	key    *secp256k1.PrivateKey
+	mining bool
+}
+
+// event is an action scheduled at a virtual time. seq breaks ties in the
+// order events were scheduled.
+type event struct {
+	at     time.Time
+	seq    uint64
+	action func()
+}
+
+// eventQueue orders events by time, then by scheduling order
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+
+func (q eventQueue) Less(i, j int) bool {
+	if !q[i].at.Equal(q[j].at) {
+		return q[i].at.Before(q[j].at)
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(*event)) }
+
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// Network is a running simulation. It is not safe for concurrent use;
+// scripts run as scheduled events on the same goroutine as everything else.
+type Network struct {
+	opts   Options
+	config core.Config
+	clock  *Clock
+	rng    *rand.Rand
+	nodes  []*Node
+
+	queue eventQueue
+	seq   uint64
+	// groups assigns each node a partition; nodes only reach their own group
+	groups []int
+	stats  Stats
+}
+
+// New creates a network of nodes sharing a genesis block. No node mines
+// until StartMining is called.
+func New(opts Options) (*Network, error) {
+	if opts.Nodes <= 0 {
+		return nil, fmt.Errorf("a simulation needs at least one node")
+	}
+	if opts.Config.Engine != nil {
+		return nil, fmt.Errorf("simulations only support the default proof-of-work engine")
+	}
+	if opts.Loss < 0 || opts.Loss >= 1 {
+		return nil, fmt.Errorf("loss must be in [0, 1)")
+	}
+	if opts.Start.IsZero() {
+		opts.Start = defaultStart
+	}
+	if opts.BlockTime <= 0 {
+		opts.BlockTime = 15 * time.Second
+	}
+	if opts.AnnounceInterval <= 0 {
+		opts.AnnounceInterval = 10 * time.Second
+	}
+
+	n := &Network{
+		opts:   opts,
+		clock:  &Clock{now: opts.Start},
+		rng:    rand.New(rand.NewSource(opts.Seed)),
+		groups: make([]int, opts.Nodes),
+	}
+
+	// Keys come from the seed so addresses, and so block hashes, repeat
+	keys := make([]*secp256k1.PrivateKey, opts.Nodes)
+	config := opts.Config
+	config.Alloc = map[string]float64{}
+	for address, amount := range opts.Config.Alloc {
+		config.Alloc[address] = amount
+	}
+	for i := range keys {
+		keys[i] = nodeKey(opts.Seed, i)
+		if opts.Premine > 0 {
+			config.Alloc[core.AddressFromPublicKey(keys[i].PubKey())] = opts.Premine
+		}
+	}
+	if config.GenesisTimestamp == 0 {
+		config.GenesisTimestamp = opts.Start.Unix()
+	}
+	config.Clock = n.clock
+	n.config = config
+
+	for i, key := range keys {
+		// Each node gets its own engine, searching nonces on one goroutine
+		// so the nonce it finds never depends on scheduling
+		config.Engine = core.NewPoW(config.RetargetInterval, config.TargetBlockTime)
+		config.Engine.(*core.PoW).SetThreads(1)
+		chain, err := core.OpenBlockchain(nil, config)
+		if err != nil {
+			return nil, fmt.Errorf("node %d: %v", i, err)
+		}
+		n.nodes = append(n.nodes, &Node{
+			ID:      i,
+			Chain:   chain,
+			Address: core.AddressFromPublicKey(key.PubKey()),
+			key:     key,
+		})
+	}
+
+	for _, node := range n.nodes {
+		n.scheduleAnnounce(node)
+	}
+	return n, nil
+}
+
+// nodeKey derives the key of node i from the seed
+func nodeKey(seed int64, i int) *secp256k1.PrivateKey {
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], uint64(seed))
+	binary.BigEndian.PutUint64(buf[8:], uint64(i))
+	hash := sha256.Sum256(buf[:])
+	return secp256k1.PrivKeyFromBytes(hash[:])
+}
+
+// Clock returns the virtual clock every node reads
+func (n *Network) Clock() *Clock {
+	return n.clock
+}
+
+// Now returns the current virtual time
+func (n *Network) Now() time.Time {
+	return n.clock.now
+}
+
+// Elapsed returns how much virtual time has passed since the start
+func (n *Network) Elapsed() time.Duration {
+	return n.clock.now.Sub(n.opts.Start)
+}
+
+// Nodes returns every node
+func (n *Network) Nodes() []*Node {
+	return n.nodes
+}
+
+// Node returns the node with the given ID
+func (n *Network) Node(id int) *Node {
+	return n.nodes[id]
+}
+
+// Stats returns what has happened on the network so far
+func (n *Network) Stats() Stats {
+	return n.stats
+}
+
+// At schedules an action at an offset from the start of the simulation,
+// for scripting partitions, transfers and checks
+func (n *Network) At(offset time.Duration, action func()) {
+	n.schedule(n.opts.Start.Add(offset), action)
+}
+
+// After schedules an action a delay after the current virtual time
+func (n *Network) After(delay time.Duration, action func()) {
+	n.schedule(n.clock.now.Add(delay), action)
+}
+
+// schedule queues an action at a virtual time, never in the past
+func (n *Network) schedule(at time.Time, action func()) {
+	if at.Before(n.clock.now) {
+		at = n.clock.now
+	}
+	n.seq++
+	heap.Push(&n.queue, &event{at: at, seq: n.seq, action: action})
+}
+
+// Run processes events until the virtual clock has advanced by d
+func (n *Network) Run(d time.Duration) {
+	n.RunUntil(n.clock.now.Add(d))
+}
+
+// RunUntil processes every event scheduled up to and including the given
+// virtual time, then sets the clock to it
+func (n *Network) RunUntil(end time.Time) {
+	for len(n.queue) > 0 && !n.queue[0].at.After(end) {
+		e := heap.Pop(&n.queue).(*event)
+		n.clock.now = e.at
+		e.action()
+	}
+	if end.After(n.clock.now) {
+		n.clock.now = end
+	}
+}
+
+// StartMining makes nodes mine, or every node if none are given
+func (n *Network) StartMining(ids ...int) {
+	for _, node := range n.selectNodes(ids) {
+		if node.mining {
+			continue
+		}
+		node.mining = true
+		n.scheduleMining(node)
+	}
+}
+
+// StopMining stops nodes mining, or every node if none are given
+func (n *Network) StopMining(ids ...int) {
+	for _, node := range n.selectNodes(ids) {
+		node.mining = false
+	}
+}
+
+// selectNodes returns the nodes with the given IDs, or all of them
+func (n *Network) selectNodes(ids []int) []*Node {
+	if len(ids) == 0 {
+		return n.nodes
+	}
+	nodes := make([]*Node, len(ids))
+	for i, id := range ids {
+		nodes[i] = n.nodes[id]
+	}
+	return nodes
+}
+
+// scheduleMining queues a node's next block. Each node finds blocks as a
+// Poisson process, so the network as a whole averages one per BlockTime.
+func (n *Network) scheduleMining(node *Node) {
+	mean := float64(n.opts.BlockTime) * float64(len(n.nodes))
+	delay := time.Duration(n.rng.ExpFloat64() * mean)
+	n.After(delay, func() {
+		if !node.mining {
+			return
+		}
+		n.Mine(node.ID)
+		n.scheduleMining(node)
+	})
+}
+
+// Mine has a node seal a block on its tip right away and announce it.
+// After mining stops, one more block from a single node breaks any tie
+// between equally heavy branches so the network can converge.
+func (n *Network) Mine(id int) (*core.Block, error) {
+	node := n.nodes[id]
+	block, err := node.Chain.MineBlock(context.Background(), node.Address)
+	if err != nil {
+		return nil, err
+	}
+	n.stats.Mined++
+	n.broadcastBlock(node.ID, block, -1)
+	return block, nil
+}
+
+// scheduleAnnounce re-announces a node's tip every AnnounceInterval
+func (n *Network) scheduleAnnounce(node *Node) {
+	n.After(n.opts.AnnounceInterval, func() {
+		n.broadcastBlock(node.ID, node.Chain.LastBlock(), -1)
+		n.scheduleAnnounce(node)
+	})
+}
+
+// Partition splits the network so nodes only reach others in their own
+// group. Nodes left out of every group form one more group together.
+func (n *Network) Partition(groups ...[]int) {
+	for i := range n.groups {
+		n.groups[i] = 0
+	}
+	for g, group := range groups {
+		for _, id := range group {
+			n.groups[id] = g + 1
+		}
+	}
+}
+
+// Heal reconnects every node
+func (n *Network) Heal() {
+	n.Partition()
+}
+
+// Transfer has one node pay another from its own account, with the next
+// free nonce, and gossips the transaction
+func (n *Network) Transfer(from, to int, amount, fee float64) (core.Transaction, error) {
+	sender := n.nodes[from]
+	tx := core.Transaction{
+		To:        n.nodes[to].Address,
+		Amount:    amount,
+		Fee:       fee,
+		Nonce:     nextNonce(sender),
+		ChainID:   n.config.ChainID,
+		Timestamp: n.clock.now.Unix(),
+	}
+	if err := core.SignTransaction(&tx, sender.key); err != nil {
+		return tx, err
+	}
+	if err := sender.Chain.AddTransaction(tx); err != nil {
+		return tx, err
+	}
+	n.broadcastTransaction(from, tx, -1)
+	return tx, nil
+}
+
+// nextNonce returns the first nonce after a node's confirmed and pending
+// transactions
+func nextNonce(node *Node) uint64 {
+	nonce := node.Chain.GetNonce(node.Address)
+	for _, tx := range node.Chain.Pending() {
+		if tx.From == node.Address && tx.Nonce >= nonce {
+			nonce = tx.Nonce + 1
+		}
+	}
+	return nonce
+}
@@ -0,0 +1,96 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"0xygen.thesphere.online/blockchain/core"
+)
+
+// script runs five lossy nodes through transfers, a partition and a heal,
+// then stops mining and breaks any tie with one last block
+func script(t *testing.T, seed int64) *Network {
+	t.Helper()
+	config := core.DefaultConfig()
+	config.RetargetInterval = 0
+	config.CoinbaseMaturity = 3
+	network, err := New(Options{
+		Nodes:     5,
+		Seed:      seed,
+		Config:    config,
+		Premine:   100,
+		Latency:   200 * time.Millisecond,
+		Jitter:    2 * time.Second,
+		Loss:      0.1,
+		BlockTime: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	network.StartMining()
+	network.At(30*time.Second, func() {
+		if _, err := network.Transfer(0, 1, 5, 0.1); err != nil {
+			t.Error(err)
+		}
+		if _, err := network.Transfer(0, 2, 5, 0.1); err != nil {
+			t.Error(err)
+		}
+	})
+	network.At(60*time.Second, func() { network.Partition([]int{0, 1}, []int{2, 3, 4}) })
+	network.At(70*time.Second, func() {
+		if _, err := network.Transfer(3, 4, 7, 0.1); err != nil {
+			t.Error(err)
+		}
+	})
+	network.At(200*time.Second, network.Heal)
+	network.At(260*time.Second, func() { network.StopMining() })
+	network.At(280*time.Second, func() {
+		if _, err := network.Mine(1); err != nil {
+			t.Error(err)
+		}
+	})
+	network.Run(400 * time.Second)
+	return network
+}
+
+func TestSimulationIsDeterministic(t *testing.T) {
+	first, second := script(t, 42), script(t, 42)
+	if first.Stats() != second.Stats() {
+		t.Fatalf("runs with one seed gave stats %+v and %+v", first.Stats(), second.Stats())
+	}
+	for i, node := range first.Nodes() {
+		if tip, other := node.Chain.LastBlock().Hash, second.Node(i).Chain.LastBlock().Hash; tip != other {
+			t.Fatalf("node %d ended at %s in one run and %s in the other", i, tip, other)
+		}
+	}
+
+	if other := script(t, 7); other.Node(0).Chain.LastBlock().Hash == first.Node(0).Chain.LastBlock().Hash {
+		t.Fatal("another seed played out the same way")
+	}
+}
+
+func TestSimulationConverges(t *testing.T) {
+	for seed := int64(1); seed <= 10; seed++ {
+		network := script(t, seed)
+		if err := network.CheckConvergence(); err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		if err := network.CheckBalances(); err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		stats := network.Stats()
+		if stats.Lost == 0 || stats.Partitioned == 0 {
+			t.Fatalf("seed %d dropped no messages: %+v", seed, stats)
+		}
+
+		// Every transfer reaches the final chain, even the one sent inside
+		// a partition
+		if nonce := network.Node(0).Chain.GetNonce(network.Node(0).Address); nonce != 2 {
+			t.Fatalf("seed %d: node 0 has %d confirmed transfers, want 2", seed, nonce)
+		}
+		if nonce := network.Node(3).Chain.GetNonce(network.Node(3).Address); nonce != 1 {
+			t.Fatalf("seed %d: node 3 has %d confirmed transfers, want 1", seed, nonce)
+		}
+	}
+}
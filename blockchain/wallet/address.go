@@ -0,0 +1,67 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// addressLength is the number of bytes in a chain address
+const addressLength = 20
+
+// ChecksumAddress returns the mixed-case form of an address. As in EIP-55,
+// each hex letter is upper-cased when the matching nibble of the Keccak-256
+// hash of the lowercase address is 8 or more, so typos are detectable.
+func ChecksumAddress(address string) string {
+	lower := strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(address, "0x"), "0X"))
+
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write([]byte(lower))
+	hash := hasher.Sum(nil)
+
+	out := []byte(lower)
+	for i, c := range out {
+		if c < 'a' || c > 'f' {
+			continue
+		}
+		nibble := hash[i/2]
+		if i%2 == 0 {
+			nibble >>= 4
+		}
+		if nibble&0x0f >= 8 {
+			out[i] = c - 'a' + 'A'
+		}
+	}
+	return "0x" + string(out)
+}
+
+// ParseAddress checks an address and returns the lowercase form used on
+// chain. All-lowercase and all-uppercase addresses are accepted as is;
+// mixed-case addresses must carry a valid checksum.
+func ParseAddress(address string) (string, error) {
+	if !strings.HasPrefix(address, "0x") && !strings.HasPrefix(address, "0X") {
+		return "", fmt.Errorf("address %q must start with 0x", address)
+	}
+	body := address[2:]
+	if len(body) != 2*addressLength {
+		return "", fmt.Errorf("address %q must have %d hex digits", address, 2*addressLength)
+	}
+	if _, err := hex.DecodeString(body); err != nil {
+		return "", fmt.Errorf("address %q is not hex", address)
+	}
+
+	lower := "0x" + strings.ToLower(body)
+	mixed := body != strings.ToLower(body) && body != strings.ToUpper(body)
+	if mixed && ChecksumAddress(lower) != "0x"+body {
+		return "", fmt.Errorf("address %q has an invalid checksum", address)
+	}
+	return lower, nil
+}
+
+// IsValidAddress reports whether ParseAddress accepts the address
+func IsValidAddress(address string) bool {
+	_, err := ParseAddress(address)
+	return err == nil
+}
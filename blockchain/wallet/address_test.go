@@ -0,0 +1,48 @@
+package wallet
+
+import (
+	"strings"
+	"testing"
+)
+
+// checksummed is an EIP-55 test vector, which this chain's checksum follows
+const checksummed = "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+
+func TestChecksumAddress(t *testing.T) {
+	if got := ChecksumAddress(strings.ToLower(checksummed)); got != checksummed {
+		t.Fatalf("checksum %s, want %s", got, checksummed)
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	lower := strings.ToLower(checksummed)
+	for _, address := range []string{
+		checksummed,
+		lower,
+		"0x" + strings.ToUpper(lower[2:]),
+		"0X" + lower[2:],
+	} {
+		parsed, err := ParseAddress(address)
+		if err != nil {
+			t.Errorf("%s: %v", address, err)
+		} else if parsed != lower {
+			t.Errorf("%s parsed as %s, want %s", address, parsed, lower)
+		}
+	}
+
+	// Changing a digit or the case of one letter breaks the checksum
+	for name, address := range map[string]string{
+		"wrong case":    strings.Replace(checksummed, "aA", "AA", 1),
+		"wrong digit":   strings.Replace(checksummed, "5aA", "6aA", 1),
+		"no prefix":     lower[2:],
+		"short":         lower[:len(lower)-2],
+		"long":          lower + "00",
+		"not hex":       "0x" + strings.Repeat("g", 40),
+		"empty":         "",
+		"only a prefix": "0x",
+	} {
+		if IsValidAddress(address) {
+			t.Errorf("%s address %s accepted", name, address)
+		}
+	}
+}
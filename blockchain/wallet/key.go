@@ -0,0 +1,96 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+
+	"0xygen.thesphere.online/blockchain/core"
+)
+
+// Key is an unlocked secp256k1 private key and the address it controls
+type Key struct {
+	Address    string
+	PrivateKey *secp256k1.PrivateKey
+}
+
+// GenerateKey creates a new random key
+func GenerateKey() (*Key, error) {
+	privateKey, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+	return newKey(privateKey), nil
+}
+
+// KeyFromHex parses a hex-encoded 32-byte private key
+func KeyFromHex(s string) (*Key, error) {
+	raw, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(s), "0x"))
+	if err != nil || len(raw) != secp256k1.PrivKeyBytesLen {
+		return nil, fmt.Errorf("private key must be %d hex-encoded bytes", secp256k1.PrivKeyBytesLen)
+	}
+
+	privateKey := secp256k1.PrivKeyFromBytes(raw)
+	if privateKey.Key.IsZero() {
+		return nil, fmt.Errorf("private key cannot be zero")
+	}
+	return newKey(privateKey), nil
+}
+
+// newKey wraps a private key with its address
+func newKey(privateKey *secp256k1.PrivateKey) *Key {
+	return &Key{
+		Address:    core.AddressFromPublicKey(privateKey.PubKey()),
+		PrivateKey: privateKey,
+	}
+}
+
+// Hex returns the hex-encoded private key. Handle it with care.
+func (k *Key) Hex() string {
+	return hex.EncodeToString(k.PrivateKey.Serialize())
+}
+
+// PublicKey returns the hex-encoded compressed public key, which is what
+// multisig accounts are made of
+func (k *Key) PublicKey() string {
+	return hex.EncodeToString(k.PrivateKey.PubKey().SerializeCompressed())
+}
+
+// SignTransaction signs tx as this key's address. The recipient is
+// normalised to its on-chain form first, since the signature covers it.
+func (k *Key) SignTransaction(tx *core.Transaction) error {
+	if err := normalizeAddresses(tx); err != nil {
+		return err
+	}
+	return core.SignTransaction(tx, k.PrivateKey)
+}
+
+// CosignTransaction adds this key's signature to a transaction from a
+// multisig account the key belongs to
+func (k *Key) CosignTransaction(tx *core.Transaction) error {
+	if err := normalizeAddresses(tx); err != nil {
+		return err
+	}
+	return core.CosignTransaction(tx, k.PrivateKey)
+}
+
+// normalizeAddresses puts the sender and recipient in their on-chain form
+func normalizeAddresses(tx *core.Transaction) error {
+	if tx.To != "" && tx.To != core.SystemAddress {
+		to, err := ParseAddress(tx.To)
+		if err != nil {
+			return fmt.Errorf("invalid recipient: %v", err)
+		}
+		tx.To = to
+	}
+	if tx.From != "" {
+		from, err := ParseAddress(tx.From)
+		if err != nil {
+			return fmt.Errorf("invalid sender: %v", err)
+		}
+		tx.From = from
+	}
+	return nil
+}
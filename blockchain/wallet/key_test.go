@@ -0,0 +1,44 @@
+package wallet
+
+import (
+	"strings"
+	"testing"
+
+	"0xygen.thesphere.online/blockchain/core"
+)
+
+func TestKeySignTransaction(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := KeyFromHex(key.Hex())
+	if err != nil || parsed.Address != key.Address {
+		t.Fatalf("key from hex has address %v: %v", parsed, err)
+	}
+
+	// A checksummed recipient is signed in its on-chain form
+	tx := core.Transaction{To: checksummed, Amount: 1, Fee: 0.1, Timestamp: 1700000000}
+	if err := key.SignTransaction(&tx); err != nil {
+		t.Fatal(err)
+	}
+	if tx.From != key.Address || tx.To != strings.ToLower(checksummed) {
+		t.Fatalf("signed transaction from %s to %s", tx.From, tx.To)
+	}
+	if err := core.VerifyTransactionID(&tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.VerifyTransactionSignature(&tx); err != nil {
+		t.Fatal(err)
+	}
+
+	tx.Amount = 2
+	if core.VerifyTransactionID(&tx) == nil {
+		t.Fatal("altered transaction kept its ID")
+	}
+
+	bad := core.Transaction{To: strings.Replace(checksummed, "aA", "AA", 1), Amount: 1}
+	if err := key.SignTransaction(&bad); err == nil {
+		t.Fatal("signed a transaction to a mistyped address")
+	}
+}
@@ -0,0 +1,340 @@
+package wallet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Scrypt cost parameters. The standard ones take about a second per unlock;
+// the light ones are for tests and throwaway development keys.
+const (
+	StandardScryptN = 1 << 18
+	StandardScryptP = 1
+	LightScryptN    = 1 << 12
+	LightScryptP    = 6
+
+	scryptR     = 8
+	scryptDKLen = 32
+
+	keystoreVersion = 1
+)
+
+var (
+	// ErrNoAccount is returned when the keystore holds no key for an address
+	ErrNoAccount = errors.New("no key for the given address")
+	// ErrDecrypt is returned when a keystore file cannot be opened with the passphrase
+	ErrDecrypt = errors.New("could not decrypt key with the given passphrase")
+	// ErrAccountExists is returned when importing a key that is already stored
+	ErrAccountExists = errors.New("account already exists")
+)
+
+// Account is a key stored in a keystore
+type Account struct {
+	Address string `json:"address"`
+	File    string `json:"file"`
+}
+
+// encryptedKey is the JSON layout of a keystore file
+type encryptedKey struct {
+	Address string     `json:"address"`
+	Version int        `json:"version"`
+	Crypto  cryptoJSON `json:"crypto"`
+}
+
+// cryptoJSON holds the encrypted private key and how to decrypt it
+type cryptoJSON struct {
+	Cipher       string       `json:"cipher"`
+	CipherText   string       `json:"ciphertext"`
+	CipherParams cipherParams `json:"cipherparams"`
+	KDF          string       `json:"kdf"`
+	KDFParams    scryptParams `json:"kdfparams"`
+	MAC          string       `json:"mac"`
+}
+
+// cipherParams holds the AES-CTR initialisation vector
+type cipherParams struct {
+	IV string `json:"iv"`
+}
+
+// scryptParams holds the key derivation settings
+type scryptParams struct {
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	DKLen int    `json:"dklen"`
+	Salt  string `json:"salt"`
+}
+
+// Keystore keeps passphrase-encrypted keys as one file per account in a directory
+type Keystore struct {
+	dir     string
+	scryptN int
+	scryptP int
+}
+
+// NewKeystore opens (or creates) a keystore directory. New keys are
+// encrypted with the given scrypt cost parameters.
+func NewKeystore(dir string, scryptN, scryptP int) (*Keystore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create keystore directory: %v", err)
+	}
+	return &Keystore{dir: dir, scryptN: scryptN, scryptP: scryptP}, nil
+}
+
+// NewAccount generates a key and stores it encrypted with passphrase
+func (ks *Keystore) NewAccount(passphrase string) (Account, error) {
+	key, err := GenerateKey()
+	if err != nil {
+		return Account{}, err
+	}
+	return ks.store(key, passphrase)
+}
+
+// Import stores an existing key encrypted with passphrase
+func (ks *Keystore) Import(key *Key, passphrase string) (Account, error) {
+	if _, err := ks.Find(key.Address); err == nil {
+		return Account{}, ErrAccountExists
+	}
+	return ks.store(key, passphrase)
+}
+
+// ImportFile imports a keystore file from elsewhere, checking that
+// passphrase opens it
+func (ks *Keystore) ImportFile(path, passphrase string) (Account, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Account{}, fmt.Errorf("failed to read key file: %v", err)
+	}
+	key, err := DecryptKey(data, passphrase)
+	if err != nil {
+		return Account{}, err
+	}
+	return ks.Import(key, passphrase)
+}
+
+// Accounts lists every account in the keystore, ordered by address
+func (ks *Keystore) Accounts() ([]Account, error) {
+	entries, err := os.ReadDir(ks.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keystore: %v", err)
+	}
+
+	accounts := []Account{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(ks.dir, entry.Name())
+		address, err := readAddress(path)
+		if err != nil {
+			// Skip anything that is not a key file
+			continue
+		}
+		accounts = append(accounts, Account{Address: address, File: path})
+	}
+
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].Address < accounts[j].Address
+	})
+	return accounts, nil
+}
+
+// Find returns the stored account for an address
+func (ks *Keystore) Find(address string) (Account, error) {
+	address, err := ParseAddress(address)
+	if err != nil {
+		return Account{}, err
+	}
+
+	accounts, err := ks.Accounts()
+	if err != nil {
+		return Account{}, err
+	}
+	for _, account := range accounts {
+		if account.Address == address {
+			return account, nil
+		}
+	}
+	return Account{}, ErrNoAccount
+}
+
+// Unlock decrypts the key of an address with passphrase
+func (ks *Keystore) Unlock(address, passphrase string) (*Key, error) {
+	account, err := ks.Find(address)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(account.File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+	return DecryptKey(data, passphrase)
+}
+
+// store encrypts a key into a new file named after its address
+func (ks *Keystore) store(key *Key, passphrase string) (Account, error) {
+	data, err := EncryptKey(key, passphrase, ks.scryptN, ks.scryptP)
+	if err != nil {
+		return Account{}, err
+	}
+
+	name := fmt.Sprintf("UTC--%s--%s", time.Now().UTC().Format("2006-01-02T15-04-05.000000000Z"),
+		strings.TrimPrefix(key.Address, "0x"))
+	path := filepath.Join(ks.dir, name)
+
+	// Write to a temporary file first so a crash never leaves half a key behind
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return Account{}, fmt.Errorf("failed to write key file: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return Account{}, fmt.Errorf("failed to write key file: %v", err)
+	}
+
+	return Account{Address: key.Address, File: path}, nil
+}
+
+// EncryptKey encrypts a key with passphrase into keystore JSON. The key is
+// encrypted with AES-128-CTR under a scrypt-derived key, and authenticated
+// with a SHA-256 MAC over the second half of the derived key and the ciphertext.
+func EncryptKey(key *Key, passphrase string, scryptN, scryptP int) ([]byte, error) {
+	salt := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	derived, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, scryptDKLen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %v", err)
+	}
+
+	cipherText, err := aesCTR(derived[:16], iv, key.PrivateKey.Serialize())
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(encryptedKey{
+		Address: strings.TrimPrefix(key.Address, "0x"),
+		Version: keystoreVersion,
+		Crypto: cryptoJSON{
+			Cipher:       "aes-128-ctr",
+			CipherText:   hex.EncodeToString(cipherText),
+			CipherParams: cipherParams{IV: hex.EncodeToString(iv)},
+			KDF:          "scrypt",
+			KDFParams: scryptParams{
+				N:     scryptN,
+				R:     scryptR,
+				P:     scryptP,
+				DKLen: scryptDKLen,
+				Salt:  hex.EncodeToString(salt),
+			},
+			MAC: hex.EncodeToString(keystoreMAC(derived, cipherText)),
+		},
+	}, "", "  ")
+}
+
+// DecryptKey opens keystore JSON with passphrase
+func DecryptKey(data []byte, passphrase string) (*Key, error) {
+	var stored encryptedKey
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("invalid key file: %v", err)
+	}
+	if stored.Version != keystoreVersion {
+		return nil, fmt.Errorf("unsupported key file version %d", stored.Version)
+	}
+	c := stored.Crypto
+	if c.Cipher != "aes-128-ctr" || c.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported key encryption %s/%s", c.Cipher, c.KDF)
+	}
+
+	salt, err := hex.DecodeString(c.KDFParams.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file salt")
+	}
+	iv, err := hex.DecodeString(c.CipherParams.IV)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file iv")
+	}
+	cipherText, err := hex.DecodeString(c.CipherText)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file ciphertext")
+	}
+	mac, err := hex.DecodeString(c.MAC)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file mac")
+	}
+
+	p := c.KDFParams
+	derived, err := scrypt.Key([]byte(passphrase), salt, p.N, p.R, p.P, p.DKLen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %v", err)
+	}
+	if len(derived) < 32 || subtle.ConstantTimeCompare(keystoreMAC(derived, cipherText), mac) != 1 {
+		return nil, ErrDecrypt
+	}
+
+	plain, err := aesCTR(derived[:16], iv, cipherText)
+	if err != nil {
+		return nil, err
+	}
+	key, err := KeyFromHex(hex.EncodeToString(plain))
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimPrefix(key.Address, "0x") != strings.ToLower(stored.Address) {
+		return nil, fmt.Errorf("key file address %s does not match its key", stored.Address)
+	}
+	return key, nil
+}
+
+// readAddress reads the address of a keystore file without decrypting it
+func readAddress(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var stored encryptedKey
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return "", err
+	}
+	return ParseAddress("0x" + stored.Address)
+}
+
+// aesCTR encrypts or decrypts data with AES in counter mode
+func aesCTR(key, iv, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	cipher.NewCTR(block, iv).XORKeyStream(out, data)
+	return out, nil
+}
+
+// keystoreMAC authenticates the ciphertext under the derived key
+func keystoreMAC(derived, cipherText []byte) []byte {
+	h := sha256.New()
+	h.Write(derived[16:32])
+	h.Write(cipherText)
+	return h.Sum(nil)
+}
@@ -0,0 +1,112 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptKeyRoundTrip(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := EncryptKey(key, "correct horse", LightScryptN, LightScryptP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := DecryptKey(data, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.Address != key.Address || decrypted.Hex() != key.Hex() {
+		t.Fatalf("decrypted key for %s, want %s", decrypted.Address, key.Address)
+	}
+
+	if _, err := DecryptKey(data, "wrong horse"); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("wrong passphrase gave %v", err)
+	}
+
+	// Any change to the ciphertext or its MAC fails authentication
+	for _, field := range []string{"ciphertext", "mac"} {
+		var stored map[string]interface{}
+		if err := json.Unmarshal(data, &stored); err != nil {
+			t.Fatal(err)
+		}
+		crypto := stored["crypto"].(map[string]interface{})
+		raw, err := hex.DecodeString(crypto[field].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw[0] ^= 1
+		crypto[field] = hex.EncodeToString(raw)
+		tampered, err := json.Marshal(stored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := DecryptKey(tampered, "correct horse"); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("tampered %s gave %v", field, err)
+		}
+	}
+}
+
+func TestKeystoreImport(t *testing.T) {
+	ks, err := NewKeystore(t.TempDir(), LightScryptN, LightScryptP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account, err := ks.Import(key, "new passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Address != key.Address {
+		t.Fatalf("imported account %s, want %s", account.Address, key.Address)
+	}
+	if _, err := ks.Import(key, "new passphrase"); !errors.Is(err, ErrAccountExists) {
+		t.Fatalf("second import gave %v", err)
+	}
+
+	// The stored file is encrypted under the passphrase given on import
+	if _, err := ks.Unlock(key.Address, "old passphrase"); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("unlock with another passphrase gave %v", err)
+	}
+	unlocked, err := ks.Unlock(key.Address, "new passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unlocked.Hex() != key.Hex() {
+		t.Fatal("unlocked a different key")
+	}
+
+	// A key file from elsewhere is re-encrypted the same way
+	data, err := EncryptKey(key, "old passphrase", LightScryptN, LightScryptP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewKeystore(t.TempDir(), LightScryptN, LightScryptP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.ImportFile(path, "wrong passphrase"); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("import with the wrong passphrase gave %v", err)
+	}
+	if _, err := other.ImportFile(path, "old passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Unlock(key.Address, "old passphrase"); err != nil {
+		t.Fatal(err)
+	}
+}